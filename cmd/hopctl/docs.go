@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"hopcolony.io/hopcolony/docs"
+)
+
+type document struct {
+	Index   string                 `json:"_index"`
+	Id      string                 `json:"_id"`
+	Version int                    `json:"_version,omitempty"`
+	Source  map[string]interface{} `json:"_source"`
+}
+
+func newDocument(doc *docs.Document) document {
+	return document{doc.Index, doc.Id, doc.Version, doc.Source}
+}
+
+func snapshotError(snapshot docs.DocumentSnapshot) error {
+	if snapshot.Success {
+		return nil
+	}
+	return errors.New(snapshot.Reason)
+}
+
+func docsGet(e *env, args []string) error {
+	if len(args) != 2 {
+		return InvalidUsage
+	}
+	db, err := e.connect()
+	if err != nil {
+		return err
+	}
+	snapshot := db.Index(args[0]).Document(args[1]).Get()
+	if err := snapshotError(snapshot); err != nil {
+		return err
+	}
+	return e.print(newDocument(snapshot.Doc))
+}
+
+func docsSet(e *env, args []string) error {
+	if len(args) != 3 {
+		return InvalidUsage
+	}
+	data, err := e.readData(args[2])
+	if err != nil {
+		return err
+	}
+	db, err := e.connect()
+	if err != nil {
+		return err
+	}
+	snapshot := db.Index(args[0]).Document(args[1]).SetData(data)
+	if err := snapshotError(snapshot); err != nil {
+		return err
+	}
+	return e.print(newDocument(snapshot.Doc))
+}
+
+func docsUpdate(e *env, args []string) error {
+	if len(args) < 3 {
+		return InvalidUsage
+	}
+	updates := make([]docs.UpdateData, 0, len(args)-2)
+	for _, arg := range args[2:] {
+		parts := strings.SplitN(arg, "=", 2)
+		if len(parts) != 2 {
+			return InvalidUsage
+		}
+		updates = append(updates, docs.UpdateData{Key: parts[0], Value: parseValue(parts[1])})
+	}
+	db, err := e.connect()
+	if err != nil {
+		return err
+	}
+	snapshot := db.Index(args[0]).Document(args[1]).Update(updates)
+	if err := snapshotError(snapshot); err != nil {
+		return err
+	}
+	return e.print(newDocument(snapshot.Doc))
+}
+
+func docsDelete(e *env, args []string) error {
+	if len(args) != 2 {
+		return InvalidUsage
+	}
+	db, err := e.connect()
+	if err != nil {
+		return err
+	}
+	if err := snapshotError(db.Index(args[0]).Document(args[1]).Delete()); err != nil {
+		return err
+	}
+	fmt.Fprintf(e.stdout, "Document %s deleted from %s\n", args[1], args[0])
+	return nil
+}
+
+type whereFlags []string
+
+func (w *whereFlags) String() string {
+	return strings.Join(*w, ", ")
+}
+
+func (w *whereFlags) Set(value string) error {
+	*w = append(*w, value)
+	return nil
+}
+
+func docsQuery(e *env, args []string) error {
+	var wheres whereFlags
+	var limit int
+	args, err := parseFlags("query", args, func(flags *flag.FlagSet) {
+		flags.Var(&wheres, "where", "")
+		flags.IntVar(&limit, "limit", 100, "")
+	})
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return InvalidUsage
+	}
+	db, err := e.connect()
+	if err != nil {
+		return err
+	}
+
+	index := db.Index(args[0]).Limit(limit)
+	for _, where := range wheres {
+		parts := strings.Fields(where)
+		if len(parts) < 3 {
+			return fmt.Errorf("invalid condition %q, expected \"field operator value\"", where)
+		}
+		rest := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(where), parts[0]))
+		value := strings.TrimSpace(strings.TrimPrefix(rest, parts[1]))
+		index = index.Where(parts[0], parts[1], parseValue(value))
+	}
+
+	snapshot := index.Get()
+	if !snapshot.Success {
+		return errors.New(snapshot.Reason)
+	}
+	result := make([]document, 0, len(snapshot.Docs))
+	for idx := range snapshot.Docs {
+		result = append(result, newDocument(&snapshot.Docs[idx]))
+	}
+	return e.print(result)
+}
+
+// docsImport reads NDJSON lines, either exported documents with "_id" and
+// "_source" or plain objects that get a generated id, and writes them in
+// _bulk requests.
+func docsImport(e *env, args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return InvalidUsage
+	}
+	in := e.stdin
+	if len(args) == 2 && args[1] != "-" {
+		file, err := os.Open(args[1])
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		in = file
+	}
+	db, err := e.connect()
+	if err != nil {
+		return err
+	}
+
+	writer := db.Index(args[0]).BulkWriter()
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var doc document
+		if err := json.Unmarshal(scanner.Bytes(), &doc); err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+		if doc.Source == nil {
+			if err := json.Unmarshal(scanner.Bytes(), &doc.Source); err != nil {
+				return fmt.Errorf("line %d: %v", line, err)
+			}
+			doc.Id = ""
+		}
+
+		if err := writer.Set(doc.Id, doc.Source); err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Imported %d documents into %s\n", writer.Written(), args[0])
+	return nil
+}
+
+func docsExport(e *env, args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return InvalidUsage
+	}
+	out := e.stdout
+	if len(args) == 2 && args[1] != "-" {
+		file, err := os.Create(args[1])
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+	db, err := e.connect()
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(out)
+	encoder := json.NewEncoder(w)
+	exported := 0
+	err = db.Index(args[0]).ForEach(func(doc *docs.Document) error {
+		exported++
+		return encoder.Encode(newDocument(doc))
+	})
+	if err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Exported %d documents from %s\n", exported, args[0])
+	return nil
+}
+
+func (e *env) readData(arg string) (map[string]interface{}, error) {
+	var b []byte
+	var err error
+	switch {
+	case arg == "-":
+		b, err = ioutil.ReadAll(e.stdin)
+	case strings.HasPrefix(arg, "@"):
+		b, err = ioutil.ReadFile(arg[1:])
+	default:
+		b = []byte(arg)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var data map[string]interface{}
+	if err := json.Unmarshal(b, &data); err != nil {
+		return nil, fmt.Errorf("document must be a JSON object: %v", err)
+	}
+	return data, nil
+}
+
+// parseValue reads a command line value as JSON, falling back to a string.
+func parseValue(value string) interface{} {
+	var v interface{}
+	if err := json.Unmarshal([]byte(value), &v); err == nil {
+		return v
+	}
+	return value
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"hopcolony.io/hopcolony/docs"
+)
+
+// bulkStore keeps the documents written through _bulk, in order, and serves
+// them back to scrolls in a single page.
+type bulkStore struct {
+	ids     []string
+	sources map[string]json.RawMessage
+}
+
+func newBulkStore() (*bulkStore, *env) {
+	store := &bulkStore{sources: make(map[string]json.RawMessage)}
+	db := &docs.HopDoc{}
+	db.Use(store.intercept)
+	return store, &env{stdout: &bytes.Buffer{}, db: db}
+}
+
+func (s *bulkStore) intercept(req *docs.Request, next docs.Handler) (*docs.Response, error) {
+	body := `{}`
+	switch path := strings.SplitN(req.Path, "?", 2)[0]; {
+	case path == "/_bulk":
+		items := make([]string, 0)
+		scanner := bufio.NewScanner(bytes.NewReader(req.Body))
+		for scanner.Scan() {
+			var action struct {
+				Index struct {
+					Id string `json:"_id"`
+				} `json:"index"`
+			}
+			json.Unmarshal(scanner.Bytes(), &action)
+			scanner.Scan()
+			id := action.Index.Id
+			if id == "" {
+				id = fmt.Sprintf("generated%d", len(s.ids))
+			}
+			if _, ok := s.sources[id]; !ok {
+				s.ids = append(s.ids, id)
+			}
+			s.sources[id] = append(json.RawMessage(nil), scanner.Bytes()...)
+			items = append(items, fmt.Sprintf(`{"index":{"_id":%q,"status":201}}`, id))
+		}
+		body = `{"errors":false,"items":[` + strings.Join(items, ",") + `]}`
+	case strings.HasSuffix(path, "/_search"):
+		hits := make([]string, 0, len(s.ids))
+		for _, id := range s.ids {
+			hits = append(hits, fmt.Sprintf(`{"_index":"products","_id":%q,"_version":1,"_source":%s}`, id, s.sources[id]))
+		}
+		body = `{"_scroll_id":"s1","hits":{"hits":[` + strings.Join(hits, ",") + `]}}`
+	case path == "/_search/scroll" && req.Method == http.MethodPost:
+		body = `{"_scroll_id":"s1","hits":{"hits":[]}}`
+	}
+	return &docs.Response{StatusCode: http.StatusOK, Status: http.StatusText(http.StatusOK), Body: []byte(body)}, nil
+}
+
+func TestDocsImportExport(t *testing.T) {
+	store, e := newBulkStore()
+	file := filepath.Join(t.TempDir(), "products.ndjson")
+	ndjson := `{"_index":"old","_id":"a","_version":3,"_source":{"name":"shoe","stock":2}}
+
+{"name":"sock"}
+`
+	if err := ioutil.WriteFile(file, []byte(ndjson), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := docsImport(e, []string{"products", file}); err != nil {
+		t.Fatalf(`Import failed: %s`, err)
+	}
+	if len(store.ids) != 2 || store.ids[0] != "a" {
+		t.Fatalf(`Expected the exported document to keep its id but got %v`, store.ids)
+	}
+	if string(store.sources["a"]) != `{"name":"shoe","stock":2}` || string(store.sources[store.ids[1]]) != `{"name":"sock"}` {
+		t.Errorf(`Unexpected imported sources %s and %s`, store.sources["a"], store.sources[store.ids[1]])
+	}
+
+	if err := docsExport(e, []string{"products"}); err != nil {
+		t.Fatalf(`Export failed: %s`, err)
+	}
+	lines := strings.Split(strings.TrimSpace(e.stdout.(*bytes.Buffer).String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf(`Expected 2 exported lines but got %q`, lines)
+	}
+	var exported document
+	if err := json.Unmarshal([]byte(lines[0]), &exported); err != nil {
+		t.Fatal(err)
+	}
+	if exported.Index != "products" || exported.Id != "a" || exported.Source["name"] != "shoe" {
+		t.Errorf(`Unexpected exported document %+v`, exported)
+	}
+
+	// Exported documents are imported again as they were.
+	again, e2 := newBulkStore()
+	e2.stdin = strings.NewReader(e.stdout.(*bytes.Buffer).String())
+	if err := docsImport(e2, []string{"products", "-"}); err != nil {
+		t.Fatalf(`Import of the export failed: %s`, err)
+	}
+	if strings.Join(again.ids, ",") != strings.Join(store.ids, ",") {
+		t.Errorf(`Expected ids %v but got %v`, store.ids, again.ids)
+	}
+
+	e.stdin = strings.NewReader("{\"name\":\"shoe\"}\nnot json\n")
+	if err := docsImport(e, []string{"products"}); err == nil || !strings.HasPrefix(err.Error(), "line 2:") {
+		t.Errorf(`Expected an error on line 2 but got %v`, err)
+	}
+}
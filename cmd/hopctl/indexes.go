@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+type indexInfo struct {
+	Name    string `json:"name"`
+	NumDocs int    `json:"docs"`
+	Status  string `json:"status"`
+}
+
+func indexesList(e *env, args []string) error {
+	if len(args) != 0 {
+		return InvalidUsage
+	}
+	db, err := e.connect()
+	if err != nil {
+		return err
+	}
+	indices, err := db.Get()
+	if err != nil {
+		return err
+	}
+
+	result := make([]indexInfo, 0, len(indices))
+	for _, index := range indices {
+		result = append(result, indexInfo{index.Name, index.NumDocs, index.Status})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return e.print(result)
+}
+
+func indexesRemove(e *env, args []string) error {
+	if len(args) != 1 {
+		return InvalidUsage
+	}
+	db, err := e.connect()
+	if err != nil {
+		return err
+	}
+	if err := db.Index(args[0]).Delete(); err != nil {
+		return err
+	}
+	fmt.Fprintf(e.stdout, "Index %s deleted\n", args[0])
+	return nil
+}
+
+func indexesCount(e *env, args []string) error {
+	if len(args) != 1 {
+		return InvalidUsage
+	}
+	db, err := e.connect()
+	if err != nil {
+		return err
+	}
+	count, err := db.Index(args[0]).Count()
+	if err != nil {
+		return err
+	}
+	return e.print(map[string]int{"count": count})
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+var ErrInterrupted error = errors.New("interrupted")
+
+// lineEditor reads lines with history and tab completion when the terminal
+// can be put in raw mode, and falls back to plain buffered reads otherwise.
+type lineEditor struct {
+	in       *bufio.Reader
+	out      io.Writer
+	history  []string
+	complete func(line string) (string, []string)
+}
+
+func newLineEditor(in io.Reader, out io.Writer) *lineEditor {
+	return &lineEditor{in: bufio.NewReader(in), out: out}
+}
+
+func (l *lineEditor) addHistory(line string) {
+	if line == "" || (len(l.history) > 0 && l.history[len(l.history)-1] == line) {
+		return
+	}
+	l.history = append(l.history, line)
+}
+
+func (l *lineEditor) readLine(prompt string) (string, error) {
+	restore, err := makeRaw()
+	if err != nil {
+		fmt.Fprint(l.out, prompt)
+		line, err := l.in.ReadString('\n')
+		if err != nil && line == "" {
+			return "", err
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+	defer restore()
+	return l.edit(prompt)
+}
+
+func (l *lineEditor) edit(prompt string) (string, error) {
+	line := []rune{}
+	cursor := 0
+	historyPos := len(l.history)
+
+	redraw := func() {
+		fmt.Fprintf(l.out, "\r\x1b[K%s%s", prompt, string(line))
+		if back := len(line) - cursor; back > 0 {
+			fmt.Fprintf(l.out, "\x1b[%dD", back)
+		}
+	}
+	setLine := func(s string) {
+		line = []rune(s)
+		cursor = len(line)
+		redraw()
+	}
+	redraw()
+
+	for {
+		r, err := l.readRune()
+		if err != nil {
+			return "", err
+		}
+		switch r {
+		case '\r', '\n':
+			fmt.Fprint(l.out, "\r\n")
+			return string(line), nil
+		case 3: // Ctrl-C
+			fmt.Fprint(l.out, "^C\r\n")
+			return "", ErrInterrupted
+		case 4: // Ctrl-D
+			if len(line) == 0 {
+				fmt.Fprint(l.out, "\r\n")
+				return "", io.EOF
+			}
+		case 1: // Ctrl-A
+			cursor = 0
+			redraw()
+		case 5: // Ctrl-E
+			cursor = len(line)
+			redraw()
+		case 127, 8:
+			if cursor > 0 {
+				line = append(line[:cursor-1], line[cursor:]...)
+				cursor--
+				redraw()
+			}
+		case '\t':
+			if l.complete == nil {
+				continue
+			}
+			completed, candidates := l.complete(string(line[:cursor]))
+			if len(candidates) > 1 {
+				fmt.Fprintf(l.out, "\r\n%s\r\n", strings.Join(candidates, "  "))
+			}
+			line = append([]rune(completed), line[cursor:]...)
+			cursor = utf8.RuneCountInString(completed)
+			redraw()
+		case 27: // escape sequences for the arrow keys
+			if b, _ := l.readRune(); b != '[' {
+				continue
+			}
+			switch code, _ := l.readRune(); code {
+			case 'A':
+				if historyPos > 0 {
+					historyPos--
+					setLine(l.history[historyPos])
+				}
+			case 'B':
+				if historyPos < len(l.history)-1 {
+					historyPos++
+					setLine(l.history[historyPos])
+				} else {
+					historyPos = len(l.history)
+					setLine("")
+				}
+			case 'C':
+				if cursor < len(line) {
+					cursor++
+					redraw()
+				}
+			case 'D':
+				if cursor > 0 {
+					cursor--
+					redraw()
+				}
+			}
+		default:
+			if r < 32 {
+				continue
+			}
+			line = append(line[:cursor], append([]rune{r}, line[cursor:]...)...)
+			cursor++
+			redraw()
+		}
+	}
+}
+
+func (l *lineEditor) readRune() (rune, error) {
+	r, _, err := l.in.ReadRune()
+	return r, err
+}
+
+// completeWord completes the last word of line against candidates and
+// returns the new line together with every candidate that matched.
+func completeWord(line string, candidates []string) (string, []string) {
+	start := strings.LastIndexAny(line, " \t,(") + 1
+	word := line[start:]
+
+	matches := make([]string, 0)
+	for _, c := range candidates {
+		if strings.HasPrefix(strings.ToLower(c), strings.ToLower(word)) {
+			matches = append(matches, c)
+		}
+	}
+	sort.Strings(matches)
+	switch len(matches) {
+	case 0:
+		return line, nil
+	case 1:
+		return line[:start] + matches[0] + " ", matches
+	}
+
+	prefix := matches[0]
+	for _, m := range matches[1:] {
+		for !strings.HasPrefix(m, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	if len(prefix) < len(word) {
+		prefix = word
+	}
+	return line[:start] + prefix, matches
+}
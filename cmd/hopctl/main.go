@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+	"hopcolony.io/hopcolony/docs"
+	"hopcolony.io/hopcolony/initialize"
+)
+
+type command struct {
+	usage       string
+	run         func(env *env, args []string) error
+	subcommands map[string]*command
+}
+
+type env struct {
+	configFile string
+	output     string
+	stdin      io.Reader
+	stdout     io.Writer
+	db         *docs.HopDoc
+}
+
+var InvalidUsage error = errors.New("invalid usage")
+
+func commands() map[string]*command {
+	return map[string]*command{
+		"login":  {usage: "login --username <username> --project <project> --token <token>", run: login},
+		"whoami": {usage: "whoami", run: whoami},
+		"status": {usage: "status", run: status},
+		"shell":  {usage: "shell [index]", run: shell},
+		"indexes": {subcommands: map[string]*command{
+			"ls":    {usage: "indexes ls", run: indexesList},
+			"rm":    {usage: "indexes rm <index>", run: indexesRemove},
+			"count": {usage: "indexes count <index>", run: indexesCount},
+		}},
+		"docs": {subcommands: map[string]*command{
+			"get":    {usage: "docs get <index> <id>", run: docsGet},
+			"set":    {usage: "docs set <index> <id> <json|@file|->", run: docsSet},
+			"update": {usage: "docs update <index> <id> <key=value>...", run: docsUpdate},
+			"delete": {usage: "docs delete <index> <id>", run: docsDelete},
+			"query":  {usage: `docs query <index> [--where "field op value"]... [--limit n]`, run: docsQuery},
+			"import": {usage: "docs import <index> [file]", run: docsImport},
+			"export": {usage: "docs export <index> [file]", run: docsExport},
+		}},
+		"migrations": {subcommands: map[string]*command{
+			"ls":     {usage: "migrations ls", run: migrationsList},
+			"unlock": {usage: "migrations unlock", run: migrationsUnlock},
+		}},
+	}
+}
+
+func main() {
+	e := &env{stdin: os.Stdin, stdout: os.Stdout}
+	flags := flag.NewFlagSet("hopctl", flag.ContinueOnError)
+	flags.StringVar(&e.configFile, "config", initialize.DefaultConfigFile(), "path to the hop config")
+	flags.StringVar(&e.output, "o", "json", "output format: json or yaml")
+	flags.Usage = func() { printUsage(os.Stderr, "", commands()) }
+	if err := flags.Parse(os.Args[1:]); err != nil {
+		os.Exit(2)
+	}
+
+	if err := dispatch(e, commands(), "", flags.Args()); err != nil {
+		if err == InvalidUsage {
+			os.Exit(2)
+		}
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func dispatch(e *env, cmds map[string]*command, prefix string, args []string) error {
+	if len(args) == 0 {
+		printUsage(os.Stderr, prefix, cmds)
+		return InvalidUsage
+	}
+	cmd, ok := cmds[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n", strings.TrimSpace(prefix+" "+args[0]))
+		printUsage(os.Stderr, prefix, cmds)
+		return InvalidUsage
+	}
+	if cmd.subcommands != nil {
+		return dispatch(e, cmd.subcommands, strings.TrimSpace(prefix+" "+args[0]), args[1:])
+	}
+	err := cmd.run(e, args[1:])
+	if err == InvalidUsage {
+		fmt.Fprintf(os.Stderr, "Usage: hopctl %s\n", cmd.usage)
+	}
+	if e.db != nil {
+		e.db.Close()
+	}
+	return err
+}
+
+func printUsage(w io.Writer, prefix string, cmds map[string]*command) {
+	fmt.Fprintln(w, "Usage: hopctl [--config file] [-o json|yaml] <command>")
+	fmt.Fprintln(w, "\nCommands:")
+	for _, usage := range usages(cmds) {
+		if strings.HasPrefix(usage, prefix) {
+			fmt.Fprintf(w, "  %s\n", usage)
+		}
+	}
+}
+
+func usages(cmds map[string]*command) []string {
+	result := make([]string, 0)
+	for _, cmd := range cmds {
+		if cmd.subcommands != nil {
+			result = append(result, usages(cmd.subcommands)...)
+		} else {
+			result = append(result, cmd.usage)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+func (e *env) connect() (*docs.HopDoc, error) {
+	if e.db != nil {
+		return e.db, nil
+	}
+	if _, err := initialize.Initialize(initialize.ProjectConfig{ConfigFile: e.configFile}); err != nil {
+		return nil, err
+	}
+	db, err := docs.New()
+	if err != nil {
+		return nil, err
+	}
+	e.db = db
+	return db, nil
+}
+
+func (e *env) print(v interface{}) error {
+	switch e.output {
+	case "yaml":
+		// Round trip through JSON so the json tags decide the field names.
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		var generic interface{}
+		if err := json.Unmarshal(b, &generic); err != nil {
+			return err
+		}
+		out, err := yaml.Marshal(generic)
+		if err != nil {
+			return err
+		}
+		_, err = e.stdout.Write(out)
+		return err
+	case "json":
+		b, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(e.stdout, string(b))
+		return err
+	}
+	return fmt.Errorf("unknown output format %q", e.output)
+}
+
+func parseFlags(name string, args []string, define func(flags *flag.FlagSet)) ([]string, error) {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	if define != nil {
+		define(flags)
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, InvalidUsage
+	}
+	return flags.Args(), nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+
+	"hopcolony.io/hopcolony/migrations"
+)
+
+func migrationsList(e *env, args []string) error {
+	if len(args) != 0 {
+		return InvalidUsage
+	}
+	db, err := e.connect()
+	if err != nil {
+		return err
+	}
+	records, err := migrations.Applied(db)
+	if err != nil {
+		return err
+	}
+	return e.print(records)
+}
+
+func migrationsUnlock(e *env, args []string) error {
+	if len(args) != 0 {
+		return InvalidUsage
+	}
+	db, err := e.connect()
+	if err != nil {
+		return err
+	}
+	if err := migrations.Unlock(db); err != nil {
+		return err
+	}
+	fmt.Fprintln(e.stdout, "Migrations unlocked")
+	return nil
+}
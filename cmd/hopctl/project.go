@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"hopcolony.io/hopcolony/initialize"
+)
+
+func login(e *env, args []string) error {
+	var username, project, token string
+	_, err := parseFlags("login", args, func(flags *flag.FlagSet) {
+		flags.StringVar(&username, "username", "", "")
+		flags.StringVar(&project, "project", "", "")
+		flags.StringVar(&token, "token", "", "")
+	})
+	if err != nil {
+		return err
+	}
+	if username == "" || project == "" || token == "" {
+		return InvalidUsage
+	}
+
+	config, err := initialize.SaveConfig(e.configFile, username, project, token)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(e.stdout, "Logged in as %s in project %s. Config written to %s\n", config.Username, config.Project, e.configFile)
+	return nil
+}
+
+type identity struct {
+	Username string `json:"username"`
+	Project  string `json:"project"`
+	Identity string `json:"identity"`
+}
+
+func whoami(e *env, args []string) error {
+	if len(args) != 0 {
+		return InvalidUsage
+	}
+	project, err := initialize.Initialize(initialize.ProjectConfig{ConfigFile: e.configFile})
+	if err != nil {
+		return err
+	}
+	return e.print(identity{project.Config.Username, project.Config.Project, project.Config.Identity})
+}
+
+func status(e *env, args []string) error {
+	if len(args) != 0 {
+		return InvalidUsage
+	}
+	db, err := e.connect()
+	if err != nil {
+		return err
+	}
+	status, err := db.Status()
+	if err != nil {
+		return err
+	}
+	return e.print(map[string]string{"status": status})
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"hopcolony.io/hopcolony/docs"
+)
+
+const shellHelp = `Commands:
+  use <index>             switch the current index
+  indexes                 list the indexes of the project
+  fields                  list the fields of the current index
+  get <id>                show a document of the current index
+  count                   count the documents of the current index
+  format table|json|yaml  change how results are shown
+  help                    show this help
+  exit                    leave the shell
+
+Anything else is a query on the current index, for example:
+  where status == "open" and total > 100 order by created desc limit 20
+  search "red shoes" in name, description
+`
+
+var shellKeywords = []string{"where", "and", "search", "in", "order", "by", "asc", "desc", "limit"}
+
+type session struct {
+	env     *env
+	db      *docs.HopDoc
+	editor  *lineEditor
+	index   string
+	format  string
+	indexes []string
+	fields  map[string][]string
+}
+
+func shell(e *env, args []string) error {
+	if len(args) > 1 {
+		return InvalidUsage
+	}
+	db, err := e.connect()
+	if err != nil {
+		return err
+	}
+
+	s := &session{env: e, db: db, editor: newLineEditor(e.stdin, e.stdout), format: "table", fields: make(map[string][]string)}
+	if len(args) == 1 {
+		s.index = args[0]
+	}
+	s.editor.complete = s.complete
+	s.loadHistory()
+
+	fmt.Fprintf(e.stdout, "Connected to project %s. Type \"help\" for help.\n", db.Project.Config.Project)
+	for {
+		line, err := s.editor.readLine(s.prompt())
+		if err == ErrInterrupted {
+			continue
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		s.editor.addHistory(line)
+		s.saveHistory(line)
+
+		if line == "exit" || line == "quit" {
+			return nil
+		}
+		if err := s.execute(line); err != nil {
+			fmt.Fprintf(e.stdout, "Error: %v\n", err)
+		}
+	}
+}
+
+func (s *session) prompt() string {
+	if s.index == "" {
+		return "hop> "
+	}
+	return fmt.Sprintf("hop:%s> ", s.index)
+}
+
+func (s *session) execute(line string) error {
+	fields := strings.Fields(line)
+	switch strings.ToLower(fields[0]) {
+	case "help":
+		fmt.Fprint(s.env.stdout, shellHelp)
+		return nil
+	case "use":
+		if len(fields) != 2 {
+			return errors.New("usage: use <index>")
+		}
+		s.index = fields[1]
+		return nil
+	case "indexes":
+		indexes, err := s.loadIndexes()
+		if err != nil {
+			return err
+		}
+		for _, index := range indexes {
+			fmt.Fprintln(s.env.stdout, index)
+		}
+		return nil
+	case "format":
+		if len(fields) != 2 || (fields[1] != "table" && fields[1] != "json" && fields[1] != "yaml") {
+			return errors.New("usage: format table|json|yaml")
+		}
+		s.format = fields[1]
+		return nil
+	}
+
+	if s.index == "" {
+		return errors.New(`no index selected, run "use <index>" first`)
+	}
+
+	switch strings.ToLower(fields[0]) {
+	case "fields":
+		names, err := s.loadFields(s.index)
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			fmt.Fprintln(s.env.stdout, name)
+		}
+		return nil
+	case "count":
+		count, err := s.db.Index(s.index).Count()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(s.env.stdout, count)
+		return nil
+	case "get":
+		if len(fields) != 2 {
+			return errors.New("usage: get <id>")
+		}
+		snapshot := s.db.Index(s.index).Document(fields[1]).Get()
+		if err := snapshotError(snapshot); err != nil {
+			return err
+		}
+		return s.show([]docs.Document{*snapshot.Doc})
+	}
+
+	statement, err := docs.ParseQuery(line)
+	if err != nil {
+		return err
+	}
+	snapshot := statement.Apply(s.db.Index(s.index)).Get()
+	if !snapshot.Success {
+		return errors.New(snapshot.Reason)
+	}
+	return s.show(snapshot.Docs)
+}
+
+func (s *session) show(documents []docs.Document) error {
+	if s.format != "table" {
+		result := make([]document, 0, len(documents))
+		for idx := range documents {
+			result = append(result, newDocument(&documents[idx]))
+		}
+		output := s.env.output
+		s.env.output = s.format
+		defer func() { s.env.output = output }()
+		return s.env.print(result)
+	}
+
+	columns := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, doc := range documents {
+		for key := range doc.Source {
+			if !seen[key] {
+				seen[key] = true
+				columns = append(columns, key)
+			}
+		}
+	}
+	sort.Strings(columns)
+
+	w := tabwriter.NewWriter(s.env.stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "_id\t%s\n", strings.Join(columns, "\t"))
+	for _, doc := range documents {
+		row := []string{doc.Id}
+		for _, column := range columns {
+			row = append(row, cell(doc.Source[column]))
+		}
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(s.env.stdout, "(%d documents)\n", len(documents))
+	return nil
+}
+
+func cell(value interface{}) string {
+	var text string
+	switch v := value.(type) {
+	case nil:
+		text = ""
+	case string:
+		text = v
+	default:
+		b, _ := json.Marshal(v)
+		text = string(b)
+	}
+	text = strings.NewReplacer("\t", " ", "\n", " ").Replace(text)
+	if len([]rune(text)) > 40 {
+		text = string([]rune(text)[:39]) + "…"
+	}
+	return text
+}
+
+func (s *session) loadIndexes() ([]string, error) {
+	if s.indexes != nil {
+		return s.indexes, nil
+	}
+	indices, err := s.db.Get()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(indices))
+	for _, index := range indices {
+		names = append(names, index.Name)
+	}
+	sort.Strings(names)
+	s.indexes = names
+	return names, nil
+}
+
+func (s *session) loadFields(index string) ([]string, error) {
+	if fields, ok := s.fields[index]; ok {
+		return fields, nil
+	}
+	mapping, err := s.db.Index(index).GetMapping()
+	if err != nil {
+		return nil, err
+	}
+	fields := flattenMapping("", mapping)
+	sort.Strings(fields)
+	s.fields[index] = fields
+	return fields, nil
+}
+
+func flattenMapping(prefix string, mapping docs.Mapping) []string {
+	fields := make([]string, 0)
+	for name, field := range mapping {
+		fields = append(fields, prefix+name)
+		if field.Properties != nil {
+			fields = append(fields, flattenMapping(prefix+name+".", field.Properties)...)
+		}
+	}
+	return fields
+}
+
+func (s *session) complete(line string) (string, []string) {
+	words := strings.Fields(line)
+	candidates := make([]string, 0)
+	first := len(words) == 0 || (len(words) == 1 && !strings.HasSuffix(line, " "))
+	switch {
+	case first:
+		candidates = append(candidates, "use", "indexes", "fields", "get", "count", "format", "help", "exit", "where", "search", "order", "limit")
+	case strings.EqualFold(words[0], "use"):
+		if indexes, err := s.loadIndexes(); err == nil {
+			candidates = append(candidates, indexes...)
+		}
+	case strings.EqualFold(words[0], "format"):
+		candidates = append(candidates, "table", "json", "yaml")
+	default:
+		candidates = append(candidates, shellKeywords...)
+		if s.index != "" {
+			if fields, err := s.loadFields(s.index); err == nil {
+				candidates = append(candidates, fields...)
+			}
+		}
+	}
+	return completeWord(line, candidates)
+}
+
+func historyFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".hop_history")
+}
+
+func (s *session) loadHistory() {
+	filename := historyFile()
+	if filename == "" {
+		return
+	}
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		s.editor.addHistory(strings.TrimSpace(line))
+	}
+}
+
+func (s *session) saveHistory(line string) {
+	filename := historyFile()
+	if filename == "" {
+		return
+	}
+	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	fmt.Fprintln(file, line)
+}
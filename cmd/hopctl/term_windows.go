@@ -0,0 +1,10 @@
+//go:build windows
+// +build windows
+
+package main
+
+import "errors"
+
+func makeRaw() (func(), error) {
+	return nil, errors.New("raw mode is not supported")
+}
@@ -0,0 +1,160 @@
+package docs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const bulkSize = 500
+
+// BulkWriter batches writes to an index into _bulk requests. Documents are
+// encrypted and validated like in SetData. It is not safe for concurrent use.
+type BulkWriter struct {
+	// Size is the number of writes sent per request, 500 by default.
+	Size int
+
+	client HopDocClient
+	db     *HopDoc
+	index  string
+	// options is the index whose encryption and schema apply, which differs
+	// from index while migrating.
+	options string
+	body    bytes.Buffer
+	ids     []string
+	written int
+}
+
+type BulkFailure struct {
+	Id     string
+	Status int
+	Reason string
+}
+
+type BulkError struct {
+	Failures []BulkFailure
+}
+
+func (e *BulkError) Error() string {
+	reasons := make([]string, 0, len(e.Failures))
+	for _, failure := range e.Failures {
+		reasons = append(reasons, fmt.Sprintf("%s: %s", failure.Id, failure.Reason))
+	}
+	return fmt.Sprintf("%d bulk writes failed: %s", len(e.Failures), strings.Join(reasons, "; "))
+}
+
+func (i *IndexReference) BulkWriter() *BulkWriter {
+	return &BulkWriter{client: i.client, db: i.db, index: i.Index, options: i.Index}
+}
+
+// Written returns the number of writes accepted so far.
+func (w *BulkWriter) Written() int {
+	return w.written
+}
+
+// Set queues a write of data as the document id, sending the batch once it
+// is full. An empty id uses the field of data tagged `hop:"id"`, or lets the
+// server generate one.
+func (w *BulkWriter) Set(id string, data interface{}) error {
+	_, b, err := w.db.encode(w.options, data)
+	if err != nil {
+		return err
+	}
+	if id == "" {
+		id = documentId(data)
+	}
+	action := map[string]interface{}{"_index": w.index}
+	if id != "" {
+		action["_id"] = id
+	}
+	return w.add(id, map[string]interface{}{"index": action}, b)
+}
+
+func (w *BulkWriter) Delete(id string) error {
+	return w.add(id, map[string]interface{}{"delete": map[string]interface{}{"_index": w.index, "_id": id}}, nil)
+}
+
+func (w *BulkWriter) add(id string, action map[string]interface{}, source []byte) error {
+	b, err := json.Marshal(action)
+	if err != nil {
+		return err
+	}
+	w.body.Write(b)
+	w.body.WriteByte('\n')
+	if source != nil {
+		w.body.Write(source)
+		w.body.WriteByte('\n')
+	}
+	w.ids = append(w.ids, id)
+
+	size := w.Size
+	if size == 0 {
+		size = bulkSize
+	}
+	if len(w.ids) >= size {
+		return w.Flush()
+	}
+	return nil
+}
+
+type bulkResponse struct {
+	Errors bool                          `json:"errors"`
+	Items  []map[string]bulkResponseItem `json:"items"`
+}
+
+type bulkResponseItem struct {
+	Id     string `json:"_id"`
+	Status int    `json:"status"`
+	Error  *struct {
+		Type   string `json:"type"`
+		Reason string `json:"reason"`
+	} `json:"error"`
+}
+
+// Flush sends the queued writes. Writes rejected by the server are reported
+// in a *BulkError.
+func (w *BulkWriter) Flush() error {
+	if len(w.ids) == 0 {
+		return nil
+	}
+	body := append([]byte(nil), w.body.Bytes()...)
+	ids := w.ids
+	w.body.Reset()
+	w.ids = nil
+
+	if cache := w.db.documentCache(); cache != nil {
+		for _, id := range ids {
+			cache.invalidate(w.index, id)
+		}
+	}
+
+	resp, err := w.client.Post("/_bulk", body)
+	if err != nil {
+		return fmt.Errorf("could not send bulk request: %v", err)
+	}
+	var result bulkResponse
+	if err := json.Unmarshal(resp, &result); err != nil {
+		return err
+	}
+
+	failures := make([]BulkFailure, 0)
+	for _, item := range result.Items {
+		for op, status := range item {
+			// Deleting a missing document is not a failure.
+			if status.Error == nil && (status.Status < 300 || op == "delete" && status.Status == 404) {
+				w.written++
+				continue
+			}
+			reason := fmt.Sprintf("status %d", status.Status)
+			if status.Error != nil {
+				reason = status.Error.Reason
+			}
+			failures = append(failures, BulkFailure{status.Id, status.Status, reason})
+		}
+	}
+	if len(failures) > 0 {
+		return &BulkError{failures}
+	}
+	return nil
+}
@@ -0,0 +1,280 @@
+package docs
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Cache stores documents keyed by "index/id". Implementations must be safe
+// for concurrent use.
+type Cache interface {
+	Get(key string) (*Document, bool)
+	Set(key string, doc *Document, ttl time.Duration)
+	Delete(key string)
+}
+
+type MemoryCache struct {
+	size    int
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+type cacheEntry struct {
+	key     string
+	doc     *Document
+	expires time.Time
+}
+
+// NewMemoryCache returns an LRU cache that holds up to size documents.
+func NewMemoryCache(size int) *MemoryCache {
+	return &MemoryCache{size: size, entries: make(map[string]*list.Element), order: list.New()}
+}
+
+func (c *MemoryCache) Get(key string) (*Document, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*cacheEntry)
+	if !entry.expires.IsZero() && time.Now().After(entry.expires) {
+		c.remove(element)
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return entry.doc, true
+}
+
+func (c *MemoryCache) Set(key string, doc *Document, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var expires time.Time
+	if ttl > 0 {
+		expires = time.Now().Add(ttl)
+	}
+	if element, ok := c.entries[key]; ok {
+		element.Value = &cacheEntry{key, doc, expires}
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&cacheEntry{key, doc, expires})
+	for c.size > 0 && c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *MemoryCache) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[key]; ok {
+		c.remove(element)
+	}
+}
+
+func (c *MemoryCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+func (c *MemoryCache) remove(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.entries, element.Value.(*cacheEntry).key)
+}
+
+type CacheConfig struct {
+	// Cache defaults to a MemoryCache of 10000 documents.
+	Cache Cache
+	// TTL of every entry. Zero keeps entries until they are evicted.
+	TTL time.Duration
+}
+
+type CacheStats struct {
+	Hits   uint64
+	Misses uint64
+	// Shared counts misses that waited for a request already in flight
+	// instead of sending their own.
+	Shared uint64
+}
+
+type documentCache struct {
+	config CacheConfig
+	hits   uint64
+	misses uint64
+	shared uint64
+	flight flightGroup
+	// mu serializes writes so that an older version never replaces a newer one.
+	mu sync.Mutex
+	// generations counts the invalidations of the keys being loaded, so that
+	// a load that raced with a write is not cached.
+	generations map[string]uint64
+}
+
+// EnableCache caches the documents read through DocumentReference.Get. The
+// cache is kept up to date by SetData, Update and Delete made through this
+// HopDoc.
+func (h *HopDoc) EnableCache(config CacheConfig) {
+	if config.Cache == nil {
+		config.Cache = NewMemoryCache(10000)
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.cache = &documentCache{config: config}
+}
+
+func (h *HopDoc) CacheStats() CacheStats {
+	c := h.documentCache()
+	if c == nil {
+		return CacheStats{}
+	}
+	return CacheStats{atomic.LoadUint64(&c.hits), atomic.LoadUint64(&c.misses), atomic.LoadUint64(&c.shared)}
+}
+
+func (h *HopDoc) documentCache() *documentCache {
+	if h == nil {
+		return nil
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.cache
+}
+
+func cacheKey(index, id string) string {
+	return index + "/" + id
+}
+
+// get returns the cached document or loads it, making sure concurrent misses
+// for the same key share a single request.
+func (c *documentCache) get(index, id string, load func() DocumentSnapshot) DocumentSnapshot {
+	key := cacheKey(index, id)
+	if doc, ok := c.config.Cache.Get(key); ok {
+		atomic.AddUint64(&c.hits, 1)
+		return DocumentSnapshot{Doc: copyDocument(doc), Success: true}
+	}
+	atomic.AddUint64(&c.misses, 1)
+
+	snapshot, shared := c.flight.do(key, func() DocumentSnapshot {
+		c.mu.Lock()
+		if c.generations == nil {
+			c.generations = make(map[string]uint64)
+		}
+		c.generations[key] = 0
+		c.mu.Unlock()
+
+		snapshot := load()
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if snapshot.Success && !snapshot.Stale && c.generations[key] == 0 {
+			c.set(index, snapshot.Doc)
+		}
+		delete(c.generations, key)
+		return snapshot
+	})
+	if shared {
+		atomic.AddUint64(&c.shared, 1)
+	}
+	if snapshot.Doc != nil {
+		snapshot.Doc = copyDocument(snapshot.Doc)
+	}
+	return snapshot
+}
+
+// store caches doc unless a newer version of it is already cached. The key
+// uses the index the caller asked for, which may be an alias of doc.Index.
+func (c *documentCache) store(index string, doc *Document) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.set(index, doc)
+}
+
+// set is store with mu held.
+func (c *documentCache) set(index string, doc *Document) {
+	if doc == nil || doc.Id == "" {
+		return
+	}
+	key := cacheKey(index, doc.Id)
+	if cached, ok := c.config.Cache.Get(key); ok && doc.Version != 0 && cached.Version > doc.Version {
+		return
+	}
+	c.config.Cache.Set(key, copyDocument(doc), c.config.TTL)
+}
+
+func (c *documentCache) invalidate(index, id string) {
+	key := cacheKey(index, id)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, loading := c.generations[key]; loading {
+		c.generations[key]++
+	}
+	c.config.Cache.Delete(key)
+}
+
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+type flightCall struct {
+	wg     sync.WaitGroup
+	result DocumentSnapshot
+}
+
+func (g *flightGroup) do(key string, fn func() DocumentSnapshot) (DocumentSnapshot, bool) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall)
+	}
+	if call, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		call.wg.Wait()
+		return call.result, true
+	}
+	call := &flightCall{}
+	call.wg.Add(1)
+	g.calls[key] = call
+	g.mu.Unlock()
+
+	call.result = fn()
+	call.wg.Done()
+
+	g.mu.Lock()
+	delete(g.calls, key)
+	g.mu.Unlock()
+	return call.result, false
+}
+
+func copyDocument(doc *Document) *Document {
+	if doc == nil {
+		return nil
+	}
+	c := *doc
+	if doc.Source != nil {
+		c.Source = deepCopy(doc.Source).(map[string]interface{})
+	}
+	return &c
+}
+
+func deepCopy(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			m[key] = deepCopy(item)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(v))
+		for i, item := range v {
+			s[i] = deepCopy(item)
+		}
+		return s
+	}
+	return value
+}
@@ -0,0 +1,345 @@
+package docs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+type EventType string
+
+const (
+	CreateEvent EventType = "create"
+	UpdateEvent EventType = "update"
+	DeleteEvent EventType = "delete"
+)
+
+// TombstoneIndex holds a record of every delete made on the indexes passed to
+// TrackDeletes, since deleted documents can not be searched.
+const TombstoneIndex = ".hop.tombstones"
+
+var MultipleShards error = errors.New("change feeds need an index with a single primary shard")
+
+type ChangeEvent struct {
+	Type    EventType              `json:"type"`
+	Index   string                 `json:"index"`
+	Id      string                 `json:"id"`
+	Seq     int64                  `json:"seq"`
+	Version int                    `json:"version,omitempty"`
+	Source  map[string]interface{} `json:"source,omitempty"`
+}
+
+type tombstone struct {
+	Index     string    `json:"index"`
+	Id        string    `json:"id"`
+	SeqNo     int64     `json:"seqNo"`
+	DeletedAt time.Time `json:"deletedAt"`
+}
+
+// TrackDeletes makes Delete leave a tombstone for the documents of index so
+// that change feeds can report them.
+func (h *HopDoc) TrackDeletes(index string) {
+	h.options(index).setTrackDeletes(true)
+}
+
+// deleteDocument deletes index/id and records its tombstone if deletes of
+// index are tracked.
+func (h *HopDoc) deleteDocument(client HopDocClient, index, id string, options writeOptions) error {
+	resp, err := client.do(http.MethodDelete, fmt.Sprintf("/%s/_doc/%s%s", index, id, options.query()), nil)
+	if err != nil || !h.lookup(index).getTrackDeletes() {
+		return err
+	}
+
+	var result struct {
+		SeqNo int64 `json:"_seq_no"`
+	}
+	if err := json.Unmarshal(resp, &result); err != nil {
+		return err
+	}
+	b, err := json.Marshal(tombstone{index, id, result.SeqNo, time.Now().UTC()})
+	if err != nil {
+		return err
+	}
+	if _, err := client.Post(fmt.Sprintf("/%s/_doc", TombstoneIndex), b); err != nil {
+		return fmt.Errorf("could not record tombstone: %v", err)
+	}
+	return nil
+}
+
+// ChangeFeed polls an index for the documents written after a sequence
+// number. Sequence numbers are ordered per shard, so the index must have a
+// single primary shard. Each poll stops at the global checkpoint of the
+// shard, below which every write is done, so that a write that is still in
+// flight is not skipped by the position. Deletes are reported for the
+// indexes passed to TrackDeletes.
+type ChangeFeed struct {
+	// Checkpoints stores the position of the feed under Name after every
+	// acknowledged event. A stored position takes precedence over since.
+	Checkpoints CheckpointStore
+	Name        string
+	// PollInterval defaults to 1s and BatchSize to 500.
+	PollInterval time.Duration
+	BatchSize    int
+
+	ctx      context.Context
+	db       *HopDoc
+	client   HopDocClient
+	index    string
+	position int64
+	loaded   bool
+}
+
+func (h *HopDoc) ChangeFeed(ctx context.Context, index string, since int64) *ChangeFeed {
+	return &ChangeFeed{ctx: ctx, db: h, client: h.client.withContext(ctx), index: index, position: since}
+}
+
+// Position returns the sequence number of the last acknowledged event.
+func (f *ChangeFeed) Position() int64 {
+	return f.position
+}
+
+// Events polls the index in the background until the context is done. The
+// error channel receives the error that stopped the feed, if any.
+func (f *ChangeFeed) Events() (<-chan ChangeEvent, <-chan error) {
+	events := make(chan ChangeEvent)
+	errs := make(chan error, 1)
+	go func() {
+		defer close(events)
+		err := f.Run(SinkFunc(func(ctx context.Context, event ChangeEvent) error {
+			select {
+			case events <- event:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}))
+		if err != nil && err != context.Canceled {
+			errs <- err
+		}
+		close(errs)
+	}()
+	return events, errs
+}
+
+// Run delivers the events to sink until the context is done or sink fails.
+// The position is checkpointed once sink accepts an event, so events are
+// delivered at least once.
+func (f *ChangeFeed) Run(sink Sink) error {
+	interval := f.PollInterval
+	if interval == 0 {
+		interval = time.Second
+	}
+	for {
+		events, err := f.Poll()
+		if err != nil {
+			return err
+		}
+		for _, event := range events {
+			if err := sink.Send(f.ctx, event); err != nil {
+				return err
+			}
+			if err := f.Ack(event); err != nil {
+				return err
+			}
+		}
+		if len(events) > 0 {
+			continue
+		}
+		select {
+		case <-f.ctx.Done():
+			return f.ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// Ack moves the position past event, saving it to the checkpoint store.
+func (f *ChangeFeed) Ack(event ChangeEvent) error {
+	f.position = event.Seq
+	if f.Checkpoints == nil {
+		return nil
+	}
+	if err := f.Checkpoints.Save(f.Name, event.Seq); err != nil {
+		return fmt.Errorf("could not save checkpoint: %v", err)
+	}
+	return nil
+}
+
+// Poll returns the next batch of events after the current position. The
+// position only moves when the events are passed to Ack.
+func (f *ChangeFeed) Poll() ([]ChangeEvent, error) {
+	if err := f.ctx.Err(); err != nil {
+		return nil, err
+	}
+	if !f.loaded && f.Checkpoints != nil {
+		position, ok, err := f.Checkpoints.Load(f.Name)
+		if err != nil {
+			return nil, fmt.Errorf("could not load checkpoint: %v", err)
+		}
+		if ok {
+			f.position = position
+		}
+	}
+	f.loaded = true
+
+	checkpoint, err := f.checkpoint()
+	if err != nil {
+		return nil, err
+	}
+	if checkpoint <= f.position {
+		return []ChangeEvent{}, nil
+	}
+
+	size := f.BatchSize
+	if size == 0 {
+		size = 500
+	}
+	writes, err := f.writes(size, checkpoint)
+	if err != nil {
+		return nil, err
+	}
+	deletes := make([]ChangeEvent, 0)
+	if f.db.lookup(f.index).getTrackDeletes() {
+		if deletes, err = f.deletes(size, checkpoint); err != nil {
+			return nil, err
+		}
+	}
+
+	// Both batches are ordered, but each one only up to its last event when
+	// it is full: anything after that may still be missing from it.
+	limit := int64(-1)
+	for _, batch := range [][]ChangeEvent{writes, deletes} {
+		if len(batch) == size && (limit < 0 || batch[size-1].Seq < limit) {
+			limit = batch[size-1].Seq
+		}
+	}
+	events := append(writes, deletes...)
+	sort.SliceStable(events, func(a, b int) bool { return events[a].Seq < events[b].Seq })
+	if limit >= 0 {
+		n := sort.Search(len(events), func(i int) bool { return events[i].Seq > limit })
+		events = events[:n]
+	}
+	return events, nil
+}
+
+// checkpoint returns the global checkpoint of the shard of the index, after
+// refreshing it so that the writes up to the checkpoint can be searched.
+func (f *ChangeFeed) checkpoint() (int64, error) {
+	resp, err := f.client.Get(fmt.Sprintf("/%s/_stats?level=shards", f.index))
+	if IsNotFound(err) {
+		return -1, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("could not get the stats of %s: %v", f.index, err)
+	}
+	var result struct {
+		Indices map[string]struct {
+			Shards map[string][]struct {
+				Routing struct {
+					Primary bool `json:"primary"`
+				} `json:"routing"`
+				SeqNo struct {
+					GlobalCheckpoint int64 `json:"global_checkpoint"`
+				} `json:"seq_no"`
+			} `json:"shards"`
+		} `json:"indices"`
+	}
+	if err := json.Unmarshal(resp, &result); err != nil {
+		return 0, err
+	}
+	checkpoint, shards := int64(-1), 0
+	for _, stats := range result.Indices {
+		for _, copies := range stats.Shards {
+			shards++
+			for _, copy := range copies {
+				if copy.Routing.Primary {
+					checkpoint = copy.SeqNo.GlobalCheckpoint
+				}
+			}
+		}
+	}
+	if shards != 1 {
+		return 0, MultipleShards
+	}
+
+	if _, err := f.client.Post(fmt.Sprintf("/%s,%s/_refresh?ignore_unavailable=true", f.index, TombstoneIndex), nil); err != nil {
+		return 0, fmt.Errorf("could not refresh %s: %v", f.index, err)
+	}
+	return checkpoint, nil
+}
+
+func (f *ChangeFeed) writes(size int, checkpoint int64) ([]ChangeEvent, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"size":                size,
+		"version":             true,
+		"seq_no_primary_term": true,
+		"query":               map[string]interface{}{"range": map[string]interface{}{"_seq_no": map[string]interface{}{"gt": f.position, "lte": checkpoint}}},
+		"sort":                []map[string]interface{}{{"_seq_no": map[string]interface{}{"order": "asc"}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	resp, err := f.client.Post(fmt.Sprintf("/%s/_search", f.index), body)
+	if err != nil {
+		return nil, fmt.Errorf("could not poll changes: %v", err)
+	}
+
+	var result IndexGetResponse
+	if err := json.Unmarshal(resp, &result); err != nil {
+		return nil, err
+	}
+	events := make([]ChangeEvent, 0, len(result.Hits.Hits))
+	for idx := range result.Hits.Hits {
+		doc := &result.Hits.Hits[idx]
+		if err := f.db.decode(f.index, doc); err != nil {
+			return nil, err
+		}
+		event := ChangeEvent{Type: UpdateEvent, Index: doc.Index, Id: doc.Id, Seq: doc.SeqNo, Version: doc.Version, Source: doc.Source}
+		if doc.Version == 1 {
+			event.Type = CreateEvent
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+func (f *ChangeFeed) deletes(size int, checkpoint int64) ([]ChangeEvent, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"size": size,
+		"query": map[string]interface{}{"bool": map[string]interface{}{"filter": []map[string]interface{}{
+			{"term": map[string]interface{}{"index.keyword": f.index}},
+			{"range": map[string]interface{}{"seqNo": map[string]interface{}{"gt": f.position, "lte": checkpoint}}},
+		}}},
+		"sort": []map[string]interface{}{{"seqNo": map[string]interface{}{"order": "asc"}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	resp, err := f.client.Post(fmt.Sprintf("/%s/_search", TombstoneIndex), body)
+	if IsNotFound(err) {
+		return []ChangeEvent{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not poll deletes: %v", err)
+	}
+
+	var result struct {
+		Hits struct {
+			Hits []struct {
+				Source tombstone `json:"_source"`
+			}
+		}
+	}
+	if err := json.Unmarshal(resp, &result); err != nil {
+		return nil, err
+	}
+	events := make([]ChangeEvent, 0, len(result.Hits.Hits))
+	for _, hit := range result.Hits.Hits {
+		events = append(events, ChangeEvent{Type: DeleteEvent, Index: hit.Source.Index, Id: hit.Source.Id, Seq: hit.Source.SeqNo})
+	}
+	return events, nil
+}
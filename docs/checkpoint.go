@@ -0,0 +1,98 @@
+package docs
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// CheckpointIndex holds the positions saved by DocumentCheckpoints.
+const CheckpointIndex = ".hop.checkpoints"
+
+// CheckpointStore keeps the position of change feeds so that they can resume
+// where they stopped.
+type CheckpointStore interface {
+	Load(name string) (position int64, ok bool, err error)
+	Save(name string, position int64) error
+}
+
+// FileCheckpoints stores each position in its own file inside a directory.
+type FileCheckpoints struct {
+	Dir string
+}
+
+func (c FileCheckpoints) file(name string) string {
+	return filepath.Join(c.Dir, base64.RawURLEncoding.EncodeToString([]byte(name))+".checkpoint")
+}
+
+func (c FileCheckpoints) Load(name string) (int64, bool, error) {
+	b, err := ioutil.ReadFile(c.file(name))
+	if os.IsNotExist(err) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	position, err := strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid checkpoint %q: %v", name, err)
+	}
+	return position, true, nil
+}
+
+func (c FileCheckpoints) Save(name string, position int64) error {
+	if err := os.MkdirAll(c.Dir, 0700); err != nil {
+		return err
+	}
+	tmp := c.file(name) + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(strconv.FormatInt(position, 10)), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.file(name))
+}
+
+// DocumentCheckpoints stores each position as a document of CheckpointIndex,
+// with the name as its id.
+type DocumentCheckpoints struct {
+	DB *HopDoc
+}
+
+type checkpoint struct {
+	Position int64 `json:"position"`
+}
+
+func (c DocumentCheckpoints) path(name string) string {
+	return fmt.Sprintf("/%s/_doc/%s", CheckpointIndex, url.PathEscape(name))
+}
+
+func (c DocumentCheckpoints) Load(name string) (int64, bool, error) {
+	resp, err := c.DB.client.Get(c.path(name))
+	if IsNotFound(err) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	var doc struct {
+		Source checkpoint `json:"_source"`
+	}
+	if err := json.Unmarshal(resp, &doc); err != nil {
+		return 0, false, err
+	}
+	return doc.Source.Position, true, nil
+}
+
+func (c DocumentCheckpoints) Save(name string, position int64) error {
+	b, err := json.Marshal(checkpoint{position})
+	if err != nil {
+		return err
+	}
+	_, err = c.DB.client.Post(c.path(name), b)
+	return err
+}
@@ -0,0 +1,118 @@
+package docs
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ParentField holds the path of the parent of the documents of a
+// subcollection, see DocumentReference.Path.
+const ParentField = "_hopParentPath"
+
+// collectionSeparator joins the index of a document and the name of its
+// subcollection. It is not "." so that subcollections can not clash with
+// the indexes kept by the SDK, such as HistoryIndex and PercolatorIndex.
+const collectionSeparator = "~"
+
+// Collection returns the subcollection name of the document. Its documents
+// are stored in the index "<index>~<name>" with the path of the document in
+// ParentField, and their ids are prefixed with "<parent id>:" so that the
+// same id can be used under different parents. Queries only see the
+// documents of this parent.
+func (d *DocumentReference) Collection(name string) *IndexReference {
+	i := d.db.Index(d.Index + collectionSeparator + name)
+	i.client = d.client
+	i.parent = d
+	return i
+}
+
+// CollectionGroup queries the subcollections called name of every document,
+// whatever their parent. The path of the parent of each document is in
+// ParentField.
+func (h *HopDoc) CollectionGroup(name string) *IndexReference {
+	return h.Index("*" + collectionSeparator + name)
+}
+
+// Parent returns the document holding the subcollection of d, nil for the
+// documents of a top level index.
+func (d *DocumentReference) Parent() *DocumentReference {
+	return d.parent
+}
+
+// Path returns "<index>/<id>" for a top level document and
+// "<parent path>/<name>/<id>" for the document of a subcollection.
+func (d *DocumentReference) Path() string {
+	if d.parent == nil {
+		return d.Index + "/" + d.Id
+	}
+	return d.parent.Path() + "/" + strings.TrimPrefix(d.Index, d.parent.Index+collectionSeparator) + "/" + d.Id
+}
+
+// key returns the id id is stored under. Ids that already have the prefix of
+// the parent, as read back from Document.Id, are kept.
+func (d *DocumentReference) key(id string) string {
+	if d.parent == nil || id == "" {
+		return id
+	}
+	prefix := d.parent.key(d.parent.Id) + ":"
+	if strings.HasPrefix(id, prefix) {
+		return id
+	}
+	return prefix + id
+}
+
+// parentPath returns the path documents read through i must have in
+// ParentField, empty if i is not a subcollection.
+func (i *IndexReference) parentPath() string {
+	if i.parent == nil {
+		return ""
+	}
+	return i.parent.Path()
+}
+
+// Cascade makes Delete first delete the documents of the given subcollections
+// of the document. Nested subcollections are named with dots, as in
+// "comments.replies", and must be listed too to be deleted. Subcollections with
+// soft deletes are marked as deleted instead. No history is recorded for the
+// documents deleted this way.
+func Cascade(collections ...string) WriteOption {
+	return func(o *writeOptions) {
+		o.cascade = append(o.cascade, collections...)
+	}
+}
+
+// cascade deletes the documents found under the path of d in its
+// subcollections.
+func (d *DocumentReference) cascade(collections []string) error {
+	path := d.Path()
+	for _, collection := range collections {
+		index := d.Index + collectionSeparator + strings.ReplaceAll(collection, ".", collectionSeparator)
+		body := map[string]interface{}{
+			"query": map[string]interface{}{"bool": map[string]interface{}{
+				"should": []map[string]interface{}{
+					{"term": map[string]interface{}{ParentField + ".keyword": path}},
+					{"prefix": map[string]interface{}{ParentField + ".keyword": path + "/"}},
+				},
+				"minimum_should_match": 1,
+			}},
+		}
+		endpoint := "_delete_by_query"
+		if audit := d.db.lookup(index).getAudit(); audit != nil && audit.SoftDelete {
+			endpoint = "_update_by_query"
+			body["script"] = Script{Source: "ctx._source.putAll(params)", Params: map[string]interface{}{
+				DeletedField: time.Now().UTC().Format(time.RFC3339Nano),
+			}}
+		}
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		_, err = d.client.Post(fmt.Sprintf("/%s/%s?refresh=true&conflicts=proceed", index, endpoint), b)
+		if err != nil && !IsNotFound(err) {
+			return fmt.Errorf("could not delete subcollection %s: %v", collection, err)
+		}
+	}
+	return nil
+}
@@ -2,11 +2,14 @@ package docs
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"regexp"
+	"sync"
 
 	"hopcolony.io/hopcolony/initialize"
 )
@@ -24,15 +27,30 @@ func New() (*HopDoc, error) {
 		return nil, err
 	}
 
-	return &HopDoc{project, client}, nil
+	return &HopDoc{Project: project, client: client}, nil
 }
 
 type HopDoc struct {
-	Project initialize.Project
-	client  HopDocClient
+	Project  initialize.Project
+	client   HopDocClient
+	indexes  map[string]*indexOptions
+	cache    *documentCache
+	offline  *offlineStore
+	sweepers []*Sweeper
+	batcher  *searchBatcher
+	mu       sync.RWMutex
 }
 
 func (h *HopDoc) Close() {
+	h.mu.RLock()
+	sweepers := h.sweepers
+	h.mu.RUnlock()
+	for _, sweeper := range sweepers {
+		sweeper.Stop()
+	}
+	if s := h.offlineStore(); s != nil {
+		s.close()
+	}
 	h.client.close()
 }
 
@@ -52,7 +70,7 @@ func (h *HopDoc) Status() (string, error) {
 }
 
 func (h *HopDoc) Index(index string) *IndexReference {
-	return &IndexReference{h.client, index, make([]Query, 0)}
+	return &IndexReference{client: h.client, db: h, Index: index, Queries: make([]Query, 0)}
 }
 
 func (h *HopDoc) Get() ([]Index, error) {
@@ -90,106 +108,138 @@ type HopDocClient struct {
 	identity   string
 	baseUrl    string
 	httpClient *http.Client
+	transport  *transport
+	ctx        context.Context
 }
 
 func (h *HopDocClient) close() {}
 
+func (h HopDocClient) withContext(ctx context.Context) HopDocClient {
+	h.ctx = ctx
+	return h
+}
+
 func (h *HopDocClient) Get(path string) ([]byte, error) {
-	req, err := http.NewRequest("GET", h.baseUrl+path, nil)
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Add("Token", h.Project.Config.Token)
-	resp, err := h.httpClient.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("could not do GET request: %v", err)
-	}
+	return h.do(http.MethodGet, path, nil)
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("status code of GET is %s", resp.Status)
-	}
+func (h *HopDocClient) Post(path string, body []byte) ([]byte, error) {
+	return h.do(http.MethodPost, path, body)
+}
 
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("could not read body in GET: %v", err)
-	}
-	return body, nil
+func (h *HopDocClient) Put(path string, body []byte) ([]byte, error) {
+	return h.do(http.MethodPut, path, body)
 }
 
-func (h *HopDocClient) Post(path string, body []byte) ([]byte, error) {
-	req, err := http.NewRequest("POST", h.baseUrl+path, bytes.NewBuffer(body))
+func (h *HopDocClient) Delete(path string) error {
+	_, err := h.do(http.MethodDelete, path, nil)
+	return err
+}
+
+func (h *HopDocClient) do(method, path string, body []byte) ([]byte, error) {
+	ctx := h.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	index, operation := describe(method, path)
+	req := &Request{
+		Context:   ctx,
+		Method:    method,
+		Path:      path,
+		Body:      body,
+		Header:    make(http.Header),
+		Index:     index,
+		Operation: operation,
+	}
+	req.Header.Set("X-Request-Id", newRequestID())
+	if method != http.MethodGet {
+		req.Header.Set("Content-type", "application/json")
+	}
+
+	resp, err := h.transport.handler(h.send)(req)
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Add("Token", h.Project.Config.Token)
-	req.Header.Add("Content-type", "application/json")
+	return resp.Body, nil
+}
 
-	resp, err := h.httpClient.Do(req)
+// send is the last handler of the chain and performs the actual request. If
+// the token is rejected it is refreshed once and the request is sent again.
+func (h *HopDocClient) send(req *Request) (*Response, error) {
+	token, err := h.Project.Token()
 	if err != nil {
-		return nil, fmt.Errorf("could not do POST request: %v", err)
+		return nil, fmt.Errorf("could not get token: %v", err)
 	}
 
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
-		return nil, fmt.Errorf("status code of POST is %s", resp.Status)
+	resp, err := h.roundTrip(req, token)
+	if resp == nil || resp.StatusCode != http.StatusUnauthorized || h.Project.Credentials == nil {
+		return resp, err
 	}
 
-	defer resp.Body.Close()
-	b, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("could not read body in POST: %v", err)
+	refreshed, refreshErr := h.Project.RefreshToken()
+	if refreshErr != nil || refreshed == token {
+		return resp, err
 	}
-
-	return b, nil
+	return h.roundTrip(req, refreshed)
 }
 
-func (h *HopDocClient) Put(path string, body []byte) ([]byte, error) {
-	req, err := http.NewRequest("PUT", h.baseUrl+path, bytes.NewBuffer(body))
-	if err != nil {
-		return nil, err
+func (h *HopDocClient) roundTrip(req *Request, token string) (*Response, error) {
+	var body io.Reader
+	if req.Body != nil {
+		body = bytes.NewBuffer(req.Body)
 	}
-	req.Header.Add("Token", h.Project.Config.Token)
-	req.Header.Add("Content-type", "application/json")
-
-	resp, err := h.httpClient.Do(req)
+	httpReq, err := http.NewRequest(req.Method, h.baseUrl+req.Path, body)
 	if err != nil {
-		return nil, fmt.Errorf("could not do PUT request: %v", err)
+		return nil, err
 	}
-
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
-		return nil, fmt.Errorf("status code of PUT is %s", resp.Status)
+	httpReq = httpReq.WithContext(req.Context)
+	for key, values := range req.Header {
+		for _, value := range values {
+			httpReq.Header.Add(key, value)
+		}
 	}
+	httpReq.Header.Set("Token", token)
 
-	defer resp.Body.Close()
-	b, err := ioutil.ReadAll(resp.Body)
+	httpResp, err := h.httpClient.Do(httpReq)
 	if err != nil {
-		return nil, fmt.Errorf("could not read body in PUT: %v", err)
+		return nil, fmt.Errorf("could not do %s request: %v", req.Method, err)
 	}
 
-	return b, nil
-}
-
-func (h *HopDocClient) Delete(path string) error {
-	req, err := http.NewRequest("DELETE", h.baseUrl+path, nil)
+	defer httpResp.Body.Close()
+	b, err := ioutil.ReadAll(httpResp.Body)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("could not read body in %s: %v", req.Method, err)
 	}
-	req.Header.Add("Token", h.Project.Config.Token)
-	req.Header.Add("Content-type", "application/json")
 
-	resp, err := h.httpClient.Do(req)
-	if err != nil {
-		return fmt.Errorf("could not do DELETE request: %v", err)
+	resp := &Response{StatusCode: httpResp.StatusCode, Status: httpResp.Status, Header: httpResp.Header, Body: b}
+	if !accepted(req.Method, httpResp.StatusCode) {
+		return resp, &StatusError{Method: req.Method, StatusCode: httpResp.StatusCode, Status: httpResp.Status, Body: b}
 	}
+	return resp, nil
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("status code of PUT is %s", resp.Status)
+func accepted(method string, status int) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut:
+		return status == http.StatusOK || status == http.StatusCreated
+	default:
+		return status == http.StatusOK
 	}
+}
+
+type StatusError struct {
+	Method     string
+	StatusCode int
+	Status     string
+	Body       []byte
+}
 
-	return nil
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("status code of %s is %s", e.Method, e.Status)
 }
 
 func newHopDocClient(project initialize.Project, host string, port int) (HopDocClient, error) {
 	return HopDocClient{Project: project, Host: host, Port: port, identity: project.Config.Identity,
-		baseUrl: fmt.Sprintf("https://%s:%d/%s/api", host, port, project.Config.Identity), httpClient: &http.Client{}}, nil
+		baseUrl: fmt.Sprintf("https://%s:%d/%s/api", host, port, project.Config.Identity), httpClient: &http.Client{},
+		transport: &transport{}}, nil
 }
@@ -3,8 +3,8 @@ package docs
 import (
 	"encoding/json"
 	"fmt"
-
-	"github.com/mitchellh/mapstructure"
+	"net/http"
+	"time"
 )
 
 type UpdateData struct {
@@ -13,54 +13,146 @@ type UpdateData struct {
 }
 
 type Document struct {
-	Source  map[string]interface{} `json:"_source"`
-	Index   string                 `json:"_index"`
-	Id      string                 `json:"_id"`
-	Version int                    `json:"_version"`
+	Source      map[string]interface{} `json:"_source"`
+	Index       string                 `json:"_index"`
+	Id          string                 `json:"_id"`
+	Version     int                    `json:"_version"`
+	SeqNo       int64                  `json:"_seq_no"`
+	PrimaryTerm int64                  `json:"_primary_term"`
+	Score       float64                `json:"_score"`
+	Highlight   map[string][]string    `json:"highlight"`
+	Sort        []interface{}          `json:"sort"`
+	// Similarity is the score of the vector of the document against the
+	// vector of a Nearest query, see Similarity.Score.
+	Similarity float64 `json:"-"`
 }
 
 func (ds *Document) Map() map[string]interface{} {
 	return ds.Source
 }
 
+// DataTo decodes the source into in. Fields tagged `hop:"id"`,
+// `hop:"index"` and `hop:"version"` are filled from the metadata.
 func (ds *Document) DataTo(in interface{}) error {
-	err := mapstructure.Decode(ds.Source, in)
-	return err
+	if err := decodeSource(ds.Source, in); err != nil {
+		return err
+	}
+	setMetadata(in, ds)
+	return nil
 }
 
 type DocumentSnapshot struct {
 	Doc     *Document
 	Success bool
 	Reason  string
+	// Pending is set when the write is in the offline journal and has not
+	// reached the server yet.
+	Pending bool
+	// Stale is set when the document was read from the offline copy.
+	Stale bool
+	// Err is the error behind Reason when the request failed.
+	Err error
 }
 
 type DocumentReference struct {
 	client HopDocClient
+	db     *HopDoc
 	Index  string
 	Id     string
+	// parent is the document holding the subcollection of the document.
+	parent *DocumentReference
 }
 
-func (d *DocumentReference) Get() DocumentSnapshot {
-	resp, err := d.client.Get(fmt.Sprintf("/%s/_doc/%s", d.Index, d.Id))
+// Get reads the document. See Populate for resolving the refs it holds.
+func (d *DocumentReference) Get(options ...ReadOption) DocumentSnapshot {
+	var snapshot DocumentSnapshot
+	if cache := d.db.documentCache(); cache != nil {
+		snapshot = cache.get(d.Index, d.key(d.Id), d.fetch)
+	} else {
+		snapshot = d.fetch()
+	}
+	var err error
+	if snapshot.Success && expired(snapshot.Doc, time.Now()) {
+		err = errGone("expired")
+	} else if snapshot.Success && d.db.lookup(d.Index).deleted(snapshot.Doc) {
+		err = errGone("deleted")
+	}
 	if err != nil {
-		return DocumentSnapshot{Success: false, Reason: err.Error()}
+		return DocumentSnapshot{Success: false, Reason: err.Error(), Err: err}
+	}
+
+	if o := newReadOptions(options); o.depth > 0 && snapshot.Success {
+		if snapshot.Doc.Index == "" {
+			snapshot.Doc.Index = d.Index
+		}
+		if err := d.db.populate(d.client, []*Document{snapshot.Doc}, o.depth); err != nil {
+			return DocumentSnapshot{Success: false, Reason: err.Error(), Err: err}
+		}
+	}
+	return snapshot
+}
+
+func (d *DocumentReference) fetch() DocumentSnapshot {
+	id := d.key(d.Id)
+	if store := d.db.local(d.Index, id); store != nil {
+		return store.read(d.db, d.Index, id)
+	}
+
+	resp, err := d.client.Get(fmt.Sprintf("/%s/_doc/%s", d.Index, id))
+	if store := d.db.fallback(err); store != nil {
+		return store.read(d.db, d.Index, id)
+	}
+	if err != nil {
+		if store := d.db.offlineStore(); store != nil && IsNotFound(err) {
+			store.removeLocal(d.Index, id)
+		}
+		return DocumentSnapshot{Success: false, Reason: err.Error(), Err: err}
 	}
 
 	var document Document
 	json.Unmarshal(resp, &document)
+	d.db.offlineStore().save(d.Index, &document)
+	if err := d.db.decode(d.Index, &document); err != nil {
+		return DocumentSnapshot{Success: false, Reason: err.Error()}
+	}
 
-	return DocumentSnapshot{&document, true, ""}
+	return DocumentSnapshot{Doc: &document, Success: true}
 }
 
-func (d *DocumentReference) SetData(data interface{}) DocumentSnapshot {
-	b, err := json.Marshal(data)
+// SetData writes data as the document. If the reference has no id, the field
+// of data tagged `hop:"id"` is used.
+func (d *DocumentReference) SetData(data interface{}, options ...WriteOption) DocumentSnapshot {
+	o := newWriteOptions(options)
+	if d.parent != nil {
+		o.parent = d.parent.Path()
+	}
+	source, b, err := d.db.encode(d.Index, data)
+	if err == nil {
+		b, err = o.withFields(source, b)
+	}
 	if err != nil {
 		return DocumentSnapshot{Success: false, Reason: err.Error()}
 	}
+	id := d.Id
+	if id == "" {
+		id = documentId(data)
+	}
+	id = d.key(id)
 
-	resp, err := d.client.Post(fmt.Sprintf("/%s/_doc/%s", d.Index, d.Id), b)
+	if d.db.queued() && d.queueable(o) {
+		return d.db.queueData(SetOp, d.Index, id, b, data)
+	}
+	resp, err := d.db.write(d.client, d.Index, id, SetOp, o, func(o writeOptions) ([]byte, error) {
+		return d.client.Post(fmt.Sprintf("/%s/_doc/%s%s", d.Index, id, o.query()), b)
+	})
+	if d.db.fallback(err) != nil && d.queueable(o) {
+		return d.db.queueData(SetOp, d.Index, id, b, data)
+	}
 	if err != nil {
-		return DocumentSnapshot{Success: false, Reason: err.Error()}
+		if cache := d.db.documentCache(); cache != nil {
+			cache.invalidate(d.Index, id)
+		}
+		return DocumentSnapshot{Success: false, Reason: err.Error(), Err: err}
 	}
 
 	var document Document
@@ -69,37 +161,105 @@ func (d *DocumentReference) SetData(data interface{}) DocumentSnapshot {
 		return DocumentSnapshot{Success: false, Reason: err.Error()}
 	}
 
-	var result map[string]interface{}
-	json.Unmarshal(b, &result)
-	document.Source = result
+	document.Source = source
+	if cache := d.db.documentCache(); cache != nil {
+		cache.store(d.Index, &document)
+	}
+	if store := d.db.offlineStore(); store != nil {
+		store.written(d.Index, document.Id, document.Version, b)
+	}
+	setMetadata(data, &document)
+	d.db.matched(d.client, d.Index, &document, b)
 
-	return DocumentSnapshot{&document, true, ""}
+	return DocumentSnapshot{Doc: &document, Success: true}
 }
 
-func (d *DocumentReference) Update(updates []UpdateData) DocumentSnapshot {
-	data := make(map[string]interface{})
-	data["doc"] = make(map[string]interface{})
+// Update writes the given fields. Fields tagged `hop:"updatedAt,auto"` in the
+// structs written to the index are stamped unless updates sets them.
+func (d *DocumentReference) Update(updates []UpdateData, options ...WriteOption) DocumentSnapshot {
+	doc := make(map[string]interface{})
 	for _, update := range updates {
-		data["doc"].(map[string]interface{})[update.Key] = update.Value
+		doc[update.Key] = update.Value
+	}
+	o := newWriteOptions(options)
+	_, b, err := d.db.encodeUpdate(d.Index, doc)
+	if err == nil {
+		b, err = o.withFields(nil, b)
 	}
-
-	jsonData, err := json.Marshal(data)
 	if err != nil {
 		return DocumentSnapshot{Success: false, Reason: err.Error()}
 	}
 
-	_, err = d.client.Post(fmt.Sprintf("/%s/_doc/%s/_update", d.Index, d.Id), jsonData)
+	jsonData, err := json.Marshal(map[string]json.RawMessage{"doc": b})
 	if err != nil {
 		return DocumentSnapshot{Success: false, Reason: err.Error()}
 	}
 
-	return d.Get()
+	id := d.key(d.Id)
+	if d.db.queued() && d.queueable(o) {
+		return d.db.queue(UpdateOp, d.Index, id, b)
+	}
+	_, err = d.db.write(d.client, d.Index, id, UpdateOp, o, func(o writeOptions) ([]byte, error) {
+		return d.client.Post(fmt.Sprintf("/%s/_doc/%s/_update%s", d.Index, id, o.query()), jsonData)
+	})
+	if d.db.fallback(err) != nil && d.queueable(o) {
+		return d.db.queue(UpdateOp, d.Index, id, b)
+	}
+	if cache := d.db.documentCache(); cache != nil {
+		cache.invalidate(d.Index, id)
+	}
+	if err != nil {
+		return DocumentSnapshot{Success: false, Reason: err.Error(), Err: err}
+	}
+
+	snapshot := d.Get()
+	if snapshot.Success {
+		d.db.matched(d.client, d.Index, snapshot.Doc, nil)
+	}
+	return snapshot
 }
 
-func (d *DocumentReference) Delete() DocumentSnapshot {
-	err := d.client.Delete(fmt.Sprintf("/%s/_doc/%s", d.Index, d.Id))
+// Delete removes the document, or marks it as deleted if the index has soft
+// deletes. See Cascade for deleting its subcollections.
+func (d *DocumentReference) Delete(options ...WriteOption) DocumentSnapshot {
+	o := newWriteOptions(options)
+	id := d.key(d.Id)
+	if d.db.queued() && d.queueable(o) {
+		return d.db.queue(DeleteOp, d.Index, id, nil)
+	}
+	err := d.cascade(o.cascade)
 	if err != nil {
-		return DocumentSnapshot{Success: false, Reason: err.Error()}
+		return DocumentSnapshot{Success: false, Reason: err.Error(), Err: err}
+	}
+	if audit := d.db.lookup(d.Index).getAudit(); audit != nil && audit.SoftDelete {
+		err = d.db.softDelete(d.client, d.Index, id, time.Now(), o)
+	} else {
+		_, err = d.db.write(d.client, d.Index, id, DeleteOp, o, func(o writeOptions) ([]byte, error) {
+			return nil, d.db.deleteDocument(d.client, d.Index, id, o)
+		})
+	}
+	if d.db.fallback(err) != nil && d.queueable(o) {
+		return d.db.queue(DeleteOp, d.Index, id, nil)
+	}
+	if cache := d.db.documentCache(); cache != nil {
+		cache.invalidate(d.Index, id)
+	}
+	if err != nil {
+		return DocumentSnapshot{Success: false, Reason: err.Error(), Err: err}
 	}
-	return DocumentSnapshot{nil, true, ""}
+	if store := d.db.offlineStore(); store != nil {
+		store.removeLocal(d.Index, id)
+	}
+	return DocumentSnapshot{Success: true}
+}
+
+// queueable reports whether a write can wait in the offline journal.
+func (d *DocumentReference) queueable(o writeOptions) bool {
+	return !o.conditional() && len(o.cascade) == 0 && !d.db.lookup(d.Index).audited()
+}
+
+// errGone is returned for the reads of documents that are expired or soft
+// deleted, which behave as if they did not exist.
+func errGone(reason string) error {
+	return &StatusError{Method: http.MethodGet, StatusCode: http.StatusNotFound, Status: "404 Not Found (" + reason + ")"}
 }
@@ -0,0 +1,477 @@
+package docs
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+const (
+	envelopePrefix      = "$hop$enc$"
+	deterministicPrefix = "$hop$det$"
+)
+
+var MissingKeyProvider error = errors.New("document has encrypted fields but no KeyProvider is configured for its index")
+var InvalidCiphertext error = errors.New("encrypted field is malformed")
+
+// KeyProvider wraps and unwraps the per-value data keys. Implementations can
+// be backed by a KMS; the data keys never leave the client in plaintext.
+type KeyProvider interface {
+	KeyID() string
+	WrapKey(dataKey []byte) ([]byte, error)
+	UnwrapKey(keyID string, wrapped []byte) ([]byte, error)
+}
+
+// DeterministicKeyProvider is required for fields that must support
+// equality queries. The same plaintext always yields the same ciphertext.
+type DeterministicKeyProvider interface {
+	KeyProvider
+	DeterministicKey(keyID string) ([]byte, error)
+}
+
+type StaticKeyProvider struct {
+	current string
+	keys    map[string][]byte
+	mu      sync.RWMutex
+}
+
+func NewStaticKeyProvider(keyID string, key []byte) (*StaticKeyProvider, error) {
+	if _, err := aes.NewCipher(key); err != nil {
+		return nil, err
+	}
+	return &StaticKeyProvider{current: keyID, keys: map[string][]byte{keyID: key}}, nil
+}
+
+// AddKey registers a retired key so values written under it can still be read.
+func (p *StaticKeyProvider) AddKey(keyID string, key []byte) error {
+	if _, err := aes.NewCipher(key); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.keys[keyID] = key
+	return nil
+}
+
+func (p *StaticKeyProvider) KeyID() string {
+	return p.current
+}
+
+func (p *StaticKeyProvider) key(keyID string) ([]byte, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	key, ok := p.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", keyID)
+	}
+	return key, nil
+}
+
+func (p *StaticKeyProvider) WrapKey(dataKey []byte) ([]byte, error) {
+	key, err := p.key(p.current)
+	if err != nil {
+		return nil, err
+	}
+	return seal(key, dataKey, nil)
+}
+
+func (p *StaticKeyProvider) UnwrapKey(keyID string, wrapped []byte) ([]byte, error) {
+	key, err := p.key(keyID)
+	if err != nil {
+		return nil, err
+	}
+	return open(key, wrapped)
+}
+
+func (p *StaticKeyProvider) DeterministicKey(keyID string) ([]byte, error) {
+	key, err := p.key(keyID)
+	if err != nil {
+		return nil, err
+	}
+	return derive(key, "hop deterministic"), nil
+}
+
+type EncryptionConfig struct {
+	Provider KeyProvider
+	// Fields are encrypted with a fresh data key on every write. Nested
+	// fields use dotted paths.
+	Fields []string
+	// Deterministic fields can be queried with "==".
+	Deterministic []string
+	// Types are values of the structs written to the index. Without them,
+	// Where only knows about the fields tagged `hop:"encrypt"` once a value
+	// of their type was written.
+	Types []interface{}
+}
+
+func (h *HopDoc) EncryptFields(index string, config EncryptionConfig) {
+	options := h.options(index)
+	options.setEncryption(&config)
+	for _, value := range config.Types {
+		options.learnTags(value)
+	}
+}
+
+type envelope struct {
+	KeyID   string `json:"k"`
+	DataKey []byte `json:"d"`
+	Data    []byte `json:"c"`
+}
+
+func (c *EncryptionConfig) encryptValue(value interface{}, deterministic bool) (string, error) {
+	if c == nil || c.Provider == nil {
+		return "", MissingKeyProvider
+	}
+	plaintext, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+
+	keyID := c.Provider.KeyID()
+	if deterministic {
+		provider, ok := c.Provider.(DeterministicKeyProvider)
+		if !ok {
+			return "", fmt.Errorf("key provider does not support deterministic encryption")
+		}
+		key, err := provider.DeterministicKey(keyID)
+		if err != nil {
+			return "", err
+		}
+		nonce := hmac.New(sha256.New, derive(key, "nonce"))
+		nonce.Write(plaintext)
+		ciphertext, err := seal(derive(key, "data"), plaintext, nonce.Sum(nil))
+		if err != nil {
+			return "", err
+		}
+		return deterministicPrefix + keyID + "$" + base64.RawURLEncoding.EncodeToString(ciphertext), nil
+	}
+
+	dataKey := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return "", err
+	}
+	wrapped, err := c.Provider.WrapKey(dataKey)
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := seal(dataKey, plaintext, nil)
+	if err != nil {
+		return "", err
+	}
+	b, err := json.Marshal(envelope{keyID, wrapped, ciphertext})
+	if err != nil {
+		return "", err
+	}
+	return envelopePrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func (c *EncryptionConfig) decryptValue(value string) (interface{}, error) {
+	if c == nil || c.Provider == nil {
+		return nil, MissingKeyProvider
+	}
+
+	var plaintext []byte
+	switch {
+	case strings.HasPrefix(value, envelopePrefix):
+		b, err := base64.RawURLEncoding.DecodeString(value[len(envelopePrefix):])
+		if err != nil {
+			return nil, InvalidCiphertext
+		}
+		var e envelope
+		if err := json.Unmarshal(b, &e); err != nil {
+			return nil, InvalidCiphertext
+		}
+		dataKey, err := c.Provider.UnwrapKey(e.KeyID, e.DataKey)
+		if err != nil {
+			return nil, err
+		}
+		if plaintext, err = open(dataKey, e.Data); err != nil {
+			return nil, err
+		}
+	case strings.HasPrefix(value, deterministicPrefix):
+		parts := strings.SplitN(value[len(deterministicPrefix):], "$", 2)
+		if len(parts) != 2 {
+			return nil, InvalidCiphertext
+		}
+		provider, ok := c.Provider.(DeterministicKeyProvider)
+		if !ok {
+			return nil, fmt.Errorf("key provider does not support deterministic encryption")
+		}
+		key, err := provider.DeterministicKey(parts[0])
+		if err != nil {
+			return nil, err
+		}
+		ciphertext, err := base64.RawURLEncoding.DecodeString(parts[1])
+		if err != nil {
+			return nil, InvalidCiphertext
+		}
+		if plaintext, err = open(derive(key, "data"), ciphertext); err != nil {
+			return nil, err
+		}
+	default:
+		return value, nil
+	}
+
+	var result interface{}
+	if err := json.Unmarshal(plaintext, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func isEncrypted(value interface{}) bool {
+	s, ok := value.(string)
+	return ok && (strings.HasPrefix(s, envelopePrefix) || strings.HasPrefix(s, deterministicPrefix))
+}
+
+// fields returns the fields to encrypt in data, and whether each one is
+// deterministic. tagged holds the fields learned from the values written to
+// the index, so that partial writes and maps are encrypted as well.
+func (c *EncryptionConfig) fields(data interface{}, tagged map[string]bool) map[string]bool {
+	fields := make(map[string]bool)
+	for field, deterministic := range tagged {
+		fields[field] = deterministic
+	}
+	if c != nil {
+		for _, field := range c.Fields {
+			fields[field] = false
+		}
+		for _, field := range c.Deterministic {
+			fields[field] = true
+		}
+	}
+	for _, f := range hopFieldsOf(data) {
+		if f.has("encrypt") {
+			fields[f.Name] = f.has("deterministic")
+		}
+	}
+	return fields
+}
+
+// encrypt returns a copy of source with fields encrypted. The original map
+// is left untouched.
+func (c *EncryptionConfig) encrypt(source map[string]interface{}, fields map[string]bool) (map[string]interface{}, bool, error) {
+	if len(fields) == 0 {
+		return source, false, nil
+	}
+
+	result := deepCopy(source).(map[string]interface{})
+	for path, deterministic := range fields {
+		for _, parent := range walkPaths(result, path) {
+			key := path[strings.LastIndex(path, ".")+1:]
+			value, ok := parent[key]
+			if !ok || value == nil || isEncrypted(value) {
+				continue
+			}
+			encrypted, err := c.encryptValue(value, deterministic)
+			if err != nil {
+				return nil, false, fmt.Errorf("could not encrypt field %s: %v", path, err)
+			}
+			parent[key] = encrypted
+		}
+	}
+	return result, true, nil
+}
+
+// decrypt replaces every encrypted value found in source in place.
+func (c *EncryptionConfig) decrypt(source map[string]interface{}) error {
+	for key, value := range source {
+		switch v := value.(type) {
+		case string:
+			if !isEncrypted(v) {
+				continue
+			}
+			plaintext, err := c.decryptValue(v)
+			if err != nil {
+				return fmt.Errorf("could not decrypt field %s: %v", key, err)
+			}
+			source[key] = plaintext
+		case map[string]interface{}:
+			if err := c.decrypt(v); err != nil {
+				return err
+			}
+		case []interface{}:
+			if err := c.decryptItems(key, v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// decryptItems is decrypt for the elements of the array in field key.
+func (c *EncryptionConfig) decryptItems(key string, items []interface{}) error {
+	for idx, item := range items {
+		switch v := item.(type) {
+		case string:
+			if !isEncrypted(v) {
+				continue
+			}
+			plaintext, err := c.decryptValue(v)
+			if err != nil {
+				return fmt.Errorf("could not decrypt field %s: %v", key, err)
+			}
+			items[idx] = plaintext
+		case map[string]interface{}:
+			if err := c.decrypt(v); err != nil {
+				return err
+			}
+		case []interface{}:
+			if err := c.decryptItems(key, v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// learnTags records the fields of data tagged `hop:"encrypt"`.
+func (o *indexOptions) learnTags(data interface{}) {
+	for _, f := range hopFieldsOf(data) {
+		if !f.has("encrypt") {
+			continue
+		}
+		o.mu.Lock()
+		if o.tagged == nil {
+			o.tagged = make(map[string]bool)
+		}
+		o.tagged[f.Name] = f.has("deterministic")
+		o.mu.Unlock()
+	}
+}
+
+// taggedFields returns a copy of the fields learned by learnTags.
+func (o *indexOptions) taggedFields() map[string]bool {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	tagged := make(map[string]bool, len(o.tagged))
+	for field, deterministic := range o.tagged {
+		tagged[field] = deterministic
+	}
+	return tagged
+}
+
+// encrypted reports whether field is encrypted in the index, by the
+// configuration or by a tag, and whether it is deterministic.
+func (o *indexOptions) encrypted(field string) (encrypted bool, deterministic bool) {
+	c := o.getEncryption()
+	if c == nil {
+		return false, false
+	}
+	for _, f := range c.Deterministic {
+		if f == field {
+			return true, true
+		}
+	}
+	o.mu.RLock()
+	deterministic, tagged := o.tagged[field]
+	o.mu.RUnlock()
+	if tagged {
+		return true, deterministic
+	}
+	for _, f := range c.Fields {
+		if f == field {
+			return true, false
+		}
+	}
+	return false, false
+}
+
+func seal(key, plaintext, nonceSeed []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if nonceSeed != nil {
+		copy(nonce, nonceSeed)
+	} else if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func open(key, ciphertext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, InvalidCiphertext
+	}
+	plaintext, err := gcm.Open(nil, ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, InvalidCiphertext
+	}
+	return plaintext, nil
+}
+
+func derive(key []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+func walkPath(m map[string]interface{}, path string) (map[string]interface{}, string) {
+	parts := strings.Split(path, ".")
+	for _, part := range parts[:len(parts)-1] {
+		nested, ok := m[part].(map[string]interface{})
+		if !ok {
+			return nil, ""
+		}
+		m = nested
+	}
+	return m, parts[len(parts)-1]
+}
+
+// walkPaths returns the maps holding the last key of path, going through
+// every element of the arrays on the way and every key where path has "*".
+func walkPaths(m map[string]interface{}, path string) []map[string]interface{} {
+	parents := []interface{}{m}
+	parts := strings.Split(path, ".")
+	for _, part := range parts[:len(parts)-1] {
+		next := make([]interface{}, 0)
+		for _, parent := range flatten(parents) {
+			if part == "*" {
+				for _, value := range parent {
+					next = append(next, value)
+				}
+			} else if value, ok := parent[part]; ok {
+				next = append(next, value)
+			}
+		}
+		parents = next
+	}
+	return flatten(parents)
+}
+
+// flatten returns the maps among values and inside their arrays.
+func flatten(values []interface{}) []map[string]interface{} {
+	maps := make([]map[string]interface{}, 0, len(values))
+	for _, value := range values {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			maps = append(maps, v)
+		case []interface{}:
+			maps = append(maps, flatten(v)...)
+		}
+	}
+	return maps
+}
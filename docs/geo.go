@@ -0,0 +1,243 @@
+package docs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const (
+	GeoDistanceOperator    = "geo_distance"
+	GeoBoundingBoxOperator = "geo_bounding_box"
+	GeoPolygonOperator     = "geo_polygon"
+)
+
+var InvalidGeoPoint error = errors.New("geo point must be an object, a \"lat,lon\" string, a geohash or a [lon, lat] array")
+
+type GeoPoint struct {
+	Lat float64 `json:"lat" mapstructure:"lat"`
+	Lon float64 `json:"lon" mapstructure:"lon"`
+}
+
+func (p *GeoPoint) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		return nil
+	}
+	var object struct {
+		Lat *float64 `json:"lat"`
+		Lon *float64 `json:"lon"`
+	}
+	if err := json.Unmarshal(b, &object); err == nil {
+		if object.Lat == nil || object.Lon == nil {
+			return InvalidGeoPoint
+		}
+		p.Lat, p.Lon = *object.Lat, *object.Lon
+		return nil
+	}
+
+	var array []float64
+	if err := json.Unmarshal(b, &array); err == nil {
+		if len(array) < 2 {
+			return InvalidGeoPoint
+		}
+		p.Lon, p.Lat = array[0], array[1]
+		return nil
+	}
+
+	var str string
+	if err := json.Unmarshal(b, &str); err != nil {
+		return InvalidGeoPoint
+	}
+	if parts := strings.Split(str, ","); len(parts) == 2 {
+		lat, err := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+		if err != nil {
+			return InvalidGeoPoint
+		}
+		lon, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		if err != nil {
+			return InvalidGeoPoint
+		}
+		p.Lat, p.Lon = lat, lon
+		return nil
+	}
+	point, err := DecodeGeohash(str)
+	if err != nil {
+		return err
+	}
+	*p = point
+	return nil
+}
+
+// geoPointHook lets DataTo decode every form of geo point the JSON decoder
+// accepts.
+func geoPointHook(from, to reflect.Type, data interface{}) (interface{}, error) {
+	if to != reflect.TypeOf(GeoPoint{}) {
+		return data, nil
+	}
+	b, err := json.Marshal(data)
+	if err != nil {
+		return nil, InvalidGeoPoint
+	}
+	var point GeoPoint
+	if err := point.UnmarshalJSON(b); err != nil {
+		return nil, err
+	}
+	return point, nil
+}
+
+const geohashAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// DecodeGeohash returns the center of the cell described by hash.
+func DecodeGeohash(hash string) (GeoPoint, error) {
+	if hash == "" {
+		return GeoPoint{}, InvalidGeoPoint
+	}
+	latRange := [2]float64{-90, 90}
+	lonRange := [2]float64{-180, 180}
+	even := true
+	for _, c := range strings.ToLower(hash) {
+		idx := strings.IndexRune(geohashAlphabet, c)
+		if idx < 0 {
+			return GeoPoint{}, InvalidGeoPoint
+		}
+		for bit := 4; bit >= 0; bit-- {
+			r := &latRange
+			if even {
+				r = &lonRange
+			}
+			mid := (r[0] + r[1]) / 2
+			if idx&(1<<uint(bit)) != 0 {
+				r[0] = mid
+			} else {
+				r[1] = mid
+			}
+			even = !even
+		}
+	}
+	return GeoPoint{Lat: (latRange[0] + latRange[1]) / 2, Lon: (lonRange[0] + lonRange[1]) / 2}, nil
+}
+
+type GeoDistance struct {
+	Center   GeoPoint
+	Distance string
+}
+
+type GeoBoundingBox struct {
+	TopLeft     GeoPoint
+	BottomRight GeoPoint
+}
+
+type GeoPolygon []GeoPoint
+
+func geoQuery(q Query) map[string]interface{} {
+	switch value := q.Value.(type) {
+	case GeoDistance:
+		return map[string]interface{}{
+			GeoDistanceOperator: map[string]interface{}{
+				"distance": value.Distance,
+				q.Field:    value.Center,
+			},
+		}
+	case GeoBoundingBox:
+		return map[string]interface{}{
+			GeoBoundingBoxOperator: map[string]interface{}{
+				q.Field: map[string]interface{}{
+					"top_left":     value.TopLeft,
+					"bottom_right": value.BottomRight,
+				},
+			},
+		}
+	case GeoPolygon:
+		return map[string]interface{}{
+			GeoPolygonOperator: map[string]interface{}{
+				q.Field: map[string]interface{}{
+					"points": []GeoPoint(value),
+				},
+			},
+		}
+	}
+	return map[string]interface{}{q.Operator: map[string]interface{}{q.Field: q.Value}}
+}
+
+func (i *IndexReference) WhereGeoDistance(field string, center GeoPoint, distance string) *IndexReference {
+	return i.Where(field, GeoDistanceOperator, GeoDistance{center, distance})
+}
+
+func (i *IndexReference) WhereGeoBoundingBox(field string, topLeft, bottomRight GeoPoint) *IndexReference {
+	return i.Where(field, GeoBoundingBoxOperator, GeoBoundingBox{topLeft, bottomRight})
+}
+
+func (i *IndexReference) WhereGeoPolygon(field string, points ...GeoPoint) *IndexReference {
+	return i.Where(field, GeoPolygonOperator, GeoPolygon(points))
+}
+
+// OrderByDistance sorts by distance to origin. The distance in the given
+// unit ends up as the first value of Document.Sort.
+func (i *IndexReference) OrderByDistance(field string, origin GeoPoint, unit string) *IndexReference {
+	i.sort = append(i.sort, map[string]interface{}{
+		"_geo_distance": map[string]interface{}{
+			field:           origin,
+			"order":         "asc",
+			"unit":          unit,
+			"distance_type": "arc",
+		},
+	})
+	return i
+}
+
+type GeoBucket struct {
+	Geohash  string
+	DocCount int
+	Center   GeoPoint
+}
+
+type geohashGridResponse struct {
+	Aggregations struct {
+		Grid struct {
+			Buckets []struct {
+				Key      string `json:"key"`
+				DocCount int    `json:"doc_count"`
+			} `json:"buckets"`
+		} `json:"grid"`
+	} `json:"aggregations"`
+}
+
+func (i *IndexReference) GeohashGrid(field string, precision int) ([]GeoBucket, error) {
+	body := i.CompoundBody(0, 0)
+	body.Sort = nil
+	body.Aggs = map[string]interface{}{
+		"grid": map[string]interface{}{
+			"geohash_grid": map[string]interface{}{
+				"field":     field,
+				"precision": precision,
+			},
+		},
+	}
+	jsonData, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := i.client.Post(fmt.Sprintf("/%s/_search", i.Index), jsonData)
+	if err != nil {
+		return nil, fmt.Errorf("could not get geohash grid: %v", err)
+	}
+
+	var result geohashGridResponse
+	if err := json.Unmarshal(resp, &result); err != nil {
+		return nil, err
+	}
+
+	buckets := make([]GeoBucket, 0)
+	for _, b := range result.Aggregations.Grid.Buckets {
+		center, err := DecodeGeohash(b.Key)
+		if err != nil {
+			return nil, err
+		}
+		buckets = append(buckets, GeoBucket{b.Key, b.DocCount, center})
+	}
+	return buckets, nil
+}
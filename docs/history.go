@@ -0,0 +1,211 @@
+package docs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// DeletedField marks the documents deleted from an index with soft deletes.
+const DeletedField = "_hopDeletedAt"
+
+// AuditConfig enables soft deletes and history on an index.
+type AuditConfig struct {
+	// SoftDelete makes Delete set DeletedField instead of removing the
+	// document. Queries and Get skip deleted documents unless WithDeleted is
+	// used.
+	SoftDelete bool
+	// History keeps the version replaced by every SetData, Update or Delete
+	// in HistoryIndex(index).
+	History bool
+}
+
+// Revision is a past version of a document.
+type Revision struct {
+	Id        string                 `json:"id"`
+	Version   int                    `json:"version"`
+	Operation string                 `json:"operation"`
+	Actor     string                 `json:"actor,omitempty"`
+	ChangedAt time.Time              `json:"changedAt"`
+	Source    map[string]interface{} `json:"source"`
+}
+
+// Audit enables soft deletes and history for the documents of index. Writes
+// to an index with history need the server: they are never queued offline.
+func (h *HopDoc) Audit(index string, config AuditConfig) {
+	h.options(index).setAudit(&config)
+}
+
+// HistoryIndex is the index holding the revisions of the documents of index.
+func HistoryIndex(index string) string {
+	return index + ".history"
+}
+
+type actorKey struct{}
+
+// WithActor returns a context that records actor in the revisions of the
+// writes made with it, see IndexReference.WithContext.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+func actorOf(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
+
+// WithDeleted makes the query include soft deleted documents.
+func (i *IndexReference) WithDeleted() *IndexReference {
+	i.withDeleted = true
+	return i
+}
+
+// deleted reports whether doc was soft deleted from index.
+func (o *indexOptions) deleted(doc *Document) bool {
+	if audit := o.getAudit(); audit == nil || !audit.SoftDelete || doc == nil {
+		return false
+	}
+	return doc.Source[DeletedField] != nil
+}
+
+// audited reports whether the writes of index must be recorded.
+func (o *indexOptions) audited() bool {
+	audit := o.getAudit()
+	return audit != nil && audit.History
+}
+
+// write runs write on index/id. If the index keeps history, the current
+// version of the document is recorded first and, unless the write already
+// has a condition, the write is made conditional on that version and retried
+// if the document changed in between. Revisions are stored under
+// "<id>:<version>", so recording one twice is harmless.
+func (h *HopDoc) write(client HopDocClient, index, id, operation string, o writeOptions, write func(o writeOptions) ([]byte, error)) ([]byte, error) {
+	if !h.lookup(index).audited() {
+		return write(o)
+	}
+	for attempt := 0; ; attempt++ {
+		current, err := h.current(client, index, id)
+		if err != nil {
+			return nil, err
+		}
+		attemptOptions := o
+		if !o.conditional() && current == nil && operation == SetOp {
+			attemptOptions.create = true
+		} else if !o.conditional() && current != nil {
+			attemptOptions.match, attemptOptions.seqNo, attemptOptions.primaryTerm = true, current.SeqNo, current.PrimaryTerm
+		}
+		if current != nil {
+			if err := h.record(client, index, operation, current); err != nil {
+				return nil, err
+			}
+		}
+
+		resp, err := write(attemptOptions)
+		if IsConflict(err) && !o.conditional() && attempt < 5 {
+			continue
+		}
+		return resp, err
+	}
+}
+
+// current reads index/id as stored, nil if it does not exist.
+func (h *HopDoc) current(client HopDocClient, index, id string) (*Document, error) {
+	resp, err := client.Get(fmt.Sprintf("/%s/_doc/%s", index, id))
+	if IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read the current version: %v", err)
+	}
+	var doc Document
+	if err := json.Unmarshal(resp, &doc); err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
+
+// record stores doc as a revision. The source is kept as stored, so
+// encrypted fields stay encrypted in the history.
+func (h *HopDoc) record(client HopDocClient, index, operation string, doc *Document) error {
+	b, err := json.Marshal(Revision{doc.Id, doc.Version, operation, actorOf(client.ctx), time.Now().UTC(), doc.Source})
+	if err != nil {
+		return err
+	}
+	if _, err := client.Post(fmt.Sprintf("/%s/_doc/%s:%d", HistoryIndex(index), doc.Id, doc.Version), b); err != nil {
+		return fmt.Errorf("could not record history: %v", err)
+	}
+	return nil
+}
+
+// History returns the past versions of the document, newest first.
+func (d *DocumentReference) History() ([]Revision, error) {
+	revisions := make([]Revision, 0)
+	history := d.db.Index(HistoryIndex(d.Index))
+	history.client = d.client
+	exists, err := history.Exists()
+	if err != nil || !exists {
+		return revisions, err
+	}
+	if err := history.Refresh(); err != nil {
+		return nil, err
+	}
+
+	err = history.Where("id.keyword", "==", d.key(d.Id)).OrderBy("version", true).ForEach(func(doc *Document) error {
+		var revision Revision
+		b, err := json.Marshal(doc.Source)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(b, &revision); err != nil {
+			return err
+		}
+		if err := d.db.decode(d.Index, &Document{Source: revision.Source}); err != nil {
+			return err
+		}
+		revisions = append(revisions, revision)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not list history: %v", err)
+	}
+	return revisions, nil
+}
+
+// Restore writes the source of a past version back, which also undeletes a
+// soft deleted document.
+func (d *DocumentReference) Restore(version int) DocumentSnapshot {
+	resp, err := d.client.Get(fmt.Sprintf("/%s/_doc/%s:%d", HistoryIndex(d.Index), d.key(d.Id), version))
+	if err != nil {
+		return DocumentSnapshot{Success: false, Reason: fmt.Sprintf("could not read version %d: %v", version, err), Err: err}
+	}
+	var doc struct {
+		Source Revision `json:"_source"`
+	}
+	if err := json.Unmarshal(resp, &doc); err != nil {
+		return DocumentSnapshot{Success: false, Reason: err.Error()}
+	}
+	source := doc.Source.Source
+	delete(source, DeletedField)
+	if err := d.db.decode(d.Index, &Document{Source: source}); err != nil {
+		return DocumentSnapshot{Success: false, Reason: err.Error()}
+	}
+	return d.SetData(source)
+}
+
+// softDelete marks index/id as deleted at t.
+func (h *HopDoc) softDelete(client HopDocClient, index, id string, t time.Time, o writeOptions) error {
+	b, err := json.Marshal(map[string]interface{}{"doc": map[string]interface{}{
+		DeletedField: t.UTC().Format(time.RFC3339Nano),
+	}})
+	if err != nil {
+		return err
+	}
+	_, err = h.write(client, index, id, DeleteOp, o, func(o writeOptions) ([]byte, error) {
+		return client.Post(fmt.Sprintf("/%s/_doc/%s/_update%s", index, id, o.query()), b)
+	})
+	return err
+}
@@ -3,6 +3,8 @@ package docs
 import (
 	"encoding/json"
 	"fmt"
+	"net/http"
+	"strings"
 )
 
 type Index struct {
@@ -12,9 +14,12 @@ type Index struct {
 }
 
 type IndexSnapshot struct {
-	Docs    []Document
-	Success bool
-	Reason  string
+	Docs     []Document
+	MaxScore float64
+	Success  bool
+	Reason   string
+	// Stale is set when the documents were read from the offline copy.
+	Stale bool
 }
 
 type Query struct {
@@ -24,9 +29,20 @@ type Query struct {
 }
 
 type IndexReference struct {
-	client  HopDocClient
-	Index   string
-	Queries []Query
+	client    HopDocClient
+	db        *HopDoc
+	err       error
+	Index     string
+	Queries   []Query
+	Searches  []TextQuery
+	highlight []string
+	sort      []map[string]interface{}
+	limit     int
+	// withDeleted includes soft deleted documents.
+	withDeleted bool
+	// parent is the document holding the subcollection.
+	parent *DocumentReference
+	vector *VectorQuery
 }
 
 type CompoundBody struct {
@@ -34,10 +50,14 @@ type CompoundBody struct {
 	From  int `json:"from"`
 	Query struct {
 		Bool struct {
-			Must   []map[string]interface{} `json:"must"`
-			Filter []map[string]interface{} `json:"filter"`
+			Must    []map[string]interface{} `json:"must"`
+			Filter  []map[string]interface{} `json:"filter"`
+			MustNot []map[string]interface{} `json:"must_not,omitempty"`
 		} `json:"bool"`
 	} `json:"query"`
+	Highlight *HighlightBody           `json:"highlight,omitempty"`
+	Sort      []map[string]interface{} `json:"sort,omitempty"`
+	Aggs      map[string]interface{}   `json:"aggs,omitempty"`
 }
 
 func operatorToComparison(operator string) string {
@@ -57,10 +77,19 @@ func (i *IndexReference) CompoundBody(size int, from int) CompoundBody {
 	compoundBody.Size = size
 	compoundBody.From = from
 	must := make([]map[string]interface{}, 0)
+	filter := make([]map[string]interface{}, 0)
 	for _, q := range i.Queries {
 		query := make(map[string]interface{})
 		switch q.Operator {
 		case "==":
+			// Ciphertexts must not be analyzed: their prefix and key id
+			// would match every encrypted document.
+			if isEncrypted(q.Value) {
+				query["term"] = map[string]interface{}{
+					q.Field + ".keyword": q.Value,
+				}
+				break
+			}
 			query["match"] = map[string]interface{}{
 				q.Field: q.Value,
 			}
@@ -70,44 +99,174 @@ func (i *IndexReference) CompoundBody(size int, from int) CompoundBody {
 					operatorToComparison(q.Operator): q.Value,
 				},
 			}
+		case GeoDistanceOperator, GeoBoundingBoxOperator, GeoPolygonOperator:
+			filter = append(filter, geoQuery(q))
+			continue
 		}
 		must = append(must, query)
 	}
+	if i.vector != nil {
+		// Only the searches add to the similarity, the conditions filter.
+		filter = append(filter, must...)
+		must = make([]map[string]interface{}, 0)
+	}
+	for _, s := range i.Searches {
+		must = append(must, s.query())
+	}
+	if i.vector != nil {
+		must = append(must, i.vector.query())
+	}
 	compoundBody.Query.Bool.Must = must
-	compoundBody.Query.Bool.Filter = make([]map[string]interface{}, 0)
+	if path := i.parentPath(); path != "" {
+		filter = append(filter, map[string]interface{}{"term": map[string]interface{}{ParentField + ".keyword": path}})
+	}
+	compoundBody.Query.Bool.Filter = filter
+	if audit := i.db.lookup(i.Index).getAudit(); audit != nil && audit.SoftDelete && !i.withDeleted {
+		compoundBody.Query.Bool.MustNot = []map[string]interface{}{{"exists": map[string]interface{}{"field": DeletedField}}}
+	}
+	if len(i.highlight) > 0 {
+		compoundBody.Highlight = newHighlightBody(i.highlight)
+	}
+	compoundBody.Sort = i.sort
 	return compoundBody
 }
 
 type IndexGetResponse struct {
 	Hits struct {
-		Hits []Document
+		MaxScore float64 `json:"max_score"`
+		Hits     []Document
 	}
 }
 
-func (i *IndexReference) Get() *IndexSnapshot {
-	jsonData, err := json.Marshal(i.CompoundBody(100, 0))
+// Get runs the query. See Populate for resolving the refs of the documents.
+func (i *IndexReference) Get(options ...ReadOption) *IndexSnapshot {
+	if i.err != nil {
+		return &IndexSnapshot{Success: false, Reason: i.err.Error()}
+	}
+
+	limit := i.size()
+	if store := i.db.local(i.Index, ""); store != nil {
+		return i.getLocal(store, limit)
+	}
+
+	jsonData, err := json.Marshal(i.CompoundBody(limit, 0))
 	if err != nil {
 		return &IndexSnapshot{Success: false, Reason: err.Error()}
 	}
 
-	resp, err := i.client.Post(fmt.Sprintf("/%s/_search", i.Index), jsonData)
+	resp, err := i.fetch(jsonData)
+	if store := i.db.fallback(err); store != nil {
+		return i.getLocal(store, limit)
+	}
 	if err != nil {
 		return &IndexSnapshot{Success: false, Reason: err.Error()}
 	}
+	return i.snapshot(resp, newReadOptions(options))
+}
 
+// snapshot builds the result of the search response resp.
+func (i *IndexReference) snapshot(resp []byte, o readOptions) *IndexSnapshot {
 	var result IndexGetResponse
 	json.Unmarshal(resp, &result)
+	hits := result.Hits.Hits[:0]
+	for idx := range result.Hits.Hits {
+		doc := &result.Hits.Hits[idx]
+		index := i.indexOf(doc)
+		i.db.offlineStore().save(index, doc)
+		if err := i.db.decode(index, doc); err != nil {
+			return &IndexSnapshot{Success: false, Reason: err.Error()}
+		}
+		// The soft deletes of the indexes of a pattern can not be excluded
+		// by the query.
+		if i.withDeleted || !i.db.lookup(index).deleted(doc) {
+			hits = append(hits, *doc)
+		}
+	}
 
-	return &IndexSnapshot{result.Hits.Hits, true, ""}
+	snapshot := &IndexSnapshot{Docs: unexpired(hits), MaxScore: result.Hits.MaxScore, Success: true}
+	if i.vector != nil {
+		i.vector.similarities(snapshot.Docs)
+	}
+	if o.depth > 0 {
+		docs := make([]*Document, 0, len(snapshot.Docs))
+		for idx := range snapshot.Docs {
+			docs = append(docs, &snapshot.Docs[idx])
+		}
+		if err := i.db.populate(i.client, docs, o.depth); err != nil {
+			return &IndexSnapshot{Success: false, Reason: err.Error()}
+		}
+	}
+	return snapshot
 }
 
+// indexOf returns the index the options of doc are registered under: the
+// index it was found in for patterns such as the one of CollectionGroup, and
+// i.Index otherwise, which may be an alias.
+func (i *IndexReference) indexOf(doc *Document) string {
+	if doc.Index != "" && strings.Contains(i.Index, "*") {
+		return doc.Index
+	}
+	return i.Index
+}
+
+func (i *IndexReference) getLocal(store *offlineStore, limit int) *IndexSnapshot {
+	docs, err := store.query(i)
+	if err != nil {
+		return &IndexSnapshot{Success: false, Reason: err.Error(), Stale: true}
+	}
+	if path := i.parentPath(); path != "" {
+		kept := docs[:0]
+		for _, doc := range docs {
+			if doc.Source[ParentField] == path {
+				kept = append(kept, doc)
+			}
+		}
+		docs = kept
+	}
+	if len(docs) > limit {
+		docs = docs[:limit]
+	}
+	for idx := range docs {
+		if err := i.db.decode(i.Index, &docs[idx]); err != nil {
+			return &IndexSnapshot{Success: false, Reason: err.Error(), Stale: true}
+		}
+	}
+	return &IndexSnapshot{Docs: unexpired(docs), Success: true, Stale: true}
+}
+
+// Add writes data as a new document, using the field of data tagged
+// `hop:"id"` as its id when it is set. The generated id is written back to
+// that field if data is a pointer.
 func (i *IndexReference) Add(data interface{}) DocumentSnapshot {
-	jsonData, err := json.Marshal(data)
+	if i.parent != nil {
+		// The id is needed to prefix it with the id of the parent.
+		id := documentId(data)
+		if id == "" {
+			id = newRequestID()
+		}
+		return i.Document(id).SetData(data)
+	}
+	source, jsonData, err := i.db.encode(i.Index, data)
 	if err != nil {
 		return DocumentSnapshot{Success: false, Reason: err.Error()}
 	}
 
-	resp, err := i.client.Post(fmt.Sprintf("/%s/_doc", i.Index), jsonData)
+	// Offline writes need their id before they reach the server.
+	id := documentId(data)
+	if id == "" && i.db.offlineStore() != nil {
+		id = newRequestID()
+	}
+	if i.db.queued() {
+		return i.db.queueData(SetOp, i.Index, id, jsonData, data)
+	}
+	path := fmt.Sprintf("/%s/_doc", i.Index)
+	if id != "" {
+		path += "/" + id
+	}
+	resp, err := i.client.Post(path, jsonData)
+	if i.db.fallback(err) != nil {
+		return i.db.queueData(SetOp, i.Index, id, jsonData, data)
+	}
 	if err != nil {
 		return DocumentSnapshot{Success: false, Reason: err.Error()}
 	}
@@ -118,11 +277,17 @@ func (i *IndexReference) Add(data interface{}) DocumentSnapshot {
 		return DocumentSnapshot{Success: false, Reason: err.Error()}
 	}
 
-	var result map[string]interface{}
-	json.Unmarshal(jsonData, &result)
-	document.Source = result
+	document.Source = source
+	if cache := i.db.documentCache(); cache != nil {
+		cache.store(i.Index, &document)
+	}
+	if store := i.db.offlineStore(); store != nil {
+		store.written(i.Index, document.Id, document.Version, jsonData)
+	}
+	setMetadata(data, &document)
+	i.db.matched(i.client, i.Index, &document, jsonData)
 
-	return DocumentSnapshot{&document, true, ""}
+	return DocumentSnapshot{Doc: &document, Success: true}
 }
 
 func (i *IndexReference) Delete() error {
@@ -130,11 +295,21 @@ func (i *IndexReference) Delete() error {
 }
 
 func (i *IndexReference) Document(id string) *DocumentReference {
-	return &DocumentReference{i.client, i.Index, id}
+	if i.parent != nil {
+		id = strings.TrimPrefix(id, i.parent.key(i.parent.Id)+":")
+	}
+	return &DocumentReference{i.client, i.db, i.Index, id, i.parent}
 }
 
 func (i *IndexReference) Count() (int, error) {
-	resp, err := i.client.Get(fmt.Sprintf("/%s/_count", i.Index))
+	var resp []byte
+	var err error
+	if i.parent != nil {
+		body, _ := json.Marshal(map[string]interface{}{"query": i.CompoundBody(0, 0).Query})
+		resp, err = i.client.Post(fmt.Sprintf("/%s/_count", i.Index), body)
+	} else {
+		resp, err = i.client.Get(fmt.Sprintf("/%s/_count", i.Index))
+	}
 	if err != nil {
 		return 0, fmt.Errorf("could not get index count: %v", err)
 	}
@@ -145,6 +320,112 @@ func (i *IndexReference) Count() (int, error) {
 }
 
 func (i *IndexReference) Where(field, operator string, value interface{}) *IndexReference {
+	options := i.db.lookup(i.Index)
+	if encrypted, deterministic := options.encrypted(field); encrypted && (operator != "==" || !deterministic) {
+		if i.err == nil {
+			i.err = fmt.Errorf("could not query %s: only deterministic encrypted fields support ==", field)
+		}
+	} else if encrypted {
+		ciphertext, err := options.getEncryption().encryptValue(value, true)
+		if err != nil && i.err == nil {
+			i.err = fmt.Errorf("could not encrypt query on %s: %v", field, err)
+		}
+		value = ciphertext
+	}
 	i.Queries = append(i.Queries, Query{field, operator, value})
 	return i
 }
+
+func (i *IndexReference) Limit(limit int) *IndexReference {
+	i.limit = limit
+	return i
+}
+
+func (i *IndexReference) OrderBy(field string, descending bool) *IndexReference {
+	order := "asc"
+	if descending {
+		order = "desc"
+	}
+	i.sort = append(i.sort, map[string]interface{}{
+		field: map[string]interface{}{"order": order},
+	})
+	return i
+}
+
+// Script is a painless script run by the server.
+type Script struct {
+	Source string                 `json:"source"`
+	Params map[string]interface{} `json:"params,omitempty"`
+}
+
+type byQueryResponse struct {
+	Updated  int           `json:"updated"`
+	Deleted  int           `json:"deleted"`
+	Failures []interface{} `json:"failures"`
+}
+
+// UpdateByQuery runs script on every document matching the query and returns
+// the number of updated documents.
+func (i *IndexReference) UpdateByQuery(script Script) (int, error) {
+	result, err := i.byQuery("_update_by_query", &script)
+	if err != nil {
+		return 0, fmt.Errorf("could not update by query: %v", err)
+	}
+	return result.Updated, nil
+}
+
+// DeleteByQuery deletes every document matching the query and returns the
+// number of deleted documents.
+func (i *IndexReference) DeleteByQuery() (int, error) {
+	result, err := i.byQuery("_delete_by_query", nil)
+	if err != nil {
+		return 0, fmt.Errorf("could not delete by query: %v", err)
+	}
+	return result.Deleted, nil
+}
+
+func (i *IndexReference) byQuery(endpoint string, script *Script) (*byQueryResponse, error) {
+	if i.err != nil {
+		return nil, i.err
+	}
+	body := map[string]interface{}{"query": i.CompoundBody(0, 0).Query}
+	if script != nil {
+		body["script"] = script
+	}
+	jsonData, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := i.client.Post(fmt.Sprintf("/%s/%s?refresh=true", i.Index, endpoint), jsonData)
+	if err != nil {
+		return nil, err
+	}
+	var result byQueryResponse
+	if err := json.Unmarshal(resp, &result); err != nil {
+		return nil, err
+	}
+	if len(result.Failures) > 0 {
+		return nil, fmt.Errorf("%d documents failed", len(result.Failures))
+	}
+	return &result, nil
+}
+
+func (i *IndexReference) Exists() (bool, error) {
+	_, err := i.client.do(http.MethodHead, "/"+i.Index, nil)
+	if IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("could not check index: %v", err)
+	}
+	return true, nil
+}
+
+// Refresh makes the latest writes to the index visible to searches.
+func (i *IndexReference) Refresh() error {
+	if _, err := i.client.Post(fmt.Sprintf("/%s/_refresh", i.Index), nil); err != nil {
+		return fmt.Errorf("could not refresh index: %v", err)
+	}
+	return nil
+}
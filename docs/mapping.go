@@ -0,0 +1,83 @@
+package docs
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+const (
+	TextType        = "text"
+	KeywordType     = "keyword"
+	LongType        = "long"
+	DoubleType      = "double"
+	BooleanType     = "boolean"
+	DateType        = "date"
+	ObjectType      = "object"
+	GeoPointType    = "geo_point"
+	CompletionType  = "completion"
+	DenseVectorType = "dense_vector"
+)
+
+type FieldMapping struct {
+	Type       string  `json:"type,omitempty"`
+	Properties Mapping `json:"properties,omitempty"`
+	// Dims is the number of dimensions of a dense_vector field.
+	Dims int `json:"dims,omitempty"`
+}
+
+type Mapping map[string]FieldMapping
+
+type mappingBody struct {
+	Properties Mapping `json:"properties"`
+}
+
+// Create creates the index with the given field mappings.
+func (i *IndexReference) Create(mapping Mapping) error {
+	jsonData, err := json.Marshal(map[string]interface{}{
+		"mappings": mappingBody{mapping},
+	})
+	if err != nil {
+		return err
+	}
+
+	if _, err := i.client.Put("/"+i.Index, jsonData); err != nil {
+		return fmt.Errorf("could not create index: %v", err)
+	}
+	return nil
+}
+
+// PutMapping adds new fields to the mapping of an existing index.
+func (i *IndexReference) PutMapping(mapping Mapping) error {
+	jsonData, err := json.Marshal(mappingBody{mapping})
+	if err != nil {
+		return err
+	}
+
+	if _, err := i.client.Put(fmt.Sprintf("/%s/_mapping", i.Index), jsonData); err != nil {
+		return fmt.Errorf("could not put mapping: %v", err)
+	}
+	return nil
+}
+
+func (i *IndexReference) GetMapping() (Mapping, error) {
+	resp, err := i.client.Get(fmt.Sprintf("/%s/_mapping", i.Index))
+	if err != nil {
+		return nil, fmt.Errorf("could not get mapping: %v", err)
+	}
+
+	var result map[string]struct {
+		Mappings mappingBody `json:"mappings"`
+	}
+	if err := json.Unmarshal(resp, &result); err != nil {
+		return nil, err
+	}
+
+	// The index may be an alias or a pattern, so merge every concrete index.
+	mapping := make(Mapping)
+	for _, index := range result {
+		for field, fieldMapping := range index.Mappings.Properties {
+			mapping[field] = fieldMapping
+		}
+	}
+	return mapping, nil
+}
@@ -0,0 +1,196 @@
+package docs
+
+import (
+	"reflect"
+	"time"
+
+	"github.com/mitchellh/mapstructure"
+)
+
+// metadata reports whether f is filled from the document metadata by
+// DataTo. Such fields are never written to the source.
+func (f hopField) metadata() bool {
+	return f.has("id") || f.has("index") || f.has("version")
+}
+
+// field returns the value of f in v, which must be a struct. ok is false when
+// f lives in a nil embedded pointer.
+func (f hopField) field(v reflect.Value) (reflect.Value, bool) {
+	for n, i := range f.Index {
+		if n > 0 {
+			for v.Kind() == reflect.Ptr {
+				if v.IsNil() {
+					return reflect.Value{}, false
+				}
+				v = v.Elem()
+			}
+		}
+		v = v.Field(i)
+	}
+	return v, true
+}
+
+func structValue(data interface{}) (reflect.Value, bool) {
+	v := reflect.ValueOf(data)
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return reflect.Value{}, false
+		}
+		v = v.Elem()
+	}
+	return v, v.Kind() == reflect.Struct
+}
+
+// documentId returns the value of the field tagged as the document id.
+func documentId(data interface{}) string {
+	v, ok := structValue(data)
+	if !ok {
+		return ""
+	}
+	for _, f := range hopFieldsOf(data) {
+		if f.nested() || !f.has("id") {
+			continue
+		}
+		if field, ok := f.field(v); ok && field.Kind() == reflect.String {
+			return field.String()
+		}
+	}
+	return ""
+}
+
+// stamp removes the metadata fields of data from source and sets its auto
+// timestamps, on the struct too when data is a pointer. It reports whether
+// source was changed.
+func stamp(data interface{}, source map[string]interface{}, now time.Time) bool {
+	v, ok := structValue(data)
+	if !ok {
+		return false
+	}
+	changed := false
+	for _, f := range hopFieldsOf(data) {
+		if f.nested() {
+			continue
+		}
+		if f.metadata() {
+			if _, ok := source[f.Name]; ok {
+				delete(source, f.Name)
+				changed = true
+			}
+			continue
+		}
+		// createdAt is only stamped while it is zero.
+		if !f.has("auto") || !(f.has("createdAt") || f.has("updatedAt")) {
+			continue
+		}
+		field, ok := f.field(v)
+		if !ok {
+			continue
+		}
+		current, ok := timeValue(field)
+		if !ok || (f.has("createdAt") && !current.IsZero()) {
+			continue
+		}
+		source[f.Name] = now.Format(time.RFC3339Nano)
+		changed = true
+		setTime(field, now)
+	}
+	return changed
+}
+
+// learnStamps records the fields of data tagged `hop:"updatedAt,auto"`, so
+// that partial updates of the index stamp them as well.
+func (h *HopDoc) learnStamps(index string, data interface{}) {
+	for _, f := range hopFieldsOf(data) {
+		if h == nil || f.nested() || !f.has("updatedAt") || !f.has("auto") {
+			continue
+		}
+		o := h.options(index)
+		o.mu.Lock()
+		if o.updatedAt == nil {
+			o.updatedAt = make(map[string]bool)
+		}
+		o.updatedAt[f.Name] = true
+		o.mu.Unlock()
+	}
+}
+
+// stampUpdate sets the learned updatedAt fields that a partial update does
+// not set itself. It reports whether source was changed.
+func (o *indexOptions) stampUpdate(source map[string]interface{}, now time.Time) bool {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	changed := false
+	for name := range o.updatedAt {
+		if _, ok := source[name]; !ok {
+			source[name] = now.Format(time.RFC3339Nano)
+			changed = true
+		}
+	}
+	return changed
+}
+
+func timeValue(v reflect.Value) (time.Time, bool) {
+	switch {
+	case v.Type() == timeType:
+		return v.Interface().(time.Time), true
+	case v.Kind() == reflect.Ptr && v.Type().Elem() == timeType:
+		if v.IsNil() {
+			return time.Time{}, true
+		}
+		return v.Elem().Interface().(time.Time), true
+	}
+	return time.Time{}, false
+}
+
+func setTime(v reflect.Value, t time.Time) {
+	if !v.CanSet() {
+		return
+	}
+	if v.Kind() == reflect.Ptr {
+		v.Set(reflect.ValueOf(&t))
+		return
+	}
+	v.Set(reflect.ValueOf(t))
+}
+
+// setMetadata fills the metadata fields of data, if it is a pointer to a
+// struct, from doc.
+func setMetadata(data interface{}, doc *Document) {
+	if doc == nil {
+		return
+	}
+	v, ok := structValue(data)
+	if !ok {
+		return
+	}
+	for _, f := range hopFieldsOf(data) {
+		if f.nested() {
+			continue
+		}
+		field, ok := f.field(v)
+		if !ok || !field.CanSet() {
+			continue
+		}
+		switch {
+		case f.has("id") && field.Kind() == reflect.String:
+			field.SetString(doc.Id)
+		case f.has("index") && field.Kind() == reflect.String:
+			field.SetString(doc.Index)
+		case f.has("version") && field.Kind() >= reflect.Int && field.Kind() <= reflect.Int64:
+			field.SetInt(int64(doc.Version))
+		case f.has("version") && field.Kind() >= reflect.Uint && field.Kind() <= reflect.Uint64:
+			field.SetUint(uint64(doc.Version))
+		}
+	}
+}
+
+func decodeSource(source map[string]interface{}, out interface{}) error {
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook: mapstructure.ComposeDecodeHookFunc(mapstructure.StringToTimeHookFunc(time.RFC3339Nano), geoPointHook),
+		Result:     out,
+	})
+	if err != nil {
+		return err
+	}
+	return decoder.Decode(source)
+}
@@ -0,0 +1,321 @@
+package docs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// MigrationDiverged is returned by Rollback once the target has been written
+// to through the alias, since deleting it would lose those writes.
+var MigrationDiverged error = errors.New("the migration target was written to after the alias moved")
+
+// MigrationConfig describes the move of the documents behind an alias to a
+// new index. Readers and writers keep using the alias, which IndexReference
+// accepts like any index name.
+type MigrationConfig struct {
+	// Alias is the name the application uses, e.g. "orders".
+	Alias string
+	// Target is the new index, e.g. "orders_v2". It must not exist.
+	Target  string
+	Mapping Mapping
+	// Source defaults to the index the alias points to.
+	Source string
+	// Transform is applied to every document in Go instead of letting the
+	// server reindex. Returning a nil document drops it. Documents are
+	// decrypted before and encrypted again after the transform using the
+	// options of the alias.
+	Transform func(doc *Document) (*Document, error)
+	// Progress is called periodically with the number of copied documents.
+	Progress func(copied, total int)
+	// PollInterval is how often the reindex task is checked, 1s by default.
+	PollInterval time.Duration
+}
+
+type Migration struct {
+	Alias  string
+	Source string
+	Target string
+	Copied int
+	db     *HopDoc
+	// seqNo is the sequence number of the target when the alias moved.
+	seqNo int64
+}
+
+// Migrate creates the target index, copies the documents of the source into
+// it and atomically moves the alias to it. Writes to the source are blocked
+// from the start of the copy until the alias moves so that none is lost, so
+// writers get an error for as long as the whole reindex or transform takes:
+// plan large migrations for a maintenance window. Reads keep working. If the
+// copy fails the target is deleted and the alias is left untouched.
+func (h *HopDoc) Migrate(config MigrationConfig) (*Migration, error) {
+	m := &Migration{Alias: config.Alias, Source: config.Source, Target: config.Target, db: h}
+	if m.Source == "" {
+		indexes, err := h.aliasIndexes(config.Alias)
+		if err != nil {
+			return nil, err
+		}
+		if len(indexes) != 1 {
+			return nil, fmt.Errorf("alias %s points to %d indexes, set the migration source", config.Alias, len(indexes))
+		}
+		m.Source = indexes[0]
+	}
+
+	if err := h.Index(m.Target).Create(config.Mapping); err != nil {
+		return nil, err
+	}
+
+	err := h.blockWrites(m.Source, true)
+	if err == nil && config.Transform != nil {
+		err = m.transform(config)
+	} else if err == nil {
+		err = m.reindex(config)
+	}
+	if err == nil {
+		err = h.Index(m.Target).Refresh()
+	}
+	if err == nil {
+		m.seqNo, err = h.maxSeqNo(m.Target)
+	}
+	if err == nil {
+		err = h.swapAlias(m.Alias, m.Source, m.Target)
+	}
+	if unblockErr := h.blockWrites(m.Source, false); err == nil && unblockErr != nil {
+		return m, unblockErr
+	}
+	if err != nil {
+		if deleteErr := h.Index(m.Target).Delete(); deleteErr != nil {
+			return nil, fmt.Errorf("%v (could not delete %s: %v)", err, m.Target, deleteErr)
+		}
+		return nil, err
+	}
+	return m, nil
+}
+
+// Rollback points the alias back to the source and deletes the target. It
+// returns MigrationDiverged and changes nothing if the target was written to
+// since the migration.
+func (m *Migration) Rollback() error {
+	if err := m.db.blockWrites(m.Target, true); err != nil {
+		return err
+	}
+	seqNo, err := m.db.maxSeqNo(m.Target)
+	if err == nil && seqNo != m.seqNo {
+		err = MigrationDiverged
+	}
+	if err == nil {
+		err = m.db.swapAlias(m.Alias, m.Target, m.Source)
+	}
+	if err != nil {
+		m.db.blockWrites(m.Target, false)
+		return err
+	}
+	return m.db.Index(m.Target).Delete()
+}
+
+// Cleanup deletes the source index once the migration is no longer needed.
+func (m *Migration) Cleanup() error {
+	return m.db.Index(m.Source).Delete()
+}
+
+func (m *Migration) reindex(config MigrationConfig) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"source": map[string]interface{}{"index": m.Source},
+		"dest":   map[string]interface{}{"index": m.Target},
+	})
+	if err != nil {
+		return err
+	}
+	resp, err := m.db.client.Post("/_reindex?wait_for_completion=false", body)
+	if err != nil {
+		return fmt.Errorf("could not start reindex: %v", err)
+	}
+	var started struct {
+		Task string `json:"task"`
+	}
+	if err := json.Unmarshal(resp, &started); err != nil {
+		return err
+	}
+
+	interval := config.PollInterval
+	if interval == 0 {
+		interval = time.Second
+	}
+	for {
+		status, err := m.db.task(started.Task)
+		if err != nil {
+			return err
+		}
+		m.Copied = status.Task.Status.Created + status.Task.Status.Updated
+		if config.Progress != nil {
+			config.Progress(m.Copied, status.Task.Status.Total)
+		}
+		if status.Completed {
+			if status.Error != nil {
+				return fmt.Errorf("reindex failed: %s", status.Error.Reason)
+			}
+			if len(status.Response.Failures) > 0 {
+				return fmt.Errorf("reindex failed for %d documents", len(status.Response.Failures))
+			}
+			return nil
+		}
+		time.Sleep(interval)
+	}
+}
+
+func (m *Migration) transform(config MigrationConfig) error {
+	total, err := m.db.Index(m.Source).Count()
+	if err != nil {
+		return err
+	}
+	writer := m.db.Index(m.Target).BulkWriter()
+	writer.options = m.Alias
+
+	body := map[string]interface{}{
+		"size": scrollPageSize,
+		"sort": []map[string]interface{}{{"_doc": map[string]interface{}{"order": "asc"}}},
+	}
+	err = scroll(m.db.client, m.Source, body, func(doc *Document) error {
+		if err := m.db.decode(m.Alias, doc); err != nil {
+			return err
+		}
+		transformed, err := config.Transform(doc)
+		if err != nil {
+			return fmt.Errorf("could not transform %s: %v", doc.Id, err)
+		}
+		m.Copied++
+		if transformed == nil {
+			return nil
+		}
+		if err := writer.Set(transformed.Id, transformed.Source); err != nil {
+			return err
+		}
+		if config.Progress != nil && m.Copied%scrollPageSize == 0 {
+			config.Progress(m.Copied, total)
+		}
+		return nil
+	})
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil && config.Progress != nil {
+		config.Progress(m.Copied, total)
+	}
+	return err
+}
+
+type taskStatus struct {
+	Completed bool `json:"completed"`
+	Task      struct {
+		Status struct {
+			Total   int `json:"total"`
+			Created int `json:"created"`
+			Updated int `json:"updated"`
+		} `json:"status"`
+	} `json:"task"`
+	Error *struct {
+		Reason string `json:"reason"`
+	} `json:"error"`
+	Response struct {
+		Failures []interface{} `json:"failures"`
+	} `json:"response"`
+}
+
+func (h *HopDoc) task(id string) (*taskStatus, error) {
+	resp, err := h.client.Get("/_tasks/" + id)
+	if err != nil {
+		return nil, fmt.Errorf("could not get task %s: %v", id, err)
+	}
+	var status taskStatus
+	if err := json.Unmarshal(resp, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// aliasIndexes returns the indexes alias points to.
+func (h *HopDoc) aliasIndexes(alias string) ([]string, error) {
+	resp, err := h.client.Get("/_alias/" + alias)
+	if IsNotFound(err) {
+		return nil, fmt.Errorf("alias %s does not exist", alias)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not get alias: %v", err)
+	}
+	var result map[string]interface{}
+	if err := json.Unmarshal(resp, &result); err != nil {
+		return nil, err
+	}
+	indexes := make([]string, 0, len(result))
+	for index := range result {
+		indexes = append(indexes, index)
+	}
+	return indexes, nil
+}
+
+// blockWrites makes index read-only, or writable again.
+func (h *HopDoc) blockWrites(index string, block bool) error {
+	var value interface{}
+	if block {
+		value = true
+	}
+	body, err := json.Marshal(map[string]interface{}{"index.blocks.write": value})
+	if err != nil {
+		return err
+	}
+	if _, err := h.client.Put(fmt.Sprintf("/%s/_settings", index), body); err != nil {
+		return fmt.Errorf("could not change the write block of %s: %v", index, err)
+	}
+	return nil
+}
+
+// maxSeqNo sums the highest sequence number of every primary shard of
+// index, which changes with every write.
+func (h *HopDoc) maxSeqNo(index string) (int64, error) {
+	resp, err := h.client.Get(fmt.Sprintf("/%s/_stats?level=shards", index))
+	if err != nil {
+		return 0, fmt.Errorf("could not get the stats of %s: %v", index, err)
+	}
+	var result struct {
+		Indices map[string]struct {
+			Shards map[string][]struct {
+				Routing struct {
+					Primary bool `json:"primary"`
+				} `json:"routing"`
+				SeqNo struct {
+					MaxSeqNo int64 `json:"max_seq_no"`
+				} `json:"seq_no"`
+			} `json:"shards"`
+		} `json:"indices"`
+	}
+	if err := json.Unmarshal(resp, &result); err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, stats := range result.Indices {
+		for _, copies := range stats.Shards {
+			for _, copy := range copies {
+				if copy.Routing.Primary {
+					total += copy.SeqNo.MaxSeqNo
+				}
+			}
+		}
+	}
+	return total, nil
+}
+
+// swapAlias moves alias from one index to another in a single request.
+func (h *HopDoc) swapAlias(alias, from, to string) error {
+	body, err := json.Marshal(map[string]interface{}{"actions": []map[string]interface{}{
+		{"remove": map[string]interface{}{"index": from, "alias": alias}},
+		{"add": map[string]interface{}{"index": to, "alias": alias}},
+	}})
+	if err != nil {
+		return err
+	}
+	if _, err := h.client.Post("/_aliases", body); err != nil {
+		return fmt.Errorf("could not swap alias: %v", err)
+	}
+	return nil
+}
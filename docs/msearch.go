@@ -0,0 +1,225 @@
+package docs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// MultiSearch runs the queries in a single _msearch request and returns
+// their results in the same order. The failure of one query is reported in
+// its snapshot and does not affect the others.
+func (h *HopDoc) MultiSearch(queries ...*IndexReference) []*IndexSnapshot {
+	snapshots := make([]*IndexSnapshot, len(queries))
+	requests := make([]searchRequest, 0, len(queries))
+	positions := make([]int, 0, len(queries))
+	for idx, query := range queries {
+		if query.err != nil {
+			snapshots[idx] = &IndexSnapshot{Success: false, Reason: query.err.Error()}
+			continue
+		}
+		if store := h.local(query.Index, ""); store != nil {
+			snapshots[idx] = query.getLocal(store, query.size())
+			continue
+		}
+		body, err := json.Marshal(query.CompoundBody(query.size(), 0))
+		if err != nil {
+			snapshots[idx] = &IndexSnapshot{Success: false, Reason: err.Error()}
+			continue
+		}
+		requests = append(requests, searchRequest{query.Index, body})
+		positions = append(positions, idx)
+	}
+	if len(requests) == 0 {
+		return snapshots
+	}
+
+	responses, errs, err := h.msearch(h.client, requests)
+	store := h.fallback(err)
+	for n, idx := range positions {
+		query := queries[idx]
+		switch {
+		case store != nil:
+			snapshots[idx] = query.getLocal(store, query.size())
+		case err != nil:
+			snapshots[idx] = &IndexSnapshot{Success: false, Reason: err.Error()}
+		case errs[n] != nil:
+			snapshots[idx] = &IndexSnapshot{Success: false, Reason: errs[n].Error()}
+		default:
+			snapshots[idx] = query.snapshot(responses[n], readOptions{})
+		}
+	}
+	return snapshots
+}
+
+// size is the number of documents a query returns.
+func (i *IndexReference) size() int {
+	if i.limit == 0 {
+		return 100
+	}
+	return i.limit
+}
+
+type searchRequest struct {
+	index string
+	body  []byte
+}
+
+// msearch sends requests as one _msearch request. It returns the response of
+// every request, or the error the server gave for it.
+func (h *HopDoc) msearch(client HopDocClient, requests []searchRequest) ([][]byte, []error, error) {
+	var body bytes.Buffer
+	for _, request := range requests {
+		header, err := json.Marshal(map[string]string{"index": request.index})
+		if err != nil {
+			return nil, nil, err
+		}
+		body.Write(header)
+		body.WriteByte('\n')
+		body.Write(request.body)
+		body.WriteByte('\n')
+	}
+	resp, err := client.Post("/_msearch", body.Bytes())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var result struct {
+		Responses []json.RawMessage `json:"responses"`
+	}
+	if err := json.Unmarshal(resp, &result); err != nil {
+		return nil, nil, err
+	}
+	if len(result.Responses) != len(requests) {
+		return nil, nil, fmt.Errorf("could not multi search: got %d responses for %d requests", len(result.Responses), len(requests))
+	}
+	responses := make([][]byte, len(requests))
+	errs := make([]error, len(requests))
+	for idx, raw := range result.Responses {
+		var failure struct {
+			Status int             `json:"status"`
+			Error  json.RawMessage `json:"error"`
+		}
+		json.Unmarshal(raw, &failure)
+		if failure.Error != nil {
+			errs[idx] = &StatusError{Method: http.MethodPost, StatusCode: failure.Status,
+				Status: fmt.Sprintf("%d %s", failure.Status, http.StatusText(failure.Status)), Body: failure.Error}
+			continue
+		}
+		responses[idx] = raw
+	}
+	return responses, errs, nil
+}
+
+type SearchBatchConfig struct {
+	// Window is how long the first Get of a batch waits for others, 10ms by
+	// default.
+	Window time.Duration
+	// MaxBatch sends the batch as soon as it has this many searches, 50 by
+	// default.
+	MaxBatch int
+}
+
+// EnableSearchBatching coalesces the IndexReference.Get calls made within
+// the window into a single _msearch request. Queries made with a context,
+// see IndexReference.WithContext, are sent on their own.
+func (h *HopDoc) EnableSearchBatching(config SearchBatchConfig) {
+	if config.Window == 0 {
+		config.Window = 10 * time.Millisecond
+	}
+	if config.MaxBatch == 0 {
+		config.MaxBatch = 50
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.batcher = &searchBatcher{db: h, config: config}
+}
+
+func (h *HopDoc) searchBatcher() *searchBatcher {
+	if h == nil {
+		return nil
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.batcher
+}
+
+// fetch posts the search body of the query, batched with others if enabled.
+func (i *IndexReference) fetch(body []byte) ([]byte, error) {
+	if batcher := i.db.searchBatcher(); batcher != nil && i.client.ctx == nil {
+		return batcher.search(searchRequest{i.Index, body})
+	}
+	return i.client.Post(fmt.Sprintf("/%s/_search", i.Index), body)
+}
+
+type searchBatcher struct {
+	db      *HopDoc
+	config  SearchBatchConfig
+	mu      sync.Mutex
+	pending []*pendingSearch
+	timer   *time.Timer
+}
+
+type pendingSearch struct {
+	request searchRequest
+	resp    []byte
+	err     error
+	done    chan struct{}
+}
+
+// search adds request to the current batch and waits for its response.
+func (b *searchBatcher) search(request searchRequest) ([]byte, error) {
+	search := &pendingSearch{request: request, done: make(chan struct{})}
+	b.mu.Lock()
+	b.pending = append(b.pending, search)
+	if len(b.pending) >= b.config.MaxBatch {
+		batch := b.take()
+		b.mu.Unlock()
+		b.flush(batch)
+	} else {
+		if len(b.pending) == 1 {
+			b.timer = time.AfterFunc(b.config.Window, func() {
+				b.mu.Lock()
+				batch := b.take()
+				b.mu.Unlock()
+				b.flush(batch)
+			})
+		}
+		b.mu.Unlock()
+	}
+	<-search.done
+	return search.resp, search.err
+}
+
+// take empties the current batch. It must be called with mu held.
+func (b *searchBatcher) take() []*pendingSearch {
+	batch := b.pending
+	b.pending = nil
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	return batch
+}
+
+func (b *searchBatcher) flush(batch []*pendingSearch) {
+	if len(batch) == 0 {
+		return
+	}
+	requests := make([]searchRequest, 0, len(batch))
+	for _, search := range batch {
+		requests = append(requests, search.request)
+	}
+	responses, errs, err := b.db.msearch(b.db.client, requests)
+	for idx, search := range batch {
+		if err != nil {
+			search.err = err
+		} else {
+			search.resp, search.err = responses[idx], errs[idx]
+		}
+		close(search.done)
+	}
+}
@@ -0,0 +1,225 @@
+package docs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Logger is satisfied by *slog.Logger as well as by thin adapters around
+// other structured loggers. Arguments are alternating keys and values.
+type Logger interface {
+	Info(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+func Logging(logger Logger) Interceptor {
+	return func(req *Request, next Handler) (*Response, error) {
+		start := time.Now()
+		resp, err := next(req)
+
+		args := []interface{}{
+			"request_id", req.Header.Get("X-Request-Id"),
+			"index", req.Index,
+			"op", req.Operation,
+			"method", req.Method,
+			"path", req.Path,
+			"status", statusCode(resp),
+			"latency", time.Since(start),
+			"retries", req.Attempt,
+			"request_bytes", len(req.Body),
+			"response_bytes", responseSize(resp),
+		}
+		if err != nil {
+			logger.Error("hop docs request failed", append(args, "error", err.Error())...)
+		} else {
+			logger.Info("hop docs request", args...)
+		}
+		return resp, err
+	}
+}
+
+// MetricsRecorder receives one observation per request.
+type MetricsRecorder interface {
+	ObserveRequest(index, operation string, status int, latency time.Duration, requestBytes, responseBytes int)
+}
+
+func Instrument(recorder MetricsRecorder) Interceptor {
+	return func(req *Request, next Handler) (*Response, error) {
+		start := time.Now()
+		resp, err := next(req)
+		recorder.ObserveRequest(req.Index, req.Operation, statusCode(resp), time.Since(start), len(req.Body), responseSize(resp))
+		return resp, err
+	}
+}
+
+var DefaultLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// RequestMetrics keeps Prometheus-style counters and latency histograms in
+// memory and serves them in the Prometheus text format.
+type RequestMetrics struct {
+	mu        sync.Mutex
+	buckets   []float64
+	requests  map[metricLabels]float64
+	bytes     map[metricLabels]float64
+	latencies map[metricLabels]*histogram
+}
+
+type metricLabels struct {
+	index     string
+	operation string
+	status    string
+}
+
+type histogram struct {
+	counts []float64
+	sum    float64
+	count  float64
+}
+
+func NewRequestMetrics() *RequestMetrics {
+	return &RequestMetrics{
+		buckets:   DefaultLatencyBuckets,
+		requests:  make(map[metricLabels]float64),
+		bytes:     make(map[metricLabels]float64),
+		latencies: make(map[metricLabels]*histogram),
+	}
+}
+
+func (m *RequestMetrics) ObserveRequest(index, operation string, status int, latency time.Duration, requestBytes, responseBytes int) {
+	labels := metricLabels{index, operation, strconv.Itoa(status)}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[labels]++
+	m.bytes[labels] += float64(requestBytes + responseBytes)
+
+	h, ok := m.latencies[labels]
+	if !ok {
+		h = &histogram{counts: make([]float64, len(m.buckets))}
+		m.latencies[labels] = h
+	}
+	seconds := latency.Seconds()
+	for i, bound := range m.buckets {
+		if seconds <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += seconds
+	h.count++
+}
+
+func (m *RequestMetrics) WritePrometheus(w io.Writer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintln(w, "# HELP hop_docs_requests_total Requests sent to Hop Docs.")
+	fmt.Fprintln(w, "# TYPE hop_docs_requests_total counter")
+	for _, l := range sortedLabels(m.requests) {
+		fmt.Fprintf(w, "hop_docs_requests_total{%s} %v\n", l.format(), m.requests[l])
+	}
+
+	fmt.Fprintln(w, "# HELP hop_docs_bytes_total Bytes sent to and received from Hop Docs.")
+	fmt.Fprintln(w, "# TYPE hop_docs_bytes_total counter")
+	for _, l := range sortedLabels(m.bytes) {
+		fmt.Fprintf(w, "hop_docs_bytes_total{%s} %v\n", l.format(), m.bytes[l])
+	}
+
+	fmt.Fprintln(w, "# HELP hop_docs_request_duration_seconds Latency of Hop Docs requests.")
+	fmt.Fprintln(w, "# TYPE hop_docs_request_duration_seconds histogram")
+	for _, l := range sortedLabels(m.requests) {
+		h := m.latencies[l]
+		for i, bound := range m.buckets {
+			fmt.Fprintf(w, "hop_docs_request_duration_seconds_bucket{%s,le=\"%v\"} %v\n", l.format(), bound, h.counts[i])
+		}
+		fmt.Fprintf(w, "hop_docs_request_duration_seconds_bucket{%s,le=\"+Inf\"} %v\n", l.format(), h.count)
+		fmt.Fprintf(w, "hop_docs_request_duration_seconds_sum{%s} %v\n", l.format(), h.sum)
+		_, err := fmt.Fprintf(w, "hop_docs_request_duration_seconds_count{%s} %v\n", l.format(), h.count)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *RequestMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m.WritePrometheus(w)
+}
+
+func (l metricLabels) format() string {
+	return fmt.Sprintf("index=%q,op=%q,status=%q", l.index, l.operation, l.status)
+}
+
+func sortedLabels(m map[metricLabels]float64) []metricLabels {
+	labels := make([]metricLabels, 0, len(m))
+	for l := range m {
+		labels = append(labels, l)
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		return labels[i].format() < labels[j].format()
+	})
+	return labels
+}
+
+// Span and Tracer are the subset of OpenTelemetry used by the client, so an
+// OpenTelemetry tracer only needs a small adapter.
+type Span interface {
+	SetAttributes(attributes map[string]interface{})
+	RecordError(err error)
+	End()
+	// TraceParent returns the W3C traceparent header of the span.
+	TraceParent() string
+}
+
+type Tracer interface {
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+func Tracing(tracer Tracer) Interceptor {
+	return func(req *Request, next Handler) (*Response, error) {
+		ctx, span := tracer.Start(req.Context, "hopdocs."+req.Operation)
+		defer span.End()
+
+		req.Context = ctx
+		if traceParent := span.TraceParent(); traceParent != "" {
+			req.Header.Set("traceparent", traceParent)
+		}
+
+		resp, err := next(req)
+		span.SetAttributes(map[string]interface{}{
+			"db.system":            "elasticsearch",
+			"db.operation":         req.Operation,
+			"hop.docs.index":       req.Index,
+			"hop.request_id":       req.Header.Get("X-Request-Id"),
+			"http.method":          req.Method,
+			"http.status_code":     statusCode(resp),
+			"hop.retries":          req.Attempt,
+			"http.request_length":  len(req.Body),
+			"http.response_length": responseSize(resp),
+		})
+		if err != nil {
+			span.RecordError(err)
+		}
+		return resp, err
+	}
+}
+
+func statusCode(resp *Response) int {
+	if resp == nil {
+		return 0
+	}
+	return resp.StatusCode
+}
+
+func responseSize(resp *Response) int {
+	if resp == nil {
+		return 0
+	}
+	return len(resp.Body)
+}
@@ -0,0 +1,859 @@
+package docs
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+const (
+	SetOp    = "set"
+	UpdateOp = "update"
+	DeleteOp = "delete"
+)
+
+var UnsupportedOfflineQuery error = errors.New("query can not be evaluated on the local copy")
+var NotAvailableOffline error = errors.New("document is not available offline")
+
+// Change is a write kept in the local journal until it reaches the server.
+// Data holds the payload exactly as it will be sent, so encrypted fields stay
+// encrypted on disk.
+type Change struct {
+	Seq         uint64          `json:"seq"`
+	Op          string          `json:"op"`
+	Index       string          `json:"index"`
+	Id          string          `json:"id"`
+	Data        json.RawMessage `json:"data,omitempty"`
+	BaseVersion int             `json:"baseVersion"`
+	At          time.Time       `json:"at"`
+}
+
+// ConflictResolver is called during replay when the server version of a
+// document is not the one the local change was based on. It returns the
+// change to apply, or nil to drop it.
+type ConflictResolver func(local Change, remote *Document) (*Change, error)
+
+func LocalWins(local Change, remote *Document) (*Change, error) {
+	return &local, nil
+}
+
+func RemoteWins(local Change, remote *Document) (*Change, error) {
+	return nil, nil
+}
+
+type OfflineConfig struct {
+	Dir string
+	// Resolver defaults to LocalWins.
+	Resolver ConflictResolver
+	// SyncInterval is how often pending changes are retried, 30s by default.
+	SyncInterval time.Duration
+	// OnRejected is called for changes the server refused or the resolver
+	// failed on. They are moved from the journal to a dead-letter file, see
+	// RejectedChanges.
+	OnRejected func(change Change, err error)
+}
+
+// RejectedChange is a change that was moved out of the journal because it
+// could not be applied.
+type RejectedChange struct {
+	Change
+	Reason string `json:"reason"`
+}
+
+// conflictRetries is how many times a change is resolved again when the
+// document changes between the read and the write of the replay.
+const conflictRetries = 3
+
+type offlineStore struct {
+	config  OfflineConfig
+	mu      sync.Mutex
+	pending []Change
+	seq     uint64
+	syncing sync.Mutex
+	stop    chan struct{}
+	done    chan struct{}
+	once    sync.Once
+}
+
+// EnableOffline keeps a durable journal of the writes that could not reach
+// the server and replays them in order once it is reachable again. Reads fall
+// back to the last synced copy of the documents, where "==" matches strings
+// sharing a lowercased word, like the analyzed match sent to the server
+// does with the standard analyzer. Calling it again replaces the previous
+// configuration.
+func (h *HopDoc) EnableOffline(config OfflineConfig) error {
+	if config.Resolver == nil {
+		config.Resolver = LocalWins
+	}
+	if config.SyncInterval == 0 {
+		config.SyncInterval = 30 * time.Second
+	}
+	if err := os.MkdirAll(filepath.Join(config.Dir, "docs"), 0700); err != nil {
+		return fmt.Errorf("could not create offline directory: %v", err)
+	}
+
+	// The previous store is stopped first so that it does not write to the
+	// journal while it is loaded.
+	h.mu.Lock()
+	previous := h.offline
+	h.offline = nil
+	h.mu.Unlock()
+	if previous != nil {
+		previous.close()
+	}
+
+	s := &offlineStore{config: config, stop: make(chan struct{}), done: make(chan struct{})}
+	if err := s.load(); err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	h.offline = s
+	h.mu.Unlock()
+
+	go s.run(h)
+	return nil
+}
+
+// Sync replays the pending changes now. It returns an error if the server
+// is still unreachable.
+func (h *HopDoc) Sync() error {
+	s := h.offlineStore()
+	if s == nil {
+		return nil
+	}
+	return s.replay(h)
+}
+
+func (h *HopDoc) PendingChanges() []Change {
+	s := h.offlineStore()
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Change(nil), s.pending...)
+}
+
+// RejectedChanges returns the changes that could not be applied, oldest
+// first. They are kept until ClearRejected is called.
+func (h *HopDoc) RejectedChanges() ([]RejectedChange, error) {
+	s := h.offlineStore()
+	if s == nil {
+		return nil, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	file, err := os.Open(s.rejectedFile())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	rejected := make([]RejectedChange, 0)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var change RejectedChange
+		if err := json.Unmarshal(scanner.Bytes(), &change); err != nil {
+			continue
+		}
+		rejected = append(rejected, change)
+	}
+	return rejected, scanner.Err()
+}
+
+// ClearRejected deletes the rejected changes.
+func (h *HopDoc) ClearRejected() error {
+	s := h.offlineStore()
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.Remove(s.rejectedFile()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (h *HopDoc) offlineStore() *offlineStore {
+	if h == nil {
+		return nil
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.offline
+}
+
+// unreachable tells network failures, which are retried later, apart from
+// errors returned by the server.
+func unreachable(err error) bool {
+	if err == nil {
+		return false
+	}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= http.StatusInternalServerError
+	}
+	return true
+}
+
+func (s *offlineStore) run(h *HopDoc) {
+	defer close(s.done)
+	ticker := time.NewTicker(s.config.SyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			if s.hasPending() {
+				s.replay(h)
+			}
+		}
+	}
+}
+
+func (s *offlineStore) close() {
+	s.once.Do(func() {
+		close(s.stop)
+		<-s.done
+	})
+}
+
+func (s *offlineStore) journalFile() string {
+	return filepath.Join(s.config.Dir, "journal.ndjson")
+}
+
+func (s *offlineStore) rejectedFile() string {
+	return filepath.Join(s.config.Dir, "rejected.ndjson")
+}
+
+func (s *offlineStore) load() error {
+	file, err := os.Open(s.journalFile())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var change Change
+		// A torn last line means the process died while appending it, so the
+		// write was never acknowledged and can be ignored.
+		if err := json.Unmarshal(scanner.Bytes(), &change); err != nil {
+			continue
+		}
+		s.pending = append(s.pending, change)
+		if change.Seq > s.seq {
+			s.seq = change.Seq
+		}
+	}
+	return scanner.Err()
+}
+
+func (s *offlineStore) hasPending() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.pending) > 0
+}
+
+func (s *offlineStore) pendingFor(index, id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, change := range s.pending {
+		if change.Index == index && (id == "" || change.Id == id) {
+			return true
+		}
+	}
+	return false
+}
+
+// queue journals a write that can not be sent now and returns the document
+// as it will look once the write is synced.
+func (h *HopDoc) queue(op, index, id string, data []byte) DocumentSnapshot {
+	doc, err := h.offlineStore().enqueue(op, index, id, data)
+	if cache := h.documentCache(); cache != nil {
+		cache.invalidate(index, id)
+	}
+	if err != nil {
+		return DocumentSnapshot{Success: false, Reason: err.Error()}
+	}
+	if err := h.decode(index, doc); err != nil {
+		return DocumentSnapshot{Success: false, Reason: err.Error()}
+	}
+	return DocumentSnapshot{Doc: doc, Success: true, Pending: true}
+}
+
+// queueData is queue for writes of data, whose metadata fields are filled
+// from the queued document.
+func (h *HopDoc) queueData(op, index, id string, payload []byte, data interface{}) DocumentSnapshot {
+	snapshot := h.queue(op, index, id, payload)
+	setMetadata(data, snapshot.Doc)
+	return snapshot
+}
+
+// queued reports whether writes must go to the journal to keep them ordered
+// after the changes that are still pending.
+func (h *HopDoc) queued() bool {
+	s := h.offlineStore()
+	return s != nil && s.hasPending()
+}
+
+// local returns the offline store if reads of index/id must be served from
+// the local copy because it has changes that are not synced yet.
+func (h *HopDoc) local(index, id string) *offlineStore {
+	s := h.offlineStore()
+	if s != nil && s.pendingFor(index, id) {
+		return s
+	}
+	return nil
+}
+
+// fallback returns the offline store when err means that the server could
+// not be reached.
+func (h *HopDoc) fallback(err error) *offlineStore {
+	if s := h.offlineStore(); s != nil && unreachable(err) {
+		return s
+	}
+	return nil
+}
+
+// written keeps the local copy of a document up to date after a write sent
+// to the server directly.
+func (s *offlineStore) written(index, id string, version int, payload []byte) {
+	var source map[string]interface{}
+	if err := json.Unmarshal(payload, &source); err != nil {
+		return
+	}
+	s.saveLocal(index, &Document{Index: index, Id: id, Version: version, Source: source})
+}
+
+// read returns the local copy of a document.
+func (s *offlineStore) read(h *HopDoc, index, id string) DocumentSnapshot {
+	doc, err := s.loadLocal(index, id)
+	if os.IsNotExist(err) {
+		err = NotAvailableOffline
+	}
+	if err == nil {
+		err = h.decode(index, doc)
+	}
+	if err != nil {
+		return DocumentSnapshot{Success: false, Reason: err.Error(), Stale: true}
+	}
+	return DocumentSnapshot{Doc: doc, Success: true, Pending: s.pendingFor(index, id), Stale: true}
+}
+
+// save keeps the copy of doc received from the server.
+func (s *offlineStore) save(index string, doc *Document) {
+	if s != nil {
+		s.saveLocal(index, doc)
+	}
+}
+
+// enqueue appends a change to the journal and applies it to the local copy.
+func (s *offlineStore) enqueue(op, index, id string, data []byte) (*Document, error) {
+	local, _ := s.loadLocal(index, id)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	change := Change{Seq: s.seq + 1, Op: op, Index: index, Id: id, Data: data, At: time.Now().UTC()}
+	if local != nil {
+		change.BaseVersion = local.Version
+	}
+	b, err := json.Marshal(change)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(s.journalFile(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("could not open offline journal: %v", err)
+	}
+	defer file.Close()
+	if _, err := file.Write(append(b, '\n')); err != nil {
+		return nil, fmt.Errorf("could not write offline journal: %v", err)
+	}
+	if err := file.Sync(); err != nil {
+		return nil, fmt.Errorf("could not write offline journal: %v", err)
+	}
+	s.seq++
+	s.pending = append(s.pending, change)
+
+	return s.applyLocal(change, local)
+}
+
+func (s *offlineStore) applyLocal(change Change, local *Document) (*Document, error) {
+	switch change.Op {
+	case DeleteOp:
+		return nil, s.removeLocal(change.Index, change.Id)
+	case UpdateOp:
+		if local == nil {
+			local = &Document{Index: change.Index, Id: change.Id, Source: make(map[string]interface{})}
+		}
+		var fields map[string]interface{}
+		if err := json.Unmarshal(change.Data, &fields); err != nil {
+			return nil, err
+		}
+		for key, value := range fields {
+			local.Source[key] = value
+		}
+	default:
+		var source map[string]interface{}
+		if err := json.Unmarshal(change.Data, &source); err != nil {
+			return nil, err
+		}
+		version := 0
+		if local != nil {
+			version = local.Version
+		}
+		local = &Document{Index: change.Index, Id: change.Id, Version: version, Source: source}
+	}
+	return local, s.saveLocal(change.Index, local)
+}
+
+func (s *offlineStore) replay(h *HopDoc) error {
+	s.syncing.Lock()
+	defer s.syncing.Unlock()
+
+	// Versions produced by our own replayed writes, so that consecutive
+	// changes to a document are not reported as conflicts with each other.
+	written := make(map[string]int)
+	for {
+		s.mu.Lock()
+		if len(s.pending) == 0 {
+			s.mu.Unlock()
+			return nil
+		}
+		change := s.pending[0]
+		s.mu.Unlock()
+
+		key := cacheKey(change.Index, change.Id)
+		if version, ok := written[key]; ok {
+			change.BaseVersion = version
+		}
+		remote, err := s.apply(h, change)
+		if _, resolving := err.(*resolverError); !resolving && (unreachable(err) || IsConflict(err)) {
+			return err
+		}
+		if err != nil {
+			if rejectErr := s.reject(change, err); rejectErr != nil {
+				return rejectErr
+			}
+			if s.config.OnRejected != nil {
+				s.config.OnRejected(change, err)
+			}
+		}
+		written[key] = 0
+		if remote != nil {
+			written[key] = remote.Version
+		}
+		if cache := h.documentCache(); cache != nil {
+			cache.invalidate(change.Index, change.Id)
+		}
+		if err := s.commit(change.Seq); err != nil {
+			return err
+		}
+		// Later changes to the same document keep their local copy until
+		// they are replayed too.
+		if !s.pendingFor(change.Index, change.Id) {
+			s.storeRemote(change.Index, change.Id, remote)
+		}
+	}
+}
+
+type resolverError struct {
+	err error
+}
+
+func (e *resolverError) Error() string {
+	return fmt.Sprintf("could not resolve conflict: %v", e.err)
+}
+
+func (e *resolverError) Unwrap() error {
+	return e.err
+}
+
+// apply sends a change to the server and returns the document as it is on
+// the server afterwards, nil if it does not exist. The write is conditional
+// on the document read to resolve conflicts, which is done again if the
+// document changes in between.
+func (s *offlineStore) apply(h *HopDoc, change Change) (*Document, error) {
+	path := fmt.Sprintf("/%s/_doc/%s", change.Index, change.Id)
+	for attempt := 0; ; attempt++ {
+		remote, err := s.fetchRemote(h, path)
+		if err != nil {
+			return nil, err
+		}
+
+		resolved := change
+		remoteVersion := 0
+		if remote != nil {
+			remoteVersion = remote.Version
+		}
+		if remoteVersion != change.BaseVersion {
+			result, err := s.config.Resolver(change, remote)
+			if err != nil {
+				return remote, &resolverError{err}
+			}
+			if result == nil {
+				return remote, nil
+			}
+			resolved = *result
+		}
+
+		err = s.write(h, path, resolved, remote)
+		if IsConflict(err) && attempt < conflictRetries {
+			continue
+		}
+		if err != nil {
+			return remote, err
+		}
+		return s.fetchRemote(h, path)
+	}
+}
+
+// write sends change only if the document is still remote.
+func (s *offlineStore) write(h *HopDoc, path string, change Change, remote *Document) error {
+	options := writeOptions{create: remote == nil}
+	if remote != nil {
+		options = writeOptions{match: true, seqNo: remote.SeqNo, primaryTerm: remote.PrimaryTerm}
+	}
+
+	switch change.Op {
+	case DeleteOp:
+		if remote == nil {
+			return nil
+		}
+		if audit := h.lookup(change.Index).getAudit(); audit != nil && audit.SoftDelete {
+			return h.softDelete(h.client, change.Index, change.Id, change.At, options)
+		}
+		return h.deleteDocument(h.client, change.Index, change.Id, options)
+	case UpdateOp:
+		body, err := json.Marshal(map[string]json.RawMessage{"doc": change.Data})
+		if err != nil {
+			return err
+		}
+		// Updates of a missing document fail anyway.
+		options.create = false
+		_, err = h.client.Post(path+"/_update"+options.query(), body)
+		return err
+	}
+	_, err := h.client.Post(path+options.query(), change.Data)
+	return err
+}
+
+func (s *offlineStore) fetchRemote(h *HopDoc, path string) (*Document, error) {
+	resp, err := h.client.Get(path)
+	if IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var remote Document
+	if err := json.Unmarshal(resp, &remote); err != nil {
+		return nil, err
+	}
+	return &remote, nil
+}
+
+// reject appends change to the dead-letter file.
+func (s *offlineStore) reject(change Change, reason error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, err := json.Marshal(RejectedChange{change, reason.Error()})
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(s.rejectedFile(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("could not open rejected changes: %v", err)
+	}
+	defer file.Close()
+	if _, err := file.Write(append(b, '\n')); err != nil {
+		return fmt.Errorf("could not write rejected changes: %v", err)
+	}
+	return file.Sync()
+}
+
+func (s *offlineStore) storeRemote(index, id string, remote *Document) error {
+	if remote == nil {
+		return s.removeLocal(index, id)
+	}
+	return s.saveLocal(index, remote)
+}
+
+// commit drops the change with seq from the journal, rewriting it atomically.
+func (s *offlineStore) commit(seq uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	remaining := make([]Change, 0, len(s.pending))
+	for _, change := range s.pending {
+		if change.Seq != seq {
+			remaining = append(remaining, change)
+		}
+	}
+
+	tmp := s.journalFile() + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(file)
+	for _, change := range remaining {
+		b, err := json.Marshal(change)
+		if err != nil {
+			file.Close()
+			return err
+		}
+		w.Write(append(b, '\n'))
+	}
+	if err := w.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.journalFile()); err != nil {
+		return err
+	}
+	s.pending = remaining
+	return nil
+}
+
+func (s *offlineStore) indexDir(index string) string {
+	return filepath.Join(s.config.Dir, "docs", base64.RawURLEncoding.EncodeToString([]byte(index)))
+}
+
+func (s *offlineStore) localFile(index, id string) string {
+	return filepath.Join(s.indexDir(index), base64.RawURLEncoding.EncodeToString([]byte(id))+".json")
+}
+
+// saveLocal stores doc as received from the server, before decoding.
+func (s *offlineStore) saveLocal(index string, doc *Document) error {
+	if doc == nil || doc.Id == "" {
+		return nil
+	}
+	if err := os.MkdirAll(s.indexDir(index), 0700); err != nil {
+		return err
+	}
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	tmp := s.localFile(index, doc.Id) + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.localFile(index, doc.Id))
+}
+
+func (s *offlineStore) loadLocal(index, id string) (*Document, error) {
+	b, err := ioutil.ReadFile(s.localFile(index, id))
+	if err != nil {
+		return nil, err
+	}
+	var doc Document
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
+
+func (s *offlineStore) removeLocal(index, id string) error {
+	err := os.Remove(s.localFile(index, id))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// query evaluates the Where conditions of i against the local copies.
+func (s *offlineStore) query(i *IndexReference) ([]Document, error) {
+	if len(i.Searches) > 0 || i.vector != nil {
+		return nil, UnsupportedOfflineQuery
+	}
+	files, err := ioutil.ReadDir(s.indexDir(i.Index))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	docs := make([]Document, 0)
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		b, err := ioutil.ReadFile(filepath.Join(s.indexDir(i.Index), file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var doc Document
+		if err := json.Unmarshal(b, &doc); err != nil {
+			continue
+		}
+		matches := true
+		for _, q := range i.Queries {
+			ok, err := matchLocal(doc.Source, q)
+			if err != nil {
+				return nil, err
+			}
+			matches = matches && ok
+		}
+		if matches {
+			docs = append(docs, doc)
+		}
+	}
+	sort.Slice(docs, func(a, b int) bool { return docs[a].Id < docs[b].Id })
+	for n := len(i.sort) - 1; n >= 0; n-- {
+		for field, spec := range i.sort[n] {
+			options, ok := spec.(map[string]interface{})
+			if !ok || field == "_geo_distance" {
+				return nil, UnsupportedOfflineQuery
+			}
+			descending := options["order"] == "desc"
+			sort.SliceStable(docs, func(a, b int) bool {
+				c := compare(localValue(docs[a].Source, field), localValue(docs[b].Source, field))
+				if descending {
+					return c > 0
+				}
+				return c < 0
+			})
+		}
+	}
+	return docs, nil
+}
+
+func localValue(source map[string]interface{}, field string) interface{} {
+	parent, key := walkPath(source, field)
+	if parent == nil {
+		return nil
+	}
+	return parent[key]
+}
+
+func matchLocal(source map[string]interface{}, q Query) (bool, error) {
+	value := localValue(source, q.Field)
+	if value == nil {
+		return false, nil
+	}
+
+	switch q.Operator {
+	case "==":
+		return matchAnalyzed(value, q.Value), nil
+	case "<", "<=", ">", ">=":
+		c := compare(value, q.Value)
+		switch q.Operator {
+		case "<":
+			return c < 0, nil
+		case "<=":
+			return c <= 0, nil
+		case ">":
+			return c > 0, nil
+		}
+		return c >= 0, nil
+	}
+	return false, UnsupportedOfflineQuery
+}
+
+// matchAnalyzed approximates the match query of "==": strings match if they
+// share a word, ignoring case, and arrays if any of their items does.
+// Ciphertexts and other values must be equal.
+func matchAnalyzed(value, query interface{}) bool {
+	if items, ok := value.([]interface{}); ok {
+		for _, item := range items {
+			if matchAnalyzed(item, query) {
+				return true
+			}
+		}
+		return false
+	}
+	text, ok := value.(string)
+	queryText, queryOk := query.(string)
+	if !ok || !queryOk || isEncrypted(query) {
+		return compare(value, query) == 0
+	}
+	words := make(map[string]bool)
+	for _, word := range analyze(text) {
+		words[word] = true
+	}
+	for _, word := range analyze(queryText) {
+		if words[word] {
+			return true
+		}
+	}
+	return false
+}
+
+// analyze splits text into lowercased words.
+func analyze(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// compare orders numbers numerically and anything else by its JSON form.
+func compare(a, b interface{}) int {
+	fa, aok := toFloat(a)
+	fb, bok := toFloat(b)
+	if aok && bok {
+		switch {
+		case fa < fb:
+			return -1
+		case fa > fb:
+			return 1
+		}
+		return 0
+	}
+	sa, sb := fmt.Sprint(a), fmt.Sprint(b)
+	if t, ok := b.(time.Time); ok {
+		sb = t.UTC().Format(time.RFC3339Nano)
+	}
+	return strings.Compare(sa, sb)
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	}
+	return 0, false
+}
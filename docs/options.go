@@ -0,0 +1,191 @@
+package docs
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// indexOptions holds the client-side behaviour configured for an index.
+type indexOptions struct {
+	mu           sync.RWMutex
+	encryption   *EncryptionConfig
+	schema       *Schema
+	trackDeletes bool
+	audit        *AuditConfig
+	matchHook    MatchHook
+	// tagged holds the fields tagged `hop:"encrypt"` seen so far, and whether
+	// they are deterministic.
+	tagged map[string]bool
+	// updatedAt holds the fields tagged `hop:"updatedAt,auto"` seen so far,
+	// which Update stamps too.
+	updatedAt map[string]bool
+}
+
+func (o *indexOptions) setMatchHook(hook MatchHook) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.matchHook = hook
+}
+
+func (o *indexOptions) getMatchHook() MatchHook {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return o.matchHook
+}
+
+func (o *indexOptions) setAudit(config *AuditConfig) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.audit = config
+}
+
+func (o *indexOptions) getAudit() *AuditConfig {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return o.audit
+}
+
+func (o *indexOptions) setTrackDeletes(track bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.trackDeletes = track
+}
+
+func (o *indexOptions) getTrackDeletes() bool {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return o.trackDeletes
+}
+
+func (o *indexOptions) setSchema(schema *Schema) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.schema = schema
+}
+
+func (o *indexOptions) getSchema() *Schema {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return o.schema
+}
+
+func (o *indexOptions) setEncryption(config *EncryptionConfig) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.encryption = config
+}
+
+func (o *indexOptions) getEncryption() *EncryptionConfig {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return o.encryption
+}
+
+func (h *HopDoc) options(index string) *indexOptions {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.indexes == nil {
+		h.indexes = make(map[string]*indexOptions)
+	}
+	o, ok := h.indexes[index]
+	if !ok {
+		o = &indexOptions{}
+		h.indexes[index] = o
+	}
+	return o
+}
+
+func (h *HopDoc) lookup(index string) *indexOptions {
+	if h == nil {
+		return &indexOptions{}
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if o, ok := h.indexes[index]; ok {
+		return o
+	}
+	return &indexOptions{}
+}
+
+// encode marshals data for index. It returns the source as the caller sees
+// it along with the payload that is sent to the server.
+func (h *HopDoc) encode(index string, data interface{}) (map[string]interface{}, []byte, error) {
+	return h.prepare(index, data, false)
+}
+
+// encodeUpdate is encode for the partial documents sent by Update.
+func (h *HopDoc) encodeUpdate(index string, fields map[string]interface{}) (map[string]interface{}, []byte, error) {
+	return h.prepare(index, fields, true)
+}
+
+func (h *HopDoc) prepare(index string, data interface{}, partial bool) (map[string]interface{}, []byte, error) {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	options := h.lookup(index)
+	var source map[string]interface{}
+	if err := json.Unmarshal(b, &source); err != nil {
+		// Only objects can be validated and encrypted.
+		if options.getSchema() != nil || options.getEncryption() != nil {
+			return nil, nil, ValidationErrors{{"", "must be an object"}}
+		}
+		return nil, b, nil
+	}
+
+	now := time.Now().UTC()
+	stamped := false
+	if partial {
+		stamped = options.stampUpdate(source, now)
+	} else {
+		h.learnStamps(index, data)
+		stamped = stamp(data, source, now)
+	}
+	if stamped {
+		if b, err = json.Marshal(source); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if schema := options.getSchema(); schema != nil {
+		if partial {
+			err = schema.validatePartial(source)
+		} else {
+			err = schema.Validate(source)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if options.getEncryption() != nil {
+		options.learnTags(data)
+	}
+	c := options.getEncryption()
+	payload, changed, err := c.encrypt(source, c.fields(data, options.taggedFields()))
+	if err != nil {
+		return nil, nil, err
+	}
+	if !changed {
+		return source, b, nil
+	}
+
+	b, err = json.Marshal(payload)
+	if err != nil {
+		return nil, nil, err
+	}
+	return source, b, nil
+}
+
+// decode turns a document read from index back into what was written.
+func (h *HopDoc) decode(index string, doc *Document) error {
+	if doc == nil || doc.Source == nil {
+		return nil
+	}
+	if encryption := h.lookup(index).getEncryption(); encryption != nil {
+		return encryption.decrypt(doc.Source)
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+package docs
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// MatchHook is called after the writes made with this client to an index
+// with the names of the saved queries the written document matches, or the
+// error that prevented matching it.
+type MatchHook func(doc *Document, queries []string, err error)
+
+// PercolatorIndex is the index holding the saved queries of index.
+func PercolatorIndex(index string) string {
+	return index + ".percolator"
+}
+
+// SaveQuery stores the Where and Search clauses of the query under name, see
+// Match. The fields they use must already be mapped in the index, their
+// mapping is copied to PercolatorIndex.
+func (i *IndexReference) SaveQuery(name string) error {
+	if i.err != nil {
+		return i.err
+	}
+	if err := i.syncPercolator(); err != nil {
+		return err
+	}
+	body, err := json.Marshal(map[string]interface{}{"query": i.CompoundBody(0, 0).Query})
+	if err != nil {
+		return err
+	}
+	if _, err := i.client.Post(fmt.Sprintf("/%s/_doc/%s?refresh=true", PercolatorIndex(i.Index), name), body); err != nil {
+		return fmt.Errorf("could not save query %s: %v", name, err)
+	}
+	return nil
+}
+
+// RemoveQuery deletes the saved query name.
+func (i *IndexReference) RemoveQuery(name string) error {
+	err := i.client.Delete(fmt.Sprintf("/%s/_doc/%s?refresh=true", PercolatorIndex(i.Index), name))
+	if err != nil && !IsNotFound(err) {
+		return fmt.Errorf("could not remove query %s: %v", name, err)
+	}
+	return nil
+}
+
+// syncPercolator creates PercolatorIndex with the mapping of the index and a
+// percolator field, or adds the fields mapped since to it.
+func (i *IndexReference) syncPercolator() error {
+	properties := make(map[string]json.RawMessage)
+	resp, err := i.client.Get(fmt.Sprintf("/%s/_mapping", i.Index))
+	if err != nil && !IsNotFound(err) {
+		return fmt.Errorf("could not get mapping: %v", err)
+	}
+	if err == nil {
+		var result map[string]struct {
+			Mappings struct {
+				Properties map[string]json.RawMessage `json:"properties"`
+			} `json:"mappings"`
+		}
+		if err := json.Unmarshal(resp, &result); err != nil {
+			return err
+		}
+		for _, index := range result {
+			for field, mapping := range index.Mappings.Properties {
+				properties[field] = mapping
+			}
+		}
+	}
+	properties["query"] = json.RawMessage(`{"type":"percolator"}`)
+
+	percolator := i.db.Index(PercolatorIndex(i.Index))
+	percolator.client = i.client
+	exists, err := percolator.Exists()
+	if err != nil {
+		return err
+	}
+	if !exists {
+		body, err := json.Marshal(map[string]interface{}{"mappings": map[string]interface{}{"properties": properties}})
+		if err != nil {
+			return err
+		}
+		if _, err := i.client.Put("/"+percolator.Index, body); err != nil {
+			return fmt.Errorf("could not create percolator index: %v", err)
+		}
+		return nil
+	}
+	body, err := json.Marshal(map[string]interface{}{"properties": properties})
+	if err != nil {
+		return err
+	}
+	if _, err := i.client.Put(fmt.Sprintf("/%s/_mapping", percolator.Index), body); err != nil {
+		return fmt.Errorf("could not update percolator mapping: %v", err)
+	}
+	return nil
+}
+
+// Match returns the names of the saved queries data matches. data is
+// neither stamped nor validated, but its deterministic encrypted fields are
+// encrypted so that they match the queries built with Where.
+func (i *IndexReference) Match(data interface{}) ([]string, error) {
+	b, err := i.db.encodeMatch(i.Index, data)
+	if err != nil {
+		return nil, err
+	}
+	return i.db.percolate(i.client, i.Index, b)
+}
+
+// encodeMatch marshals data for percolation without changing it.
+func (h *HopDoc) encodeMatch(index string, data interface{}) ([]byte, error) {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	var source map[string]interface{}
+	if err := json.Unmarshal(b, &source); err != nil {
+		return b, nil
+	}
+	for _, f := range hopFieldsOf(data) {
+		if !f.nested() && f.metadata() {
+			delete(source, f.Name)
+		}
+	}
+
+	options := h.lookup(index)
+	c := options.getEncryption()
+	deterministic := make(map[string]bool)
+	for field, ok := range c.fields(data, options.taggedFields()) {
+		if ok {
+			deterministic[field] = true
+		}
+	}
+	payload, _, err := c.encrypt(source, deterministic)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(payload)
+}
+
+// OnMatch calls hook after every SetData, Update and Add that reaches the
+// server for index. It runs before the write returns.
+func (h *HopDoc) OnMatch(index string, hook MatchHook) {
+	h.options(index).setMatchHook(hook)
+}
+
+func (h *HopDoc) percolate(client HopDocClient, index string, document []byte) ([]string, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"query": map[string]interface{}{"percolate": map[string]interface{}{
+			"field":    "query",
+			"document": json.RawMessage(document),
+		}},
+		"_source": false,
+		"size":    10000,
+	})
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Post(fmt.Sprintf("/%s/_search", PercolatorIndex(index)), body)
+	if IsNotFound(err) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not match saved queries: %v", err)
+	}
+
+	var result IndexGetResponse
+	if err := json.Unmarshal(resp, &result); err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(result.Hits.Hits))
+	for _, hit := range result.Hits.Hits {
+		names = append(names, hit.Id)
+	}
+	return names, nil
+}
+
+// matched runs the match hook of index, if any, for the document written
+// as b.
+func (h *HopDoc) matched(client HopDocClient, index string, doc *Document, b []byte) {
+	hook := h.lookup(index).getMatchHook()
+	if hook == nil {
+		return
+	}
+	if b == nil {
+		current, err := h.current(client, index, doc.Id)
+		if err != nil || current == nil {
+			hook(doc, nil, err)
+			return
+		}
+		if b, err = json.Marshal(current.Source); err != nil {
+			hook(doc, nil, err)
+			return
+		}
+	}
+	queries, err := h.percolate(client, index, b)
+	hook(doc, queries, err)
+}
@@ -0,0 +1,298 @@
+package docs
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// QueryStatement is the parsed form of the small query language accepted by
+// ParseQuery, for example:
+//
+//	where status == "open" and total > 100 order by created desc limit 20
+//	search "red shoes" in name, description limit 5
+type QueryStatement struct {
+	Conditions []Query
+	Searches   []TextQuery
+	Sort       []SortField
+	Limit      int
+}
+
+type SortField struct {
+	Field      string
+	Descending bool
+}
+
+type QuerySyntaxError struct {
+	Position int
+	Message  string
+}
+
+func (e *QuerySyntaxError) Error() string {
+	return fmt.Sprintf("syntax error at position %d: %s", e.Position, e.Message)
+}
+
+func (s *QueryStatement) Apply(i *IndexReference) *IndexReference {
+	for _, q := range s.Conditions {
+		i = i.Where(q.Field, q.Operator, q.Value)
+	}
+	for _, t := range s.Searches {
+		i = i.SearchWith(t)
+	}
+	for _, f := range s.Sort {
+		i = i.OrderBy(f.Field, f.Descending)
+	}
+	if s.Limit > 0 {
+		i = i.Limit(s.Limit)
+	}
+	return i
+}
+
+type tokenKind int
+
+const (
+	wordToken tokenKind = iota
+	stringToken
+	numberToken
+	operatorToken
+	commaToken
+	endToken
+)
+
+type token struct {
+	kind  tokenKind
+	text  string
+	value interface{}
+	pos   int
+}
+
+func tokenize(text string) ([]token, error) {
+	tokens := make([]token, 0)
+	runes := []rune(text)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == ',':
+			tokens = append(tokens, token{kind: commaToken, text: ",", pos: i})
+			i++
+		case r == '"' || r == '\'':
+			start := i
+			var b strings.Builder
+			for i++; i < len(runes) && runes[i] != r; i++ {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+				}
+				b.WriteRune(runes[i])
+			}
+			if i >= len(runes) {
+				return nil, &QuerySyntaxError{start, "unterminated string"}
+			}
+			i++
+			tokens = append(tokens, token{stringToken, string(runes[start:i]), b.String(), start})
+		case strings.ContainsRune("=<>!", r):
+			start := i
+			for i < len(runes) && strings.ContainsRune("=<>!", runes[i]) {
+				i++
+			}
+			op := string(runes[start:i])
+			switch op {
+			case "==", "<", "<=", ">", ">=":
+			case "=":
+				op = "=="
+			default:
+				return nil, &QuerySyntaxError{start, fmt.Sprintf("unknown operator %q", op)}
+			}
+			tokens = append(tokens, token{kind: operatorToken, text: op, pos: start})
+		default:
+			start := i
+			for i < len(runes) && !unicode.IsSpace(runes[i]) && !strings.ContainsRune(`,"'=<>!`, runes[i]) {
+				i++
+			}
+			word := string(runes[start:i])
+			if n, err := strconv.ParseInt(word, 10, 64); err == nil {
+				tokens = append(tokens, token{numberToken, word, n, start})
+			} else if f, err := strconv.ParseFloat(word, 64); err == nil {
+				tokens = append(tokens, token{numberToken, word, f, start})
+			} else {
+				tokens = append(tokens, token{wordToken, word, word, start})
+			}
+		}
+	}
+	return append(tokens, token{kind: endToken, pos: len(runes)}), nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != endToken {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) keyword(words ...string) bool {
+	t := p.peek()
+	if t.kind != wordToken {
+		return false
+	}
+	for _, w := range words {
+		if strings.EqualFold(t.text, w) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *parser) expect(word string) error {
+	if !p.keyword(word) {
+		return p.unexpected(fmt.Sprintf("%q", word))
+	}
+	p.next()
+	return nil
+}
+
+func (p *parser) unexpected(expected string) error {
+	t := p.peek()
+	if t.kind == endToken {
+		return &QuerySyntaxError{t.pos, "unexpected end of query, expected " + expected}
+	}
+	return &QuerySyntaxError{t.pos, fmt.Sprintf("unexpected %q, expected %s", t.text, expected)}
+}
+
+func (p *parser) field() (string, error) {
+	t := p.peek()
+	if t.kind != wordToken {
+		return "", p.unexpected("a field name")
+	}
+	p.next()
+	return t.text, nil
+}
+
+func (p *parser) value() (interface{}, error) {
+	t := p.peek()
+	switch t.kind {
+	case stringToken, numberToken:
+		p.next()
+		return t.value, nil
+	case wordToken:
+		p.next()
+		switch strings.ToLower(t.text) {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		}
+		return t.text, nil
+	}
+	return nil, p.unexpected("a value")
+}
+
+// ParseQuery parses a query written in the Where DSL. Clauses are optional
+// but must appear in the order where, search, order by, limit.
+func ParseQuery(text string) (*QueryStatement, error) {
+	tokens, err := tokenize(text)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	s := &QueryStatement{}
+
+	if p.keyword("where") {
+		p.next()
+		for {
+			field, err := p.field()
+			if err != nil {
+				return nil, err
+			}
+			op := p.peek()
+			if op.kind != operatorToken {
+				return nil, p.unexpected("an operator")
+			}
+			p.next()
+			value, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			s.Conditions = append(s.Conditions, Query{field, op.text, value})
+			if !p.keyword("and") {
+				break
+			}
+			p.next()
+		}
+	}
+
+	for p.keyword("search") {
+		p.next()
+		t := p.peek()
+		if t.kind != stringToken {
+			return nil, p.unexpected("a quoted search text")
+		}
+		p.next()
+		search := TextQuery{Text: t.value.(string)}
+		if p.keyword("in") {
+			p.next()
+			for {
+				field, err := p.field()
+				if err != nil {
+					return nil, err
+				}
+				search.Fields = append(search.Fields, field)
+				if p.peek().kind != commaToken {
+					break
+				}
+				p.next()
+			}
+		}
+		s.Searches = append(s.Searches, search)
+	}
+
+	if p.keyword("order") {
+		p.next()
+		if err := p.expect("by"); err != nil {
+			return nil, err
+		}
+		for {
+			field, err := p.field()
+			if err != nil {
+				return nil, err
+			}
+			sort := SortField{Field: field}
+			if p.keyword("asc", "desc") {
+				sort.Descending = strings.EqualFold(p.next().text, "desc")
+			}
+			s.Sort = append(s.Sort, sort)
+			if p.peek().kind != commaToken {
+				break
+			}
+			p.next()
+		}
+	}
+
+	if p.keyword("limit") {
+		p.next()
+		limit, ok := p.peek().value.(int64)
+		if p.peek().kind != numberToken || !ok || limit <= 0 {
+			return nil, p.unexpected("a positive number")
+		}
+		p.next()
+		s.Limit = int(limit)
+	}
+
+	if p.peek().kind != endToken {
+		return nil, p.unexpected("end of query")
+	}
+	return s, nil
+}
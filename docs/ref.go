@@ -0,0 +1,176 @@
+package docs
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Ref points to a document of another index. It is stored as an object with
+// exactly the keys "index" and "id", which is how populate recognizes it.
+type Ref struct {
+	Index string `json:"index"`
+	Id    string `json:"id"`
+}
+
+func (d *DocumentReference) Ref() Ref {
+	return Ref{d.Index, d.key(d.Id)}
+}
+
+// ReadOption changes how documents are read.
+type ReadOption func(*readOptions)
+
+type readOptions struct {
+	depth int
+}
+
+// Populate replaces the refs found in the documents read with the source of
+// the documents they point to, plus their "index" and "id" keys, so the
+// result decodes into nested structs. Refs inside populated documents are
+// resolved too, up to depth levels. A ref to a document that is already
+// being populated higher up the same path, or that does not exist, is left
+// as it is.
+func Populate(depth int) ReadOption {
+	return func(o *readOptions) {
+		o.depth = depth
+	}
+}
+
+func newReadOptions(options []ReadOption) readOptions {
+	var o readOptions
+	for _, option := range options {
+		option(&o)
+	}
+	return o
+}
+
+// refSlot is a place in a source that holds a ref.
+type refSlot struct {
+	ref       Ref
+	set       func(value interface{})
+	ancestors map[Ref]bool
+}
+
+// populate resolves the refs of docs level by level, with a multi-get per
+// level.
+func (h *HopDoc) populate(client HopDocClient, docs []*Document, depth int) error {
+	slots := make([]refSlot, 0)
+	for _, doc := range docs {
+		slots = findRefs(doc.Source, map[Ref]bool{{doc.Index, doc.Id}: true}, slots)
+	}
+
+	fetched := make(map[Ref]*Document)
+	for level := 0; level < depth && len(slots) > 0; level++ {
+		missing := make([]Ref, 0)
+		for _, slot := range slots {
+			if _, ok := fetched[slot.ref]; !ok && !slot.ancestors[slot.ref] {
+				fetched[slot.ref] = nil
+				missing = append(missing, slot.ref)
+			}
+		}
+		if err := h.multiGet(client, missing, fetched); err != nil {
+			return err
+		}
+
+		next := make([]refSlot, 0)
+		for _, slot := range slots {
+			doc := fetched[slot.ref]
+			if doc == nil || slot.ancestors[slot.ref] {
+				continue
+			}
+			// Populate a copy, the fetched document may be referenced again.
+			populated := deepCopy(doc.Source).(map[string]interface{})
+			populated["index"], populated["id"] = slot.ref.Index, slot.ref.Id
+			slot.set(populated)
+
+			ancestors := make(map[Ref]bool, len(slot.ancestors)+1)
+			for ref := range slot.ancestors {
+				ancestors[ref] = true
+			}
+			ancestors[slot.ref] = true
+			next = findRefs(populated, ancestors, next)
+		}
+		slots = next
+	}
+	return nil
+}
+
+// findRefs appends the refs found in value to slots.
+func findRefs(value interface{}, ancestors map[Ref]bool, slots []refSlot) []refSlot {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, child := range value {
+			key := key
+			if ref, ok := asRef(child); ok {
+				slots = append(slots, refSlot{ref, func(v interface{}) { value[key] = v }, ancestors})
+			} else {
+				slots = findRefs(child, ancestors, slots)
+			}
+		}
+	case []interface{}:
+		for idx, child := range value {
+			idx := idx
+			if ref, ok := asRef(child); ok {
+				slots = append(slots, refSlot{ref, func(v interface{}) { value[idx] = v }, ancestors})
+			} else {
+				slots = findRefs(child, ancestors, slots)
+			}
+		}
+	}
+	return slots
+}
+
+func asRef(value interface{}) (Ref, bool) {
+	object, ok := value.(map[string]interface{})
+	if !ok || len(object) != 2 {
+		return Ref{}, false
+	}
+	index, indexOk := object["index"].(string)
+	id, idOk := object["id"].(string)
+	return Ref{index, id}, indexOk && idOk
+}
+
+// multiGet reads refs in a single request into fetched. Documents that do
+// not exist are left nil.
+func (h *HopDoc) multiGet(client HopDocClient, refs []Ref, fetched map[Ref]*Document) error {
+	if len(refs) == 0 {
+		return nil
+	}
+	docs := make([]map[string]string, 0, len(refs))
+	for _, ref := range refs {
+		docs = append(docs, map[string]string{"_index": ref.Index, "_id": ref.Id})
+	}
+	body, err := json.Marshal(map[string]interface{}{"docs": docs})
+	if err != nil {
+		return err
+	}
+	resp, err := client.Post("/_mget", body)
+	if err != nil {
+		return fmt.Errorf("could not get referenced documents: %v", err)
+	}
+
+	var result struct {
+		Docs []struct {
+			Document
+			Found bool `json:"found"`
+		} `json:"docs"`
+	}
+	if err := json.Unmarshal(resp, &result); err != nil {
+		return err
+	}
+	// Results come in the order of the request, and refs may name aliases.
+	for idx := range result.Docs {
+		doc := &result.Docs[idx].Document
+		if !result.Docs[idx].Found || idx >= len(refs) || doc.Source == nil {
+			continue
+		}
+		if expired(doc, time.Now()) || h.lookup(refs[idx].Index).deleted(doc) {
+			continue
+		}
+		if err := h.decode(refs[idx].Index, doc); err != nil {
+			return err
+		}
+		fetched[refs[idx]] = doc
+	}
+	return nil
+}
@@ -0,0 +1,473 @@
+package docs
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SchemaType is the "type" keyword, which JSON Schema allows to be a single
+// type or a list of them.
+type SchemaType []string
+
+func (t *SchemaType) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*t = SchemaType{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(b, &list); err != nil {
+		return fmt.Errorf("schema type must be a string or a list of strings")
+	}
+	*t = list
+	return nil
+}
+
+func (t SchemaType) MarshalJSON() ([]byte, error) {
+	if len(t) == 1 {
+		return json.Marshal(t[0])
+	}
+	return json.Marshal([]string(t))
+}
+
+// Schema is the subset of JSON Schema checked before documents are written.
+type Schema struct {
+	Type                 SchemaType         `json:"type,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *bool              `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Enum                 []interface{}      `json:"enum,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	MinItems             *int               `json:"minItems,omitempty"`
+	MaxItems             *int               `json:"maxItems,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+type FieldError struct {
+	Path    string
+	Message string
+}
+
+func (e FieldError) Error() string {
+	if e.Path == "" {
+		return e.Message
+	}
+	return e.Path + ": " + e.Message
+}
+
+type ValidationErrors []FieldError
+
+func (e ValidationErrors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, fieldError := range e {
+		messages = append(messages, fieldError.Error())
+	}
+	return "invalid document: " + strings.Join(messages, "; ")
+}
+
+func ParseSchema(b []byte) (*Schema, error) {
+	var s Schema
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, fmt.Errorf("could not parse schema: %v", err)
+	}
+	if err := s.compile(); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func (s *Schema) compile() error {
+	if s.Pattern != "" {
+		pattern, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid pattern %q: %v", s.Pattern, err)
+		}
+		s.pattern = pattern
+	}
+	for _, property := range s.Properties {
+		if err := property.compile(); err != nil {
+			return err
+		}
+	}
+	if s.Items != nil {
+		return s.Items.compile()
+	}
+	return nil
+}
+
+// SchemaFor derives a schema from a struct. Types come from the Go fields and
+// constraints from the hop tag, e.g.
+//
+//	Status string `json:"status" hop:"required,enum=open|closed"`
+//	Total  int    `json:"total" hop:"min=0,max=1000"`
+//
+// min and max bound numbers, string lengths or slice sizes depending on the
+// type of the field.
+func SchemaFor(v interface{}) (*Schema, error) {
+	s, err := schemaForType(reflect.TypeOf(v))
+	if err != nil {
+		return nil, err
+	}
+	if err := s.compile(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func schemaForType(t reflect.Type) (*Schema, error) {
+	nullable := false
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+		nullable = true
+	}
+
+	s := &Schema{}
+	switch {
+	case t == timeType:
+		s.Type = SchemaType{"string"}
+	case t.Kind() == reflect.String:
+		s.Type = SchemaType{"string"}
+	case t.Kind() == reflect.Bool:
+		s.Type = SchemaType{"boolean"}
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		s.Type = SchemaType{"integer"}
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		s.Type = SchemaType{"number"}
+	case t.Kind() == reflect.Slice || t.Kind() == reflect.Array:
+		s.Type = SchemaType{"array"}
+		items, err := schemaForType(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		s.Items = items
+	case t.Kind() == reflect.Map:
+		s.Type = SchemaType{"object"}
+	case t.Kind() == reflect.Struct:
+		s.Type = SchemaType{"object"}
+		if err := structProperties(t, s); err != nil {
+			return nil, err
+		}
+	}
+	if nullable && len(s.Type) > 0 {
+		s.Type = append(s.Type, "null")
+	}
+	return s, nil
+}
+
+func structProperties(t reflect.Type, s *Schema) error {
+	if s.Properties == nil {
+		s.Properties = make(map[string]*Schema)
+	}
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		name, skip := jsonName(sf)
+		if skip {
+			continue
+		}
+		if sf.Anonymous && name == "" {
+			embedded := sf.Type
+			for embedded.Kind() == reflect.Ptr {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				if err := structProperties(embedded, s); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		if sf.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+
+		var field hopField
+		if tag, ok := sf.Tag.Lookup("hop"); ok {
+			field = hopField{name, []int{i}, sf.Type, strings.Split(tag, ",")}
+		}
+		if field.metadata() {
+			continue
+		}
+
+		property, err := schemaForType(sf.Type)
+		if err != nil {
+			return err
+		}
+		if field.Options != nil {
+			if field.has("required") {
+				s.Required = append(s.Required, name)
+			}
+			if err := applyConstraints(property, field); err != nil {
+				return fmt.Errorf("field %s: %v", sf.Name, err)
+			}
+		}
+		s.Properties[name] = property
+	}
+	return nil
+}
+
+func applyConstraints(s *Schema, field hopField) error {
+	kind := ""
+	if len(s.Type) > 0 {
+		kind = s.Type[0]
+	}
+	for _, option := range []string{"min", "max"} {
+		value, ok := field.value(option)
+		if !ok || value == "" {
+			continue
+		}
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid %s %q", option, value)
+		}
+		switch kind {
+		case "integer", "number":
+			if option == "min" {
+				s.Minimum = &n
+			} else {
+				s.Maximum = &n
+			}
+		case "string":
+			length := int(n)
+			if option == "min" {
+				s.MinLength = &length
+			} else {
+				s.MaxLength = &length
+			}
+		case "array":
+			size := int(n)
+			if option == "min" {
+				s.MinItems = &size
+			} else {
+				s.MaxItems = &size
+			}
+		default:
+			return fmt.Errorf("%s is not supported on %s fields", option, kind)
+		}
+	}
+	if value, ok := field.value("enum"); ok {
+		for _, v := range strings.Split(value, "|") {
+			if kind == "integer" || kind == "number" {
+				n, err := strconv.ParseFloat(v, 64)
+				if err != nil {
+					return fmt.Errorf("invalid enum value %q", v)
+				}
+				s.Enum = append(s.Enum, n)
+			} else {
+				s.Enum = append(s.Enum, v)
+			}
+		}
+	}
+	if value, ok := field.value("pattern"); ok {
+		s.Pattern = value
+	}
+	return nil
+}
+
+// Validate checks data, which can be a struct, a map or the decoded JSON of
+// a document, and returns ValidationErrors listing every failing field.
+func (s *Schema) Validate(data interface{}) error {
+	value, err := normalize(data)
+	if err != nil {
+		return err
+	}
+	var errs ValidationErrors
+	s.validate("", value, &errs)
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// validatePartial checks only the fields present in an update.
+func (s *Schema) validatePartial(fields map[string]interface{}) error {
+	var errs ValidationErrors
+	for _, key := range sortedKeys(fields) {
+		property := s.property(key)
+		if property == nil {
+			if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+				errs = append(errs, FieldError{key, "is not allowed"})
+			}
+			continue
+		}
+		property.validate(key, fields[key], &errs)
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func (s *Schema) property(path string) *Schema {
+	current := s
+	for _, part := range strings.Split(path, ".") {
+		if current == nil || current.Properties == nil {
+			return nil
+		}
+		current = current.Properties[part]
+	}
+	return current
+}
+
+func normalize(data interface{}) (interface{}, error) {
+	if _, ok := data.(map[string]interface{}); ok {
+		return data, nil
+	}
+	b, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	var value interface{}
+	if err := json.Unmarshal(b, &value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+func (s *Schema) validate(path string, value interface{}, errs *ValidationErrors) {
+	fail := func(format string, args ...interface{}) {
+		*errs = append(*errs, FieldError{path, fmt.Sprintf(format, args...)})
+	}
+
+	if len(s.Type) > 0 && !s.Type.matches(value) {
+		fail("must be of type %s but is %s", strings.Join(s.Type, " or "), typeOf(value))
+		return
+	}
+	if len(s.Enum) > 0 && !inEnum(s.Enum, value) {
+		fail("must be one of %v", s.Enum)
+	}
+
+	switch v := value.(type) {
+	case float64:
+		if s.Minimum != nil && v < *s.Minimum {
+			fail("must be at least %v", *s.Minimum)
+		}
+		if s.Maximum != nil && v > *s.Maximum {
+			fail("must be at most %v", *s.Maximum)
+		}
+	case string:
+		length := len([]rune(v))
+		if s.MinLength != nil && length < *s.MinLength {
+			fail("must be at least %d characters long", *s.MinLength)
+		}
+		if s.MaxLength != nil && length > *s.MaxLength {
+			fail("must be at most %d characters long", *s.MaxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			fail("must match %s", s.Pattern)
+		}
+	case []interface{}:
+		if s.MinItems != nil && len(v) < *s.MinItems {
+			fail("must have at least %d items", *s.MinItems)
+		}
+		if s.MaxItems != nil && len(v) > *s.MaxItems {
+			fail("must have at most %d items", *s.MaxItems)
+		}
+		if s.Items != nil {
+			for i, item := range v {
+				s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item, errs)
+			}
+		}
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if field, ok := v[name]; !ok || field == nil {
+				*errs = append(*errs, FieldError{join(path, name), "is required"})
+			}
+		}
+		for _, key := range sortedKeys(v) {
+			property, ok := s.Properties[key]
+			if !ok {
+				if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+					*errs = append(*errs, FieldError{join(path, key), "is not allowed"})
+				}
+				continue
+			}
+			if v[key] == nil && !property.Type.matches(nil) {
+				continue
+			}
+			property.validate(join(path, key), v[key], errs)
+		}
+	}
+}
+
+func (t SchemaType) matches(value interface{}) bool {
+	if len(t) == 0 {
+		return true
+	}
+	actual := typeOf(value)
+	for _, expected := range t {
+		if expected == actual || (expected == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+func typeOf(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func inEnum(enum []interface{}, value interface{}) bool {
+	for _, e := range enum {
+		if reflect.DeepEqual(e, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func join(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// SetSchema makes every write to index be validated against schema before
+// it is sent. A nil schema disables validation.
+func (h *HopDoc) SetSchema(index string, schema *Schema) {
+	h.options(index).setSchema(schema)
+}
@@ -0,0 +1,101 @@
+package docs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+var StopIteration error = errors.New("stop iteration")
+
+const scrollPageSize = 500
+
+type scrollResponse struct {
+	ScrollId string `json:"_scroll_id"`
+	Hits     struct {
+		Hits []Document
+	}
+}
+
+// ForEach calls fn for every document matching the query, paging through the
+// results with a scroll. Returning StopIteration from fn ends it early.
+func (i *IndexReference) ForEach(fn func(doc *Document) error) error {
+	if i.err != nil {
+		return i.err
+	}
+
+	body := i.CompoundBody(scrollPageSize, 0)
+	if len(body.Sort) == 0 {
+		body.Sort = []map[string]interface{}{{"_doc": map[string]interface{}{"order": "asc"}}}
+	}
+	return scroll(i.client, i.Index, body, func(doc *Document) error {
+		if err := i.db.decode(i.Index, doc); err != nil {
+			return err
+		}
+		if expired(doc, time.Now()) {
+			return nil
+		}
+		return fn(doc)
+	})
+}
+
+// scroll calls fn with every hit of the search body on index, as stored. The
+// scroll is cleared once it ends, early or not, instead of being left open
+// on the server until it expires.
+func scroll(client HopDocClient, index string, body interface{}, fn func(doc *Document) error) error {
+	jsonData, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	var scrollId string
+	defer func() {
+		if scrollId != "" {
+			clearScroll(client, scrollId)
+		}
+	}()
+
+	resp, err := client.Post(fmt.Sprintf("/%s/_search?scroll=1m", index), jsonData)
+	for {
+		if err != nil {
+			return fmt.Errorf("could not scroll index: %v", err)
+		}
+
+		var result scrollResponse
+		if err := json.Unmarshal(resp, &result); err != nil {
+			return err
+		}
+		if result.ScrollId != "" {
+			scrollId = result.ScrollId
+		}
+		if len(result.Hits.Hits) == 0 {
+			return nil
+		}
+
+		for idx := range result.Hits.Hits {
+			if err := fn(&result.Hits.Hits[idx]); err == StopIteration {
+				return nil
+			} else if err != nil {
+				return err
+			}
+		}
+
+		next, marshalErr := json.Marshal(map[string]string{"scroll": "1m", "scroll_id": scrollId})
+		if marshalErr != nil {
+			return marshalErr
+		}
+		resp, err = client.Post("/_search/scroll", next)
+	}
+}
+
+// clearScroll frees the search context of a scroll. It is best effort: the
+// context expires on its own otherwise.
+func clearScroll(client HopDocClient, scrollId string) {
+	body, err := json.Marshal(map[string]string{"scroll_id": scrollId})
+	if err != nil {
+		return
+	}
+	client.do(http.MethodDelete, "/_search/scroll", body)
+}
@@ -0,0 +1,156 @@
+package docs
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+type SearchMode int
+
+const (
+	MatchMode SearchMode = iota
+	PhraseMode
+	PrefixMode
+)
+
+type TextQuery struct {
+	Text      string
+	Fields    []string
+	Mode      SearchMode
+	Fuzziness string
+	Operator  string
+}
+
+func (t TextQuery) query() map[string]interface{} {
+	multiMatch := map[string]interface{}{
+		"query": t.Text,
+	}
+	if len(t.Fields) > 0 {
+		multiMatch["fields"] = t.Fields
+	}
+	switch t.Mode {
+	case PhraseMode:
+		multiMatch["type"] = "phrase"
+	case PrefixMode:
+		multiMatch["type"] = "phrase_prefix"
+	default:
+		multiMatch["type"] = "best_fields"
+		if t.Fuzziness != "" {
+			multiMatch["fuzziness"] = t.Fuzziness
+		}
+		if t.Operator != "" {
+			multiMatch["operator"] = t.Operator
+		}
+	}
+	return map[string]interface{}{"multi_match": multiMatch}
+}
+
+// Boost returns the field name weighted by factor, e.g. Boost("title", 3)
+// becomes "title^3".
+func Boost(field string, factor float64) string {
+	return field + "^" + strconv.FormatFloat(factor, 'f', -1, 64)
+}
+
+func (i *IndexReference) Search(text string, fields ...string) *IndexReference {
+	return i.SearchWith(TextQuery{Text: text, Fields: fields})
+}
+
+func (i *IndexReference) SearchFuzzy(text, fuzziness string, fields ...string) *IndexReference {
+	return i.SearchWith(TextQuery{Text: text, Fields: fields, Fuzziness: fuzziness})
+}
+
+func (i *IndexReference) SearchPhrase(text string, fields ...string) *IndexReference {
+	return i.SearchWith(TextQuery{Text: text, Fields: fields, Mode: PhraseMode})
+}
+
+func (i *IndexReference) SearchPrefix(text string, fields ...string) *IndexReference {
+	return i.SearchWith(TextQuery{Text: text, Fields: fields, Mode: PrefixMode})
+}
+
+func (i *IndexReference) SearchWith(query TextQuery) *IndexReference {
+	i.Searches = append(i.Searches, query)
+	return i
+}
+
+func (i *IndexReference) Highlight(fields ...string) *IndexReference {
+	i.highlight = append(i.highlight, fields...)
+	return i
+}
+
+type HighlightBody struct {
+	PreTags  []string                          `json:"pre_tags"`
+	PostTags []string                          `json:"post_tags"`
+	Fields   map[string]map[string]interface{} `json:"fields"`
+}
+
+func newHighlightBody(fields []string) *HighlightBody {
+	highlight := &HighlightBody{
+		PreTags:  []string{"<em>"},
+		PostTags: []string{"</em>"},
+		Fields:   make(map[string]map[string]interface{}),
+	}
+	for _, field := range fields {
+		highlight.Fields[field] = make(map[string]interface{})
+	}
+	return highlight
+}
+
+type Suggestion struct {
+	Text  string
+	Score float64
+	Doc   Document
+}
+
+type suggestOption struct {
+	Text string `json:"text"`
+	Document
+}
+
+type suggestResponse struct {
+	Suggest map[string][]struct {
+		Options []suggestOption `json:"options"`
+	} `json:"suggest"`
+}
+
+// Suggest returns completions for prefix from a field mapped as completion.
+func (i *IndexReference) Suggest(field, prefix string, size int) ([]Suggestion, error) {
+	body := map[string]interface{}{
+		"_source": true,
+		"suggest": map[string]interface{}{
+			"hop": map[string]interface{}{
+				"prefix": prefix,
+				"completion": map[string]interface{}{
+					"field":           field,
+					"size":            size,
+					"skip_duplicates": true,
+				},
+			},
+		},
+	}
+	jsonData, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := i.client.Post(fmt.Sprintf("/%s/_search", i.Index), jsonData)
+	if err != nil {
+		return nil, fmt.Errorf("could not get suggestions: %v", err)
+	}
+
+	var result suggestResponse
+	if err := json.Unmarshal(resp, &result); err != nil {
+		return nil, err
+	}
+
+	suggestions := make([]Suggestion, 0)
+	for _, entry := range result.Suggest["hop"] {
+		for _, option := range entry.Options {
+			if err := i.db.decode(i.Index, &option.Document); err != nil {
+				return nil, err
+			}
+			suggestions = append(suggestions, Suggestion{option.Text, option.Score, option.Document})
+		}
+	}
+	return suggestions, nil
+}
@@ -0,0 +1,66 @@
+package docs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+)
+
+// Sink receives the events of a change feed. An error stops the feed before
+// the event is acknowledged.
+type Sink interface {
+	Send(ctx context.Context, event ChangeEvent) error
+}
+
+type SinkFunc func(ctx context.Context, event ChangeEvent) error
+
+func (f SinkFunc) Send(ctx context.Context, event ChangeEvent) error {
+	return f(ctx, event)
+}
+
+// NDJSONSink writes every event to w as a line of JSON.
+func NDJSONSink(w io.Writer) Sink {
+	var mu sync.Mutex
+	encoder := json.NewEncoder(w)
+	return SinkFunc(func(ctx context.Context, event ChangeEvent) error {
+		mu.Lock()
+		defer mu.Unlock()
+		return encoder.Encode(event)
+	})
+}
+
+// WebhookSink posts every event as JSON to url. Any status other than 2xx is
+// an error. A nil client uses http.DefaultClient.
+func WebhookSink(url string, client *http.Client) Sink {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return SinkFunc(func(ctx context.Context, event ChangeEvent) error {
+		b, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(b))
+		if err != nil {
+			return err
+		}
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-type", "application/json")
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return fmt.Errorf("could not post event: %v", err)
+		}
+		defer resp.Body.Close()
+		io.Copy(ioutil.Discard, resp.Body)
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			return fmt.Errorf("webhook returned %s", resp.Status)
+		}
+		return nil
+	})
+}
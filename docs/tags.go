@@ -0,0 +1,151 @@
+package docs
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// hopField is an exported struct field carrying a `hop:"..."` tag, keyed by
+// the name it has once marshaled to JSON.
+type hopField struct {
+	Name    string
+	Index   []int
+	Type    reflect.Type
+	Options []string
+}
+
+func (f hopField) has(option string) bool {
+	_, ok := f.value(option)
+	return ok
+}
+
+func (f hopField) value(option string) (string, bool) {
+	for _, o := range f.Options {
+		if o == option {
+			return "", true
+		}
+		if strings.HasPrefix(o, option+"=") {
+			return o[len(option)+1:], true
+		}
+	}
+	return "", false
+}
+
+var hopFieldsCache sync.Map
+
+// hopFields returns the tagged fields of t, including those of the structs
+// it nests. Nested fields are named by their dotted path, where slices are
+// transparent and map keys are "*".
+func hopFields(t reflect.Type) []hopField {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+	if cached, ok := hopFieldsCache.Load(t); ok {
+		return cached.([]hopField)
+	}
+	fields := collectHopFields(t, map[reflect.Type]bool{})
+	hopFieldsCache.Store(t, fields)
+	return fields
+}
+
+// collectHopFields is hopFields without the cache. seen holds the structs
+// being walked, so that recursive types end.
+func collectHopFields(t reflect.Type, seen map[reflect.Type]bool) []hopField {
+	seen[t] = true
+	defer delete(seen, t)
+
+	fields := make([]hopField, 0)
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		name, skip := jsonName(sf)
+		if skip {
+			// Metadata fields are filled by DataTo even when they are left
+			// out of the source.
+			if tag, ok := sf.Tag.Lookup("hop"); ok && sf.PkgPath == "" {
+				if f := (hopField{sf.Name, []int{i}, sf.Type, strings.Split(tag, ",")}); f.metadata() {
+					fields = append(fields, f)
+				}
+			}
+			continue
+		}
+		if sf.Anonymous && name == "" {
+			if embedded := structType(sf.Type); embedded != nil && !seen[embedded] {
+				for _, f := range collectHopFields(embedded, seen) {
+					if f.Index != nil {
+						f.Index = append([]int{i}, f.Index...)
+					}
+					fields = append(fields, f)
+				}
+			}
+			continue
+		}
+		if sf.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		if tag, ok := sf.Tag.Lookup("hop"); ok {
+			fields = append(fields, hopField{name, []int{i}, sf.Type, strings.Split(tag, ",")})
+		}
+
+		// Fields of nested structs are reached through their parent, and
+		// can only be found in the source past slices and maps.
+		t, index := sf.Type, []int{i}
+		for t.Kind() == reflect.Slice || t.Kind() == reflect.Array || t.Kind() == reflect.Map {
+			if t.Kind() == reflect.Map {
+				name += ".*"
+			}
+			t, index = t.Elem(), nil
+		}
+		nested := structType(t)
+		if nested == nil || nested == timeType || seen[nested] {
+			continue
+		}
+		for _, f := range collectHopFields(nested, seen) {
+			f.Name = name + "." + f.Name
+			if index != nil && f.Index != nil {
+				f.Index = append(append([]int(nil), index...), f.Index...)
+			} else {
+				f.Index = nil
+			}
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+func structType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+	return t
+}
+
+// nested reports whether f belongs to a nested struct rather than to the
+// document itself.
+func (f hopField) nested() bool {
+	return strings.Contains(f.Name, ".")
+}
+
+func jsonName(sf reflect.StructField) (string, bool) {
+	tag := sf.Tag.Get("json")
+	if tag == "-" {
+		return "", true
+	}
+	return strings.Split(tag, ",")[0], false
+}
+
+func hopFieldsOf(data interface{}) []hopField {
+	if data == nil {
+		return nil
+	}
+	return hopFields(reflect.TypeOf(data))
+}
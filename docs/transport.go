@@ -0,0 +1,201 @@
+package docs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Request struct {
+	Context   context.Context
+	Method    string
+	Path      string
+	Body      []byte
+	Header    http.Header
+	Index     string
+	Operation string
+	// Attempt is incremented by Retry every time the request is resent.
+	Attempt int
+}
+
+type Response struct {
+	StatusCode int
+	Status     string
+	Header     http.Header
+	Body       []byte
+}
+
+type Handler func(req *Request) (*Response, error)
+
+// Interceptor wraps every request sent by HopDocClient. It must call next to
+// continue the chain and may inspect or modify both the request and response.
+type Interceptor func(req *Request, next Handler) (*Response, error)
+
+type transport struct {
+	mu           sync.RWMutex
+	interceptors []Interceptor
+}
+
+func (t *transport) use(interceptors ...Interceptor) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.interceptors = append(t.interceptors, interceptors...)
+}
+
+func (t *transport) handler(last Handler) Handler {
+	if t == nil {
+		return last
+	}
+	t.mu.RLock()
+	interceptors := t.interceptors
+	t.mu.RUnlock()
+
+	h := last
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], h
+		h = func(req *Request) (*Response, error) {
+			return interceptor(req, next)
+		}
+	}
+	return h
+}
+
+// Use appends interceptors to the chain. The first one registered is the
+// outermost, so it sees the request first and the response last.
+func (h *HopDoc) Use(interceptors ...Interceptor) {
+	if h.client.transport == nil {
+		h.client.transport = &transport{}
+	}
+	h.client.transport.use(interceptors...)
+}
+
+func (i *IndexReference) WithContext(ctx context.Context) *IndexReference {
+	i.client = i.client.withContext(ctx)
+	return i
+}
+
+func (d *DocumentReference) WithContext(ctx context.Context) *DocumentReference {
+	d.client = d.client.withContext(ctx)
+	return d
+}
+
+// Retry resends requests that failed because of the network or a transient
+// server status, waiting backoff, 2*backoff, 4*backoff... between attempts.
+// Only requests that can be applied twice safely are resent: reads, searches
+// and writes of a document by id. Others, like Add without an id, updates,
+// bulk and by-query requests, are resent only if their context was made with
+// RetryUnsafe.
+func Retry(attempts int, backoff time.Duration) Interceptor {
+	return func(req *Request, next Handler) (*Response, error) {
+		wait := backoff
+		for {
+			resp, err := next(req)
+			if err == nil || req.Attempt+1 >= attempts || !retryable(resp) || !idempotent(req) {
+				return resp, err
+			}
+			select {
+			case <-req.Context.Done():
+				return resp, err
+			case <-time.After(wait):
+			}
+			wait *= 2
+			req.Attempt++
+		}
+	}
+}
+
+type retryUnsafeKey struct{}
+
+// RetryUnsafe returns a context that lets Retry resend the requests made with
+// it even if they are not idempotent, see IndexReference.WithContext.
+func RetryUnsafe(ctx context.Context) context.Context {
+	return context.WithValue(ctx, retryUnsafeKey{}, true)
+}
+
+// idempotent reports whether req can be sent again after a failure that may
+// have happened once the server applied it.
+func idempotent(req *Request) bool {
+	if req.Context != nil && req.Context.Value(retryUnsafeKey{}) != nil {
+		return true
+	}
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	path := req.Path
+	if i := strings.Index(path, "?"); i >= 0 {
+		path = path[:i]
+	}
+	switch req.Operation {
+	case "index":
+		// Documents posted without an id get a new one every time.
+		return !strings.HasSuffix(path, "/_doc")
+	case "search", "msearch", "mget", "count", "refresh":
+		return true
+	}
+	return false
+}
+
+func retryable(resp *Response) bool {
+	if resp == nil {
+		return true
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// describe extracts the index and the operation name from an API path such as
+// "/orders/_doc/1/_update".
+func describe(method, path string) (string, string) {
+	if i := strings.Index(path, "?"); i >= 0 {
+		path = path[:i]
+	}
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	index := ""
+	if len(parts) > 0 && !strings.HasPrefix(parts[0], "_") {
+		index = parts[0]
+		parts = parts[1:]
+	}
+
+	endpoints := make([]string, 0)
+	for _, part := range parts {
+		if strings.HasPrefix(part, "_") {
+			endpoints = append(endpoints, part[1:])
+		}
+	}
+
+	switch {
+	case len(endpoints) == 0 && index != "":
+		switch method {
+		case http.MethodPut:
+			return index, "create_index"
+		case http.MethodDelete:
+			return index, "delete_index"
+		}
+		return index, "get_index"
+	case len(endpoints) == 0:
+		return index, strings.ToLower(method)
+	case endpoints[len(endpoints)-1] == "doc":
+		switch method {
+		case http.MethodGet:
+			return index, "get"
+		case http.MethodDelete:
+			return index, "delete"
+		}
+		return index, "index"
+	}
+	return index, strings.Join(endpoints, ".")
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,312 @@
+package docs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ExpiryField holds the expiry time of the documents written with ExpireAt.
+const ExpiryField = "_hopExpireAt"
+
+// ExpireAt stores t in ExpiryField. Reads skip the document once t has
+// passed and a Sweeper deletes it from the server.
+func ExpireAt(t time.Time) WriteOption {
+	return func(o *writeOptions) {
+		o.expireAt = t
+	}
+}
+
+// ExpireAfter is ExpireAt for a time relative to now.
+func ExpireAfter(d time.Duration) WriteOption {
+	return ExpireAt(time.Now().Add(d))
+}
+
+// expired reports whether doc has an expiry that is not after now.
+func expired(doc *Document, now time.Time) bool {
+	if doc == nil {
+		return false
+	}
+	value, ok := doc.Source[ExpiryField].(string)
+	if !ok {
+		return false
+	}
+	expireAt, err := time.Parse(time.RFC3339Nano, value)
+	return err == nil && !expireAt.After(now)
+}
+
+// unexpired drops the expired documents of docs in place.
+func unexpired(docs []Document) []Document {
+	now := time.Now()
+	kept := docs[:0]
+	for idx := range docs {
+		if !expired(&docs[idx], now) {
+			kept = append(kept, docs[idx])
+		}
+	}
+	return kept
+}
+
+type SweepStats struct {
+	Sweeps    int
+	Errors    int
+	Deleted   int
+	LastSweep time.Time
+	LastError error
+}
+
+// Sweeper deletes the expired documents of some indexes periodically. Expired
+// documents are removed from the server even on indexes with soft deletes,
+// and no history is recorded for them. On indexes passed to TrackDeletes they
+// are deleted one by one to leave their tombstones.
+type Sweeper struct {
+	// Interval between sweeps, 1m when not positive.
+	Interval time.Duration
+	// BatchSize is the number of documents deleted per request, 1000 when
+	// not positive.
+	BatchSize int
+
+	db      *HopDoc
+	indexes []string
+	mu      sync.Mutex
+	stats   map[string]*SweepStats
+	started bool
+	stop    chan struct{}
+	done    chan struct{}
+	once    sync.Once
+}
+
+func (h *HopDoc) Sweeper(indexes ...string) *Sweeper {
+	return &Sweeper{Interval: time.Minute, BatchSize: 1000, db: h, indexes: indexes,
+		stats: make(map[string]*SweepStats), stop: make(chan struct{}), done: make(chan struct{})}
+}
+
+// Start sweeps in the background until Stop or HopDoc.Close is called. It
+// does nothing if the sweeper was already started.
+func (s *Sweeper) Start() {
+	s.mu.Lock()
+	if s.started {
+		s.mu.Unlock()
+		return
+	}
+	s.started = true
+	s.mu.Unlock()
+	s.db.mu.Lock()
+	s.db.sweepers = append(s.db.sweepers, s)
+	s.db.mu.Unlock()
+	go s.run()
+}
+
+func (s *Sweeper) run() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.interval())
+	defer ticker.Stop()
+	for {
+		s.Sweep()
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Sweeper) interval() time.Duration {
+	if s.Interval <= 0 {
+		return time.Minute
+	}
+	return s.Interval
+}
+
+func (s *Sweeper) batchSize() int {
+	if s.BatchSize <= 0 {
+		return 1000
+	}
+	return s.BatchSize
+}
+
+// Stop ends the background sweeps, waiting for the current one to finish.
+func (s *Sweeper) Stop() {
+	s.once.Do(func() {
+		close(s.stop)
+		s.mu.Lock()
+		started := s.started
+		s.mu.Unlock()
+		if started {
+			<-s.done
+		}
+	})
+}
+
+// Sweep deletes the expired documents of every index now and returns how
+// many were deleted.
+func (s *Sweeper) Sweep() (int, error) {
+	total := 0
+	var firstErr error
+	for _, index := range s.indexes {
+		deleted, err := s.sweep(index)
+		total += deleted
+
+		s.mu.Lock()
+		stats := s.statsOf(index)
+		stats.Sweeps++
+		stats.Deleted += deleted
+		stats.LastSweep = time.Now()
+		stats.LastError = err
+		if err != nil {
+			stats.Errors++
+		}
+		s.mu.Unlock()
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return total, firstErr
+}
+
+func expiredQuery() map[string]interface{} {
+	return map[string]interface{}{"range": map[string]interface{}{
+		ExpiryField: map[string]interface{}{"lte": time.Now().UTC().Format(time.RFC3339Nano)},
+	}}
+}
+
+func (s *Sweeper) sweep(index string) (int, error) {
+	if s.db.lookup(index).getTrackDeletes() {
+		return s.sweepTracked(index)
+	}
+	deleted := 0
+	for {
+		body, err := json.Marshal(map[string]interface{}{
+			"query":    expiredQuery(),
+			"max_docs": s.batchSize(),
+		})
+		if err != nil {
+			return deleted, err
+		}
+		resp, err := s.db.client.Post(fmt.Sprintf("/%s/_delete_by_query?refresh=true&conflicts=proceed", index), body)
+		if IsNotFound(err) {
+			return deleted, nil
+		}
+		if err != nil {
+			return deleted, fmt.Errorf("could not sweep %s: %v", index, err)
+		}
+		var result byQueryResponse
+		if err := json.Unmarshal(resp, &result); err != nil {
+			return deleted, err
+		}
+		deleted += result.Deleted
+		if result.Deleted < s.batchSize() {
+			return deleted, nil
+		}
+		select {
+		case <-s.stop:
+			return deleted, nil
+		default:
+		}
+	}
+}
+
+// sweepTracked deletes the expired documents of index through Delete so
+// that the change feeds see them go. Documents written again since they were
+// found are kept.
+func (s *Sweeper) sweepTracked(index string) (int, error) {
+	deleted := 0
+	for {
+		body, err := json.Marshal(map[string]interface{}{
+			"query":               expiredQuery(),
+			"size":                s.batchSize(),
+			"_source":             false,
+			"seq_no_primary_term": true,
+		})
+		if err != nil {
+			return deleted, err
+		}
+		resp, err := s.db.client.Post(fmt.Sprintf("/%s/_search", index), body)
+		if IsNotFound(err) {
+			return deleted, nil
+		}
+		if err != nil {
+			return deleted, fmt.Errorf("could not sweep %s: %v", index, err)
+		}
+		var result IndexGetResponse
+		if err := json.Unmarshal(resp, &result); err != nil {
+			return deleted, err
+		}
+		for _, doc := range result.Hits.Hits {
+			o := writeOptions{match: true, seqNo: doc.SeqNo, primaryTerm: doc.PrimaryTerm}
+			err := s.db.deleteDocument(s.db.client, index, doc.Id, o)
+			if IsConflict(err) || IsNotFound(err) {
+				continue
+			}
+			if err != nil {
+				return deleted, fmt.Errorf("could not sweep %s: %v", index, err)
+			}
+			deleted++
+		}
+		if len(result.Hits.Hits) < s.batchSize() {
+			return deleted, nil
+		}
+		if err := s.db.Index(index).Refresh(); err != nil {
+			return deleted, err
+		}
+		select {
+		case <-s.stop:
+			return deleted, nil
+		default:
+		}
+	}
+}
+
+func (s *Sweeper) statsOf(index string) *SweepStats {
+	stats, ok := s.stats[index]
+	if !ok {
+		stats = &SweepStats{}
+		s.stats[index] = stats
+	}
+	return stats
+}
+
+// Stats returns the counters of the sweeps of index.
+func (s *Sweeper) Stats(index string) SweepStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return *s.statsOf(index)
+}
+
+// WritePrometheus writes the counters of every index in the Prometheus text
+// format.
+func (s *Sweeper) WritePrometheus(w io.Writer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	indexes := make([]string, 0, len(s.stats))
+	for index := range s.stats {
+		indexes = append(indexes, index)
+	}
+	sort.Strings(indexes)
+
+	fmt.Fprintln(w, "# HELP hop_docs_sweeps_total Sweeps of expired documents.")
+	fmt.Fprintln(w, "# TYPE hop_docs_sweeps_total counter")
+	for _, index := range indexes {
+		stats := s.stats[index]
+		fmt.Fprintf(w, "hop_docs_sweeps_total{index=%q,status=\"ok\"} %d\n", index, stats.Sweeps-stats.Errors)
+		fmt.Fprintf(w, "hop_docs_sweeps_total{index=%q,status=\"error\"} %d\n", index, stats.Errors)
+	}
+
+	fmt.Fprintln(w, "# HELP hop_docs_swept_documents_total Expired documents deleted by sweeps.")
+	fmt.Fprintln(w, "# TYPE hop_docs_swept_documents_total counter")
+	for _, index := range indexes {
+		if _, err := fmt.Fprintf(w, "hop_docs_swept_documents_total{index=%q} %d\n", index, s.stats[index].Deleted); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Sweeper) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	s.WritePrometheus(w)
+}
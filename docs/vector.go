@@ -0,0 +1,164 @@
+package docs
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Vector is an embedding, stored in a field mapped with DenseVector.
+type Vector []float32
+
+// Similarity is the function used to compare vectors.
+type Similarity string
+
+const (
+	Cosine     Similarity = "cosine"
+	DotProduct Similarity = "dot_product"
+	L2Norm     Similarity = "l2_norm"
+)
+
+// DenseVector maps a field holding vectors of dims dimensions.
+func DenseVector(dims int) FieldMapping {
+	return FieldMapping{Type: DenseVectorType, Dims: dims}
+}
+
+func (v Vector) Dot(w Vector) float64 {
+	var sum float64
+	for idx := 0; idx < len(v) && idx < len(w); idx++ {
+		sum += float64(v[idx]) * float64(w[idx])
+	}
+	return sum
+}
+
+func (v Vector) Norm() float64 {
+	return math.Sqrt(v.Dot(v))
+}
+
+// Cosine returns the cosine of the angle between v and w, 0 if one of them
+// is zero.
+func (v Vector) Cosine(w Vector) float64 {
+	norms := v.Norm() * w.Norm()
+	if norms == 0 {
+		return 0
+	}
+	return v.Dot(w) / norms
+}
+
+// Distance returns the euclidean distance between v and w.
+func (v Vector) Distance(w Vector) float64 {
+	var sum float64
+	for idx := 0; idx < len(v) && idx < len(w); idx++ {
+		d := float64(v[idx]) - float64(w[idx])
+		sum += d * d
+	}
+	return math.Sqrt(sum)
+}
+
+// Score compares v and w so that closer vectors score higher: the cosine,
+// the dot product, or 1 / (1 + distance) for L2Norm.
+func (s Similarity) Score(v, w Vector) float64 {
+	switch s {
+	case DotProduct:
+		return v.Dot(w)
+	case L2Norm:
+		return 1 / (1 + v.Distance(w))
+	default:
+		return v.Cosine(w)
+	}
+}
+
+// VectorQuery scores documents by the similarity of the vector in Field to
+// Vector.
+type VectorQuery struct {
+	Field      string
+	Vector     Vector
+	K          int
+	Similarity Similarity
+	// Boost weights the similarity against the text searches and the Where
+	// clauses the query is combined with, 1 by default.
+	Boost float64
+}
+
+// script turns the similarity into a positive score, as required by
+// script_score.
+func (q VectorQuery) script() string {
+	switch q.Similarity {
+	case DotProduct:
+		return fmt.Sprintf("double value = dotProduct(params.vector, '%s'); return sigmoid(1, Math.E, -value);", q.Field)
+	case L2Norm:
+		return fmt.Sprintf("1 / (1 + l2norm(params.vector, '%s'))", q.Field)
+	default:
+		return fmt.Sprintf("cosineSimilarity(params.vector, '%s') + 1.0", q.Field)
+	}
+}
+
+// query scores every document with a script rather than running an
+// approximate kNN search, so the exact nearest documents are found among
+// the ones that pass the filters of the bool query it is part of.
+func (q VectorQuery) query() map[string]interface{} {
+	scriptScore := map[string]interface{}{
+		"query": map[string]interface{}{"exists": map[string]interface{}{"field": q.Field}},
+		"script": map[string]interface{}{
+			"source": q.script(),
+			"params": map[string]interface{}{"vector": q.Vector},
+		},
+	}
+	if q.Boost != 0 {
+		scriptScore["boost"] = q.Boost
+	}
+	return map[string]interface{}{"script_score": scriptScore}
+}
+
+// Nearest returns the k documents whose vector in field is the most similar
+// to vector by cosine. Where conditions only filter the candidates, while
+// Search adds to the score for hybrid ranking; see NearestWith for the other
+// similarities and the weighting. The search is exact: every document that
+// passes the filters is scored, so queries slow down linearly with the index
+// and suit up to a few hundred thousand candidates.
+func (i *IndexReference) Nearest(field string, vector Vector, k int) *IndexReference {
+	return i.NearestWith(VectorQuery{Field: field, Vector: vector, K: k})
+}
+
+func (i *IndexReference) NearestWith(query VectorQuery) *IndexReference {
+	i.vector = &query
+	if query.K > 0 {
+		i.limit = query.K
+	}
+	return i
+}
+
+// similarities sets the Similarity of docs to the vector of the query.
+func (q *VectorQuery) similarities(docs []Document) {
+	for idx := range docs {
+		if vector, ok := vectorOf(sourceField(docs[idx].Source, q.Field)); ok {
+			docs[idx].Similarity = q.Similarity.Score(q.Vector, vector)
+		}
+	}
+}
+
+func sourceField(source map[string]interface{}, field string) interface{} {
+	var current interface{} = source
+	for _, key := range strings.Split(field, ".") {
+		object, _ := current.(map[string]interface{})
+		current = object[key]
+	}
+	return current
+}
+
+// vectorOf converts a vector decoded from JSON.
+func vectorOf(value interface{}) (Vector, bool) {
+	values, ok := value.([]interface{})
+	if !ok {
+		return nil, false
+	}
+	vector := make(Vector, 0, len(values))
+	for _, value := range values {
+		number, ok := value.(float64)
+		if !ok {
+			return nil, false
+		}
+		vector = append(vector, float32(number))
+	}
+	return vector, true
+}
@@ -0,0 +1,108 @@
+package docs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// WriteOption changes how a single write is performed. Conditional writes are
+// never kept in the offline journal since their condition can only be
+// checked by the server.
+type WriteOption func(*writeOptions)
+
+type writeOptions struct {
+	create      bool
+	match       bool
+	seqNo       int64
+	primaryTerm int64
+	expireAt    time.Time
+	parent      string
+	cascade     []string
+}
+
+// CreateOnly makes SetData fail with a conflict if the document exists.
+func CreateOnly() WriteOption {
+	return func(o *writeOptions) {
+		o.create = true
+	}
+}
+
+// IfMatch makes the write fail with a conflict unless the document is still
+// at the given sequence number and primary term, as read in Document.
+func IfMatch(seqNo, primaryTerm int64) WriteOption {
+	return func(o *writeOptions) {
+		o.match = true
+		o.seqNo = seqNo
+		o.primaryTerm = primaryTerm
+	}
+}
+
+func newWriteOptions(options []WriteOption) writeOptions {
+	var o writeOptions
+	for _, option := range options {
+		option(&o)
+	}
+	return o
+}
+
+func (o writeOptions) conditional() bool {
+	return o.create || o.match
+}
+
+func (o writeOptions) query() string {
+	values := url.Values{}
+	if o.create {
+		values.Set("op_type", "create")
+	}
+	if o.match {
+		values.Set("if_seq_no", strconv.FormatInt(o.seqNo, 10))
+		values.Set("if_primary_term", strconv.FormatInt(o.primaryTerm, 10))
+	}
+	if len(values) == 0 {
+		return ""
+	}
+	return "?" + values.Encode()
+}
+
+// withFields adds the fields set by the options, the expiry and the parent
+// path, to the payload b and to source.
+func (o writeOptions) withFields(source map[string]interface{}, b []byte) ([]byte, error) {
+	fields := make(map[string]interface{})
+	if !o.expireAt.IsZero() {
+		fields[ExpiryField] = o.expireAt.UTC().Format(time.RFC3339Nano)
+	}
+	if o.parent != "" {
+		fields[ParentField] = o.parent
+	}
+	if len(fields) == 0 {
+		return b, nil
+	}
+	var payload map[string]interface{}
+	if err := json.Unmarshal(b, &payload); err != nil {
+		return nil, fmt.Errorf("could not set fields: %v", err)
+	}
+	for field, value := range fields {
+		payload[field] = value
+		if source != nil {
+			source[field] = value
+		}
+	}
+	return json.Marshal(payload)
+}
+
+// IsNotFound reports whether err is the 404 of a missing document or index.
+func IsNotFound(err error) bool {
+	var statusErr *StatusError
+	return errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound
+}
+
+// IsConflict reports whether err is the rejection of a conditional write.
+func IsConflict(err error) bool {
+	var statusErr *StatusError
+	return errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusConflict
+}
@@ -0,0 +1,243 @@
+package eventstore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"hopcolony.io/hopcolony/docs"
+)
+
+const (
+	// Any skips the version check of Append.
+	Any int64 = -1
+	// NoStream expects the stream to have no events yet.
+	NoStream int64 = 0
+)
+
+type Event struct {
+	Stream string `json:"stream"`
+	// Version is the position of the event in its stream, starting at 1.
+	Version    int64             `json:"version"`
+	Type       string            `json:"type"`
+	Data       interface{}       `json:"data"`
+	Metadata   map[string]string `json:"metadata,omitempty"`
+	RecordedAt time.Time         `json:"recordedAt"`
+}
+
+// Decode unmarshals the data of the event into v.
+func (e Event) Decode(v interface{}) error {
+	b, err := json.Marshal(e.Data)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+type ConcurrencyError struct {
+	Stream   string
+	Expected int64
+}
+
+func (e *ConcurrencyError) Error() string {
+	return fmt.Sprintf("stream %s is not at version %d", e.Stream, e.Expected)
+}
+
+// PartialAppendError is returned when only the first Written events of a
+// batch were appended, see Append.
+type PartialAppendError struct {
+	Stream  string
+	Written int
+	Err     error
+}
+
+func (e *PartialAppendError) Error() string {
+	return fmt.Sprintf("only %d events were appended to stream %s: %v", e.Written, e.Stream, e.Err)
+}
+
+func (e *PartialAppendError) Unwrap() error {
+	return e.Err
+}
+
+type Handler func(ctx context.Context, event Event) error
+
+// Store keeps the events of every stream in one index, as one document per
+// event with the id "<stream>:<version>". Appends are guarded by creating
+// the document of the next version, which fails if another writer got there
+// first. Subscriptions follow the sequence numbers of the index, so they see
+// the events of all streams in order only if the index has a single shard.
+type Store struct {
+	Index string
+	db    *docs.HopDoc
+}
+
+func New(db *docs.HopDoc, index string) *Store {
+	return &Store{Index: index, db: db}
+}
+
+func eventId(stream string, version int64) string {
+	return fmt.Sprintf("%s:%019d", stream, version)
+}
+
+// Append adds events to the end of stream if it is at expectedVersion, or
+// wherever it is with Any, and returns the new version of the stream. It
+// returns a *ConcurrencyError if the stream is at another version.
+//
+// A batch of several events is not atomic: the events are written one
+// request each, so another Append to the stream, in particular one with
+// Any, can take a version in the middle of the batch. Append then returns a
+// *PartialAppendError, the events before it are kept and the returned
+// version says how far the stream got. Writers that need all or nothing
+// must append one event at a time or be the only writer of the stream.
+func (s *Store) Append(stream string, expectedVersion int64, events ...Event) (int64, error) {
+	for {
+		version := expectedVersion
+		var err error
+		if expectedVersion == Any {
+			version, err = s.Version(stream)
+		} else {
+			err = s.check(stream, expectedVersion)
+		}
+		if err != nil || len(events) == 0 {
+			return version, err
+		}
+
+		last, written, err := s.write(stream, version, events)
+		if written || err != nil {
+			return last, err
+		}
+		if expectedVersion != Any {
+			return 0, &ConcurrencyError{stream, expectedVersion}
+		}
+	}
+}
+
+// check makes sure stream got to version. Creating the next version only
+// proves it is not past it.
+func (s *Store) check(stream string, version int64) error {
+	if version == NoStream {
+		return nil
+	}
+	snapshot := s.db.Index(s.Index).Document(eventId(stream, version)).Get()
+	if docs.IsNotFound(snapshot.Err) {
+		return &ConcurrencyError{stream, version}
+	}
+	if !snapshot.Success {
+		return fmt.Errorf("could not read stream %s: %s", stream, snapshot.Reason)
+	}
+	return nil
+}
+
+// write appends events after version. It returns false if another writer
+// took the first version.
+func (s *Store) write(stream string, version int64, events []Event) (int64, bool, error) {
+	now := time.Now().UTC()
+	for i, event := range events {
+		event.Stream, event.Version, event.RecordedAt = stream, version+1, now
+		snapshot := s.db.Index(s.Index).Document(eventId(stream, event.Version)).SetData(event, docs.CreateOnly())
+		if i == 0 && docs.IsConflict(snapshot.Err) {
+			return version, false, nil
+		}
+		if !snapshot.Success && i > 0 {
+			return version, true, &PartialAppendError{stream, i, fmt.Errorf("could not append event %d: %s", event.Version, snapshot.Reason)}
+		}
+		if !snapshot.Success {
+			return version, true, fmt.Errorf("could not append to stream %s: %s", stream, snapshot.Reason)
+		}
+		version++
+	}
+	return version, true, nil
+}
+
+// Version returns the version of the last event of stream, 0 if it has none.
+func (s *Store) Version(stream string) (int64, error) {
+	if err := s.refresh(); err != nil {
+		return 0, err
+	}
+	snapshot := s.query(stream).OrderBy("version", true).Limit(1).Get()
+	if !snapshot.Success {
+		return 0, fmt.Errorf("could not read stream %s: %s", stream, snapshot.Reason)
+	}
+	if len(snapshot.Docs) == 0 {
+		return 0, nil
+	}
+	var event Event
+	if err := snapshot.Docs[0].DataTo(&event); err != nil {
+		return 0, err
+	}
+	return event.Version, nil
+}
+
+// Read returns the events of stream from fromVersion on, in order.
+func (s *Store) Read(stream string, fromVersion int64) ([]Event, error) {
+	if err := s.refresh(); err != nil {
+		return nil, err
+	}
+	events := make([]Event, 0)
+	err := s.query(stream).Where("version", ">=", fromVersion).OrderBy("version", false).ForEach(func(doc *docs.Document) error {
+		var event Event
+		if err := doc.DataTo(&event); err != nil {
+			return err
+		}
+		events = append(events, event)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not read stream %s: %v", stream, err)
+	}
+	return events, nil
+}
+
+func (s *Store) query(stream string) *docs.IndexReference {
+	return s.db.Index(s.Index).Where("stream.keyword", "==", stream)
+}
+
+// refresh makes the appended events searchable. A store that has no events
+// yet reads as empty.
+func (s *Store) refresh() error {
+	exists, err := s.db.Index(s.Index).Exists()
+	if err != nil || !exists {
+		return err
+	}
+	return s.db.Index(s.Index).Refresh()
+}
+
+// Subscribe calls handler with the events of all streams as they are
+// appended, until ctx is done or handler fails. The position is saved under
+// name after every event, so a subscription resumes where it stopped and
+// events are delivered at least once. This makes the store usable as an
+// outbox: append the events with the state change and publish them from a
+// subscription.
+func (s *Store) Subscribe(ctx context.Context, name string, handler Handler) error {
+	feed := s.db.ChangeFeed(ctx, s.Index, -1)
+	feed.Name = s.checkpoint(name)
+	feed.Checkpoints = docs.DocumentCheckpoints{DB: s.db}
+	return feed.Run(docs.SinkFunc(func(ctx context.Context, change docs.ChangeEvent) error {
+		event, err := decode(change)
+		if err != nil || event == nil {
+			return err
+		}
+		return handler(ctx, *event)
+	}))
+}
+
+func (s *Store) checkpoint(name string) string {
+	return s.Index + ":" + name
+}
+
+// decode returns the event written by change, nil for anything else.
+func decode(change docs.ChangeEvent) (*Event, error) {
+	if change.Type != docs.CreateEvent {
+		return nil, nil
+	}
+	b, err := json.Marshal(change.Source)
+	if err != nil {
+		return nil, err
+	}
+	var event Event
+	if err := json.Unmarshal(b, &event); err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
@@ -0,0 +1,80 @@
+package eventstore
+
+import (
+	"context"
+	"fmt"
+
+	"hopcolony.io/hopcolony/docs"
+)
+
+// Projection builds a read model in its own index out of the events of all
+// streams.
+type Projection struct {
+	Name  string
+	Index string
+	// Mapping is used to create Index on Rebuild.
+	Mapping docs.Mapping
+	Apply   func(event Event, model *docs.IndexReference) error
+}
+
+// Project keeps the read model of p up to date until ctx is done.
+func (s *Store) Project(ctx context.Context, p Projection) error {
+	return s.Subscribe(ctx, s.projection(p), func(ctx context.Context, event Event) error {
+		return p.Apply(event, s.db.Index(p.Index))
+	})
+}
+
+// Rebuild deletes the read model of p and applies every event stored so far
+// to a new one. Project must not run for p while it is rebuilt, and resumes
+// from where Rebuild stopped.
+func (s *Store) Rebuild(ctx context.Context, p Projection) error {
+	model := s.db.Index(p.Index)
+	exists, err := model.Exists()
+	if err != nil {
+		return err
+	}
+	if exists {
+		if err := model.Delete(); err != nil {
+			return err
+		}
+	}
+	if err := model.Create(p.Mapping); err != nil {
+		return err
+	}
+	if err := s.refresh(); err != nil {
+		return err
+	}
+
+	feed := s.db.ChangeFeed(ctx, s.Index, -1)
+	for {
+		changes, err := feed.Poll()
+		if err != nil {
+			return err
+		}
+		if len(changes) == 0 {
+			break
+		}
+		for _, change := range changes {
+			event, err := decode(change)
+			if err != nil {
+				return err
+			}
+			if event != nil {
+				if err := p.Apply(*event, s.db.Index(p.Index)); err != nil {
+					return fmt.Errorf("could not apply event %d of %s: %v", event.Version, event.Stream, err)
+				}
+			}
+			feed.Ack(change)
+		}
+	}
+
+	checkpoints := docs.DocumentCheckpoints{DB: s.db}
+	if err := checkpoints.Save(s.checkpoint(s.projection(p)), feed.Position()); err != nil {
+		return fmt.Errorf("could not save checkpoint: %v", err)
+	}
+	return nil
+}
+
+func (s *Store) projection(p Projection) string {
+	return "projection." + p.Name
+}
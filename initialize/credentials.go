@@ -0,0 +1,200 @@
+package initialize
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+var EmptyToken error = errors.New("Credentials provider returned an empty token")
+
+type Credentials struct {
+	Token  string    `json:"token"`
+	Expiry time.Time `json:"expiry"`
+}
+
+// CredentialsProvider returns the token sent with every request. Refresh is
+// called when the server rejects the current token, and should return a new
+// one if the source can produce it.
+type CredentialsProvider interface {
+	Token() (string, error)
+	Refresh() (string, error)
+}
+
+type staticCredentials struct {
+	token string
+}
+
+func StaticCredentials(token string) CredentialsProvider {
+	return &staticCredentials{token}
+}
+
+func (c *staticCredentials) Token() (string, error) {
+	if c.token == "" {
+		return "", EmptyToken
+	}
+	return c.token, nil
+}
+
+func (c *staticCredentials) Refresh() (string, error) {
+	return c.Token()
+}
+
+type envCredentials struct {
+	name string
+}
+
+// EnvCredentials reads the token from an environment variable every time it
+// is needed.
+func EnvCredentials(name string) CredentialsProvider {
+	return &envCredentials{name}
+}
+
+func (c *envCredentials) Token() (string, error) {
+	token := os.Getenv(c.name)
+	if token == "" {
+		return "", fmt.Errorf("environment variable %s is empty", c.name)
+	}
+	return token, nil
+}
+
+func (c *envCredentials) Refresh() (string, error) {
+	return c.Token()
+}
+
+type fileCredentials struct {
+	filename string
+	mu       sync.Mutex
+	modTime  time.Time
+	token    string
+}
+
+// FileCredentials reads the token from a file and reloads it whenever the
+// file changes. The file is either a Hop config or contains only the token.
+func FileCredentials(filename string) CredentialsProvider {
+	return &fileCredentials{filename: filename}
+}
+
+func (c *fileCredentials) Token() (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	info, err := os.Stat(c.filename)
+	if err != nil {
+		return "", err
+	}
+	if c.token != "" && info.ModTime().Equal(c.modTime) {
+		return c.token, nil
+	}
+	return c.load(info.ModTime())
+}
+
+func (c *fileCredentials) Refresh() (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	info, err := os.Stat(c.filename)
+	if err != nil {
+		return "", err
+	}
+	return c.load(info.ModTime())
+}
+
+func (c *fileCredentials) load(modTime time.Time) (string, error) {
+	file, err := ioutil.ReadFile(c.filename)
+	if err != nil {
+		return "", err
+	}
+
+	var config HopConfig
+	token := ""
+	if err := yaml.Unmarshal(file, &config); err == nil {
+		token = config.Token
+	}
+	if token == "" {
+		token = strings.TrimSpace(string(file))
+	}
+	if token == "" {
+		return "", EmptyToken
+	}
+
+	c.token = token
+	c.modTime = modTime
+	return token, nil
+}
+
+type refreshingCredentials struct {
+	fetch func() (Credentials, error)
+	skew  time.Duration
+	mu    sync.Mutex
+	creds Credentials
+}
+
+// RefreshingCredentials caches the credentials returned by fetch and calls
+// it again skew before they expire. Credentials without an expiry are kept
+// until the server rejects them.
+func RefreshingCredentials(fetch func() (Credentials, error), skew time.Duration) CredentialsProvider {
+	return &refreshingCredentials{fetch: fetch, skew: skew}
+}
+
+func (c *refreshingCredentials) Token() (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.creds.Token != "" && (c.creds.Expiry.IsZero() || time.Now().Add(c.skew).Before(c.creds.Expiry)) {
+		return c.creds.Token, nil
+	}
+	return c.refresh()
+}
+
+func (c *refreshingCredentials) Refresh() (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.refresh()
+}
+
+func (c *refreshingCredentials) refresh() (string, error) {
+	creds, err := c.fetch()
+	if err != nil {
+		return "", err
+	}
+	if creds.Token == "" {
+		return "", EmptyToken
+	}
+	c.creds = creds
+	return creds.Token, nil
+}
+
+// ExecCredentials runs a helper command to obtain the token. The helper
+// prints either the bare token or a JSON object with "token" and an optional
+// RFC 3339 "expiry".
+func ExecCredentials(skew time.Duration, command string, args ...string) CredentialsProvider {
+	return RefreshingCredentials(func() (Credentials, error) {
+		var stdout, stderr bytes.Buffer
+		cmd := exec.Command(command, args...)
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			return Credentials{}, fmt.Errorf("credentials helper failed: %v: %s", err, strings.TrimSpace(stderr.String()))
+		}
+
+		output := bytes.TrimSpace(stdout.Bytes())
+		var creds Credentials
+		if bytes.HasPrefix(output, []byte("{")) {
+			if err := json.Unmarshal(output, &creds); err != nil {
+				return Credentials{}, fmt.Errorf("could not parse credentials helper output: %v", err)
+			}
+			return creds, nil
+		}
+		return Credentials{Token: string(output)}, nil
+	}, skew)
+}
@@ -4,6 +4,8 @@ import (
 	b64 "encoding/base64"
 	"errors"
 	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strings"
 
 	"gopkg.in/yaml.v3"
@@ -52,41 +54,96 @@ func newHopConfigFromFile(filename string) (*HopConfig, error) {
 		return nil, err
 	}
 
+	if c.Identity == "" {
+		c.Identity = computeIdentity(c.Username, c.Project)
+	}
+
 	return c, nil
 }
 
+const ConfigFileName = ".hop.config"
+
+// DefaultConfigFile returns the .hop.config of the working directory if there
+// is one, or the one in the home directory otherwise.
+func DefaultConfigFile() string {
+	if _, err := os.Stat(ConfigFileName); err == nil {
+		return ConfigFileName
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ConfigFileName
+	}
+	return filepath.Join(home, ConfigFileName)
+}
+
+func SaveConfig(filename, username, project, token string) (HopConfig, error) {
+	if username == "" || project == "" || token == "" {
+		return HopConfig{}, InvalidConfig
+	}
+
+	config := newHopConfig(username, project, token)
+	b, err := yaml.Marshal(config)
+	if err != nil {
+		return HopConfig{}, err
+	}
+
+	if err := ioutil.WriteFile(filename, b, 0600); err != nil {
+		return HopConfig{}, err
+	}
+	return config, nil
+}
+
 var InvalidConfig error = errors.New("If you provide one of [username, project, token] or [namespace, project, token], you need to provide the 3 of them")
 var ConfigNotFound error = errors.New("Hop Config not found. Run 'hopctl login' or place a .hop.config file here.")
 
 type ProjectConfig struct {
-	Username   string
-	Project    string
-	Token      string
-	ConfigFile string
+	Username    string
+	Project     string
+	Token       string
+	ConfigFile  string
+	Credentials CredentialsProvider
 }
 type Project struct {
-	Config HopConfig
+	Config      HopConfig
+	Credentials CredentialsProvider
+}
+
+// Token returns the current token, asking the credentials provider if the
+// project has one.
+func (p Project) Token() (string, error) {
+	if p.Credentials == nil {
+		return p.Config.Token, nil
+	}
+	return p.Credentials.Token()
+}
+
+func (p Project) RefreshToken() (string, error) {
+	if p.Credentials == nil {
+		return p.Config.Token, nil
+	}
+	return p.Credentials.Refresh()
 }
 
 func newProject(config ProjectConfig) (*Project, error) {
+	hasToken := config.Token != "" || config.Credentials != nil
 	if config.Username != "" || config.Project != "" || config.Token != "" {
-		if config.Username != "" && config.Project != "" && config.Token != "" {
+		if config.Username != "" && config.Project != "" && hasToken {
 			return &Project{Config: newHopConfig(
 				config.Username,
 				config.Project,
 				config.Token,
-			)}, nil
+			), Credentials: config.Credentials}, nil
 		} else {
 			return nil, InvalidConfig
 		}
 	} else {
-		config, err := newHopConfigFromFile(config.ConfigFile)
+		hopConfig, err := newHopConfigFromFile(config.ConfigFile)
 
 		if err != nil {
 			return nil, ConfigNotFound
 		}
 
-		return &Project{Config: *config}, nil
+		return &Project{Config: *hopConfig, Credentials: config.Credentials}, nil
 
 	}
 }
@@ -0,0 +1,265 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"hopcolony.io/hopcolony/docs"
+)
+
+// Index holds a document per lock.
+const Index = ".hop.locks"
+
+var LeaseLost error = errors.New("lease was lost")
+
+type HeldError struct {
+	Name      string
+	Owner     string
+	ExpiresAt time.Time
+}
+
+func (e *HeldError) Error() string {
+	return fmt.Sprintf("lock %s is held by %s until %s", e.Name, e.Owner, e.ExpiresAt.Format(time.RFC3339))
+}
+
+// record is the lock document. Token grows by one on every acquisition and
+// is never reset, released locks are kept with a zero expiry.
+type record struct {
+	Owner     string    `json:"owner"`
+	Token     int64     `json:"token"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// Locker acquires leases on named locks. Expiry is checked against the local
+// clock, so TTL must be well above the clock skew between workers.
+type Locker struct {
+	// Owner identifies this worker, the hostname and pid by default.
+	Owner string
+	// TTL of the leases, 30s by default. They are renewed every TTL/3.
+	TTL time.Duration
+	db  *docs.HopDoc
+}
+
+func New(db *docs.HopDoc) *Locker {
+	host, _ := os.Hostname()
+	return &Locker{Owner: fmt.Sprintf("%s:%d", host, os.Getpid()), TTL: 30 * time.Second, db: db}
+}
+
+type Lease struct {
+	Name  string
+	Owner string
+	// Token is a fencing token: it is greater than the token of any lease
+	// acquired before on the same lock.
+	Token int64
+
+	locker      *Locker
+	ctx         context.Context
+	cancel      context.CancelFunc
+	mu          sync.Mutex
+	seqNo       int64
+	primaryTerm int64
+	expiresAt   time.Time
+	err         error
+	stop        chan struct{}
+	done        chan struct{}
+}
+
+// Acquire takes the lock if it is free or its lease expired. It returns a
+// *HeldError if another worker holds it. ctx only bounds the acquisition: the
+// lease is kept alive until it is released or lost.
+func (l *Locker) Acquire(ctx context.Context, name string) (*Lease, error) {
+	ref := l.db.Index(Index).WithContext(ctx).Document(name)
+	for {
+		expiresAt := time.Now().Add(l.ttl())
+		snapshot := ref.SetData(record{l.Owner, 1, expiresAt}, docs.CreateOnly())
+		if snapshot.Success {
+			return l.start(name, 1, expiresAt, snapshot.Doc), nil
+		}
+		if !docs.IsConflict(snapshot.Err) {
+			return nil, fmt.Errorf("could not acquire lock %s: %s", name, snapshot.Reason)
+		}
+
+		current := ref.Get()
+		if docs.IsNotFound(current.Err) {
+			continue
+		}
+		if !current.Success {
+			return nil, fmt.Errorf("could not read lock %s: %s", name, current.Reason)
+		}
+		var held record
+		if err := current.Doc.DataTo(&held); err != nil {
+			return nil, err
+		}
+		if time.Now().Before(held.ExpiresAt) {
+			return nil, &HeldError{name, held.Owner, held.ExpiresAt}
+		}
+
+		// Take over the expired lease, unless somebody else does it first.
+		expiresAt = time.Now().Add(l.ttl())
+		token := held.Token + 1
+		snapshot = ref.SetData(record{l.Owner, token, expiresAt}, docs.IfMatch(current.Doc.SeqNo, current.Doc.PrimaryTerm))
+		if snapshot.Success {
+			return l.start(name, token, expiresAt, snapshot.Doc), nil
+		}
+		if !docs.IsConflict(snapshot.Err) {
+			return nil, fmt.Errorf("could not acquire lock %s: %s", name, snapshot.Reason)
+		}
+	}
+}
+
+// Lock waits until the lock is acquired or ctx is done, retrying every poll.
+func (l *Locker) Lock(ctx context.Context, name string, poll time.Duration) (*Lease, error) {
+	for {
+		lease, err := l.Acquire(ctx, name)
+		var held *HeldError
+		if !errors.As(err, &held) {
+			return lease, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(poll):
+		}
+	}
+}
+
+// Break frees the lock whoever holds it, without waiting for the lease to
+// expire. Like Release it keeps the token, so that the next lease still gets
+// a greater one.
+func (l *Locker) Break(ctx context.Context, name string) error {
+	ref := l.db.Index(Index).WithContext(ctx).Document(name)
+	for {
+		current := ref.Get()
+		if docs.IsNotFound(current.Err) {
+			return nil
+		}
+		if !current.Success {
+			return fmt.Errorf("could not read lock %s: %s", name, current.Reason)
+		}
+		var held record
+		if err := current.Doc.DataTo(&held); err != nil {
+			return err
+		}
+		held.ExpiresAt = time.Time{}
+		snapshot := ref.SetData(held, docs.IfMatch(current.Doc.SeqNo, current.Doc.PrimaryTerm))
+		if snapshot.Success {
+			return nil
+		}
+		if !docs.IsConflict(snapshot.Err) {
+			return fmt.Errorf("could not break lock %s: %s", name, snapshot.Reason)
+		}
+	}
+}
+
+func (l *Locker) ttl() time.Duration {
+	if l.TTL == 0 {
+		return 30 * time.Second
+	}
+	return l.TTL
+}
+
+func (l *Locker) start(name string, token int64, expiresAt time.Time, doc *docs.Document) *Lease {
+	lease := &Lease{Name: name, Owner: l.Owner, Token: token, locker: l, expiresAt: expiresAt,
+		seqNo: doc.SeqNo, primaryTerm: doc.PrimaryTerm, stop: make(chan struct{}), done: make(chan struct{})}
+	lease.ctx, lease.cancel = context.WithCancel(context.Background())
+	go lease.keepalive()
+	return lease
+}
+
+// Context is cancelled when the lease is released or lost.
+func (lease *Lease) Context() context.Context {
+	return lease.ctx
+}
+
+// Err returns LeaseLost once the lease was taken by another worker or could
+// not be renewed before it expired.
+func (lease *Lease) Err() error {
+	lease.mu.Lock()
+	defer lease.mu.Unlock()
+	return lease.err
+}
+
+func (lease *Lease) keepalive() {
+	defer close(lease.done)
+	ticker := time.NewTicker(lease.locker.ttl() / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-lease.stop:
+			return
+		case <-lease.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		err := lease.write(time.Now().Add(lease.locker.ttl()))
+		lease.mu.Lock()
+		expired := !time.Now().Before(lease.expiresAt)
+		lease.mu.Unlock()
+		// Failed renewals are retried while the lease is still valid.
+		if docs.IsConflict(err) || err != nil && expired {
+			lease.lost()
+			return
+		}
+	}
+}
+
+func (lease *Lease) lost() {
+	lease.mu.Lock()
+	lease.err = LeaseLost
+	lease.mu.Unlock()
+	lease.cancel()
+}
+
+// write stores the lease with a new expiry, on the condition that nobody
+// changed it since it was last written.
+func (lease *Lease) write(expiresAt time.Time) error {
+	lease.mu.Lock()
+	seqNo, primaryTerm := lease.seqNo, lease.primaryTerm
+	lease.mu.Unlock()
+
+	ref := lease.locker.db.Index(Index).Document(lease.Name)
+	snapshot := ref.SetData(record{lease.Owner, lease.Token, expiresAt}, docs.IfMatch(seqNo, primaryTerm))
+	if !snapshot.Success && snapshot.Err != nil {
+		return snapshot.Err
+	}
+	if !snapshot.Success {
+		return errors.New(snapshot.Reason)
+	}
+
+	lease.mu.Lock()
+	defer lease.mu.Unlock()
+	lease.seqNo, lease.primaryTerm = snapshot.Doc.SeqNo, snapshot.Doc.PrimaryTerm
+	lease.expiresAt = expiresAt
+	return nil
+}
+
+// Release frees the lock so that it can be acquired right away.
+func (lease *Lease) Release() error {
+	lease.mu.Lock()
+	select {
+	case <-lease.stop:
+		lease.mu.Unlock()
+		return nil
+	default:
+		close(lease.stop)
+	}
+	lease.mu.Unlock()
+	<-lease.done
+	defer lease.cancel()
+
+	if err := lease.Err(); err != nil {
+		return err
+	}
+	if err := lease.write(time.Time{}); err != nil {
+		if docs.IsConflict(err) {
+			return LeaseLost
+		}
+		return fmt.Errorf("could not release lock %s: %v", lease.Name, err)
+	}
+	return nil
+}
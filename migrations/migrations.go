@@ -0,0 +1,232 @@
+package migrations
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"hopcolony.io/hopcolony/docs"
+	"hopcolony.io/hopcolony/lock"
+)
+
+// Index holds a record of every applied migration.
+const Index = ".hop.migrations"
+
+// LockName is the lock taken in lock.Index while migrations run.
+const LockName = "migrations"
+
+var MissingDown error = errors.New("migration can not be reverted")
+
+type LockedError struct {
+	Owner     string
+	ExpiresAt time.Time
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("migrations are locked by %s until %s", e.Owner, e.ExpiresAt.Format(time.RFC3339))
+}
+
+type Func func(db *docs.HopDoc) error
+
+// Step is the work of a migration. Down may be nil for steps that can not be
+// reverted.
+type Step struct {
+	Up   Func
+	Down Func
+}
+
+type Migration struct {
+	Version int
+	Name    string
+	Step
+}
+
+// Record is stored in Index for every applied migration.
+type Record struct {
+	Version   int       `json:"version"`
+	Name      string    `json:"name"`
+	AppliedAt time.Time `json:"appliedAt"`
+}
+
+type Migrator struct {
+	// Owner identifies this runner in the lock, the hostname and pid by
+	// default.
+	Owner string
+	// TTL of the lease on the lock, see lock.Locker. The lease is renewed
+	// while migrations run, so a runner that dies holds it for TTL at most.
+	TTL        time.Duration
+	db         *docs.HopDoc
+	migrations []Migration
+}
+
+func New(db *docs.HopDoc) *Migrator {
+	host, _ := os.Hostname()
+	return &Migrator{Owner: fmt.Sprintf("%s:%d", host, os.Getpid()), TTL: 30 * time.Second, db: db}
+}
+
+// Register adds a migration. Migrations run in ascending version order, and
+// registering a version twice panics.
+func (m *Migrator) Register(version int, name string, step Step) *Migrator {
+	for _, migration := range m.migrations {
+		if migration.Version == version {
+			panic(fmt.Sprintf("migrations: version %d registered twice", version))
+		}
+	}
+	m.migrations = append(m.migrations, Migration{version, name, step})
+	sort.SliceStable(m.migrations, func(a, b int) bool { return m.migrations[a].Version < m.migrations[b].Version })
+	return m
+}
+
+// Applied returns the records of the applied migrations, oldest first.
+func Applied(db *docs.HopDoc) ([]Record, error) {
+	records := make([]Record, 0)
+	exists, err := db.Index(Index).Exists()
+	if err != nil || !exists {
+		return records, err
+	}
+	if err := db.Index(Index).Refresh(); err != nil {
+		return nil, err
+	}
+	err = db.Index(Index).ForEach(func(doc *docs.Document) error {
+		var record Record
+		b, err := json.Marshal(doc.Source)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(b, &record); err != nil {
+			return err
+		}
+		records = append(records, record)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(records, func(a, b int) bool { return records[a].Version < records[b].Version })
+	return records, nil
+}
+
+// Unlock frees the lock left by a runner that died while applying
+// migrations, without waiting for its lease to expire.
+func Unlock(db *docs.HopDoc) error {
+	if err := lock.New(db).Break(context.Background(), LockName); err != nil {
+		return fmt.Errorf("could not unlock migrations: %v", err)
+	}
+	return nil
+}
+
+// Pending returns the registered migrations that are not applied yet.
+func (m *Migrator) Pending() ([]Migration, error) {
+	pending, _, err := m.pending()
+	return pending, err
+}
+
+// pending is Pending along with the newest applied version, 0 if none was.
+func (m *Migrator) pending() ([]Migration, int, error) {
+	records, err := Applied(m.db)
+	if err != nil {
+		return nil, 0, err
+	}
+	applied := make(map[int]bool)
+	newest := 0
+	for _, record := range records {
+		applied[record.Version] = true
+		newest = record.Version
+	}
+	pending := make([]Migration, 0)
+	for _, migration := range m.migrations {
+		if !applied[migration.Version] {
+			pending = append(pending, migration)
+		}
+	}
+	return pending, newest, nil
+}
+
+// Up applies every pending migration in order and returns how many ran. It
+// fails without running any if one is older than the newest applied one.
+func (m *Migrator) Up() (int, error) {
+	n := 0
+	err := m.locked(func(lease *lock.Lease) error {
+		pending, newest, err := m.pending()
+		if err != nil {
+			return err
+		}
+		if len(pending) > 0 && pending[0].Version < newest {
+			return fmt.Errorf("migration %d %s is older than the applied migration %d", pending[0].Version, pending[0].Name, newest)
+		}
+		for _, migration := range pending {
+			if err := lease.Err(); err != nil {
+				return err
+			}
+			if err := migration.Up(m.db); err != nil {
+				return fmt.Errorf("migration %d %s failed: %v", migration.Version, migration.Name, err)
+			}
+			record := Record{migration.Version, migration.Name, time.Now().UTC()}
+			if snapshot := m.db.Index(Index).Document(strconv.Itoa(migration.Version)).SetData(record); !snapshot.Success {
+				return fmt.Errorf("could not record migration %d: %s", migration.Version, snapshot.Reason)
+			}
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
+
+// Down reverts the last steps applied migrations, newest first.
+func (m *Migrator) Down(steps int) (int, error) {
+	n := 0
+	err := m.locked(func(lease *lock.Lease) error {
+		records, err := Applied(m.db)
+		if err != nil {
+			return err
+		}
+		registered := make(map[int]Migration)
+		for _, migration := range m.migrations {
+			registered[migration.Version] = migration
+		}
+		for i := len(records) - 1; i >= 0 && n < steps; i-- {
+			if err := lease.Err(); err != nil {
+				return err
+			}
+			migration, ok := registered[records[i].Version]
+			if !ok || migration.Down == nil {
+				return fmt.Errorf("migration %d %s: %v", records[i].Version, records[i].Name, MissingDown)
+			}
+			if err := migration.Down(m.db); err != nil {
+				return fmt.Errorf("reverting migration %d %s failed: %v", migration.Version, migration.Name, err)
+			}
+			if snapshot := m.db.Index(Index).Document(strconv.Itoa(migration.Version)).Delete(); !snapshot.Success {
+				return fmt.Errorf("could not remove record of migration %d: %s", migration.Version, snapshot.Reason)
+			}
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
+
+// locked runs fn while holding a lease on the migrations lock. fn stops
+// before the next migration if the lease is lost.
+func (m *Migrator) locked(fn func(lease *lock.Lease) error) error {
+	locker := lock.New(m.db)
+	locker.Owner, locker.TTL = m.Owner, m.TTL
+	lease, err := locker.Acquire(context.Background(), LockName)
+	var held *lock.HeldError
+	if errors.As(err, &held) {
+		return &LockedError{held.Owner, held.ExpiresAt}
+	}
+	if err != nil {
+		return fmt.Errorf("could not lock migrations: %v", err)
+	}
+
+	err = fn(lease)
+	if releaseErr := lease.Release(); err == nil {
+		err = releaseErr
+	}
+	return err
+}
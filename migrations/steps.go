@@ -0,0 +1,64 @@
+package migrations
+
+import (
+	"hopcolony.io/hopcolony/docs"
+)
+
+func script(index string, source string, params map[string]interface{}) Func {
+	return func(db *docs.HopDoc) error {
+		_, err := db.Index(index).UpdateByQuery(docs.Script{Source: source, Params: params})
+		return err
+	}
+}
+
+// AddFieldDefault sets field to value on the documents of index that do not
+// have it. Down keeps the field, since the documents that got the default can
+// not be told apart from the ones that already had it.
+func AddFieldDefault(index, field string, value interface{}) Step {
+	return Step{
+		Up: script(index, `if (ctx._source.containsKey(params.field)) { ctx.op = 'noop' } else { ctx._source[params.field] = params.value }`,
+			map[string]interface{}{"field": field, "value": value}),
+		Down: func(db *docs.HopDoc) error {
+			return nil
+		},
+	}
+}
+
+// RemoveField removes field from every document of index. It can not be
+// reverted.
+func RemoveField(index, field string) Step {
+	return Step{
+		Up: script(index, `if (ctx._source.containsKey(params.field)) { ctx._source.remove(params.field) } else { ctx.op = 'noop' }`,
+			map[string]interface{}{"field": field}),
+	}
+}
+
+// RenameField moves the value of from to to in every document of index.
+func RenameField(index, from, to string) Step {
+	rename := `if (ctx._source.containsKey(params.from)) { ctx._source[params.to] = ctx._source.remove(params.from) } else { ctx.op = 'noop' }`
+	return Step{
+		Up:   script(index, rename, map[string]interface{}{"from": from, "to": to}),
+		Down: script(index, rename, map[string]interface{}{"from": to, "to": from}),
+	}
+}
+
+// Backfill rewrites every document of index with the result of fn, skipping
+// the documents for which it returns nil. It can not be reverted.
+func Backfill(index string, fn func(doc *docs.Document) (map[string]interface{}, error)) Step {
+	return Step{
+		Up: func(db *docs.HopDoc) error {
+			writer := db.Index(index).BulkWriter()
+			err := db.Index(index).ForEach(func(doc *docs.Document) error {
+				source, err := fn(doc)
+				if err != nil || source == nil {
+					return err
+				}
+				return writer.Set(doc.Id, source)
+			})
+			if err != nil {
+				return err
+			}
+			return writer.Flush()
+		},
+	}
+}
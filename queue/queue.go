@@ -0,0 +1,356 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"hopcolony.io/hopcolony/docs"
+)
+
+const (
+	Ready   = "ready"
+	Running = "running"
+	Dead    = "dead"
+)
+
+var ClaimLost error = errors.New("job was claimed by another worker")
+
+type Job struct {
+	Id          string      `json:"id" hop:"id"`
+	Payload     interface{} `json:"payload"`
+	Priority    int         `json:"priority"`
+	RunAt       time.Time   `json:"runAt"`
+	Status      string      `json:"status"`
+	Attempts    int         `json:"attempts"`
+	LockedBy    string      `json:"lockedBy,omitempty"`
+	LockedUntil time.Time   `json:"lockedUntil"`
+	LastError   string      `json:"lastError,omitempty"`
+	CreatedAt   time.Time   `json:"createdAt" hop:"createdAt,auto"`
+
+	queue       *Queue
+	mu          sync.Mutex
+	seqNo       int64
+	primaryTerm int64
+}
+
+// Decode unmarshals the payload into v.
+func (j *Job) Decode(v interface{}) error {
+	b, err := json.Marshal(j.Payload)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// Queue stores its jobs as the documents of an index. Jobs are claimed with
+// writes conditioned on their sequence number, so each claim is won by a
+// single worker.
+type Queue struct {
+	Index string
+	// DeadLetter receives the jobs that failed MaxAttempts times, Index with a
+	// ".dead" suffix by default.
+	DeadLetter  string
+	MaxAttempts int
+	// VisibilityTimeout is how long a claim lasts without heartbeats, 30s by
+	// default. Running jobs send a heartbeat every third of it.
+	VisibilityTimeout time.Duration
+	// Backoff returns the delay before retrying a job that failed attempts
+	// times.
+	Backoff func(attempts int) time.Duration
+	// PollInterval is how long idle workers wait before looking for jobs.
+	PollInterval time.Duration
+	// Worker identifies the claims of this process.
+	Worker string
+	// OnError is called by Work with the errors it recovers from.
+	OnError func(err error)
+	db      *docs.HopDoc
+}
+
+func New(db *docs.HopDoc, index string) *Queue {
+	host, _ := os.Hostname()
+	return &Queue{
+		Index:             index,
+		DeadLetter:        index + ".dead",
+		MaxAttempts:       5,
+		VisibilityTimeout: 30 * time.Second,
+		Backoff:           ExponentialBackoff(time.Second, time.Hour),
+		PollInterval:      time.Second,
+		Worker:            fmt.Sprintf("%s:%d", host, os.Getpid()),
+		db:                db,
+	}
+}
+
+func (q *Queue) visibilityTimeout() time.Duration {
+	if q.VisibilityTimeout <= 0 {
+		return 30 * time.Second
+	}
+	return q.VisibilityTimeout
+}
+
+func (q *Queue) heartbeatInterval() time.Duration {
+	if interval := q.visibilityTimeout() / 3; interval > 0 {
+		return interval
+	}
+	return q.visibilityTimeout()
+}
+
+// ExponentialBackoff doubles the delay after every attempt, up to max.
+func ExponentialBackoff(base, max time.Duration) func(attempts int) time.Duration {
+	return func(attempts int) time.Duration {
+		delay := base
+		for i := 1; i < attempts && delay < max; i++ {
+			delay *= 2
+		}
+		if delay > max {
+			return max
+		}
+		return delay
+	}
+}
+
+// Enqueue adds a job that runs no earlier than runAt. Jobs with a higher
+// priority are claimed first.
+func (q *Queue) Enqueue(payload interface{}, priority int, runAt time.Time) (*Job, error) {
+	job := &Job{Payload: payload, Priority: priority, RunAt: runAt.UTC(), Status: Ready, queue: q}
+	snapshot := q.db.Index(q.Index).Add(job)
+	if !snapshot.Success {
+		return nil, fmt.Errorf("could not enqueue job: %s", snapshot.Reason)
+	}
+	if snapshot.Doc != nil {
+		job.seqNo, job.primaryTerm = snapshot.Doc.SeqNo, snapshot.Doc.PrimaryTerm
+	}
+	return job, nil
+}
+
+// Claim takes the next job that is due, or one whose claim expired. Jobs
+// whose claim expired after MaxAttempts are moved to the dead letter index
+// instead. It returns nil if there is none.
+func (q *Queue) Claim() (*Job, error) {
+	now := time.Now().UTC()
+	ready := q.db.Index(q.Index).Where("status", "==", Ready).Where("runAt", "<=", now)
+	expired := q.db.Index(q.Index).Where("status", "==", Running).Where("lockedUntil", "<=", now)
+	for _, query := range []*docs.IndexReference{ready, expired} {
+		snapshot := query.OrderBy("priority", true).OrderBy("runAt", false).Limit(10).Get()
+		if !snapshot.Success {
+			return nil, fmt.Errorf("could not find jobs: %s", snapshot.Reason)
+		}
+		for _, candidate := range snapshot.Docs {
+			job, err := q.claim(candidate.Id, now)
+			if job != nil || err != nil {
+				return job, err
+			}
+		}
+	}
+	return nil, nil
+}
+
+// claim reads the job again, since searches may lag behind, and takes it if
+// it is still claimable.
+func (q *Queue) claim(id string, now time.Time) (*Job, error) {
+	job, err := q.get(id)
+	if err != nil || job == nil {
+		return nil, err
+	}
+	due := job.Status == Ready && !job.RunAt.After(now)
+	expired := job.Status == Running && !job.LockedUntil.After(now)
+	if !due && !expired {
+		return nil, nil
+	}
+	if expired && job.Attempts >= q.MaxAttempts {
+		err := job.bury(fmt.Sprintf("claim expired after %d attempts", job.Attempts))
+		if err == ClaimLost {
+			err = nil
+		}
+		return nil, err
+	}
+
+	err = job.save(func() {
+		job.Status = Running
+		job.Attempts++
+		job.LockedBy = q.Worker
+		job.LockedUntil = now.Add(q.visibilityTimeout())
+	})
+	if docs.IsConflict(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+func (q *Queue) get(id string) (*Job, error) {
+	snapshot := q.db.Index(q.Index).Document(id).Get()
+	if docs.IsNotFound(snapshot.Err) {
+		return nil, nil
+	}
+	if !snapshot.Success {
+		return nil, fmt.Errorf("could not get job %s: %s", id, snapshot.Reason)
+	}
+	job := &Job{queue: q, seqNo: snapshot.Doc.SeqNo, primaryTerm: snapshot.Doc.PrimaryTerm}
+	if err := snapshot.Doc.DataTo(job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// save applies change to the job and writes it if nobody changed it since it
+// was read.
+func (j *Job) save(change func()) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	change()
+	snapshot := j.queue.db.Index(j.queue.Index).Document(j.Id).SetData(j, docs.IfMatch(j.seqNo, j.primaryTerm))
+	if !snapshot.Success {
+		if snapshot.Err != nil {
+			return snapshot.Err
+		}
+		return errors.New(snapshot.Reason)
+	}
+	j.seqNo, j.primaryTerm = snapshot.Doc.SeqNo, snapshot.Doc.PrimaryTerm
+	return nil
+}
+
+func lost(err error) error {
+	if docs.IsConflict(err) {
+		return ClaimLost
+	}
+	return err
+}
+
+// Heartbeat extends the claim by the visibility timeout.
+func (j *Job) Heartbeat() error {
+	return lost(j.save(func() {
+		j.LockedUntil = time.Now().UTC().Add(j.queue.visibilityTimeout())
+	}))
+}
+
+// Complete removes the job from the queue.
+func (j *Job) Complete() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	snapshot := j.queue.db.Index(j.queue.Index).Document(j.Id).Delete(docs.IfMatch(j.seqNo, j.primaryTerm))
+	if !snapshot.Success {
+		return lost(snapshot.Err)
+	}
+	return nil
+}
+
+// Fail schedules the job to be retried after the backoff, or moves it to
+// the dead letter index once it failed MaxAttempts times.
+func (j *Job) Fail(cause error) error {
+	if j.Attempts < j.queue.MaxAttempts {
+		return lost(j.save(func() {
+			j.Status = Ready
+			j.RunAt = time.Now().UTC().Add(j.queue.Backoff(j.Attempts))
+			j.LastError = cause.Error()
+			j.LockedBy = ""
+			j.LockedUntil = time.Time{}
+		}))
+	}
+	return j.bury(cause.Error())
+}
+
+// bury moves the job to the dead letter index. The job is marked as dead
+// first, which fails if the claim was lost, and the dead letter is created
+// only once so that a retry after a failure does not write it again.
+func (j *Job) bury(cause string) error {
+	err := j.save(func() {
+		j.Status = Dead
+		j.LastError = cause
+		j.LockedBy = ""
+		j.LockedUntil = time.Time{}
+	})
+	if err != nil {
+		return lost(err)
+	}
+	snapshot := j.queue.db.Index(j.queue.DeadLetter).Document(j.Id).SetData(j, docs.CreateOnly())
+	if !snapshot.Success && !docs.IsConflict(snapshot.Err) {
+		return fmt.Errorf("could not move job %s to %s: %s", j.Id, j.queue.DeadLetter, snapshot.Reason)
+	}
+	return j.Complete()
+}
+
+type Handler func(ctx context.Context, job *Job) error
+
+// Work runs handler on the jobs of the queue with the given number of
+// workers until ctx is done, then waits for the running jobs to finish.
+// Claims are kept alive while handler runs, and the context passed to it is
+// cancelled if the claim is lost.
+func (q *Queue) Work(ctx context.Context, workers int, handler Handler) {
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.work(ctx, handler)
+		}()
+	}
+	wg.Wait()
+}
+
+func (q *Queue) work(ctx context.Context, handler Handler) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		job, err := q.Claim()
+		if err == nil && job != nil {
+			err = q.run(job, handler)
+		}
+		if err != nil && err != ClaimLost && q.OnError != nil {
+			q.OnError(err)
+		}
+		if job != nil && err == nil {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(q.PollInterval):
+		}
+	}
+}
+
+func (q *Queue) run(job *Job, handler Handler) error {
+	jobCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan struct{})
+	heartbeats := make(chan error, 1)
+	go func() {
+		ticker := time.NewTicker(q.heartbeatInterval())
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				heartbeats <- nil
+				return
+			case <-ticker.C:
+				if err := job.Heartbeat(); err == ClaimLost {
+					cancel()
+					heartbeats <- err
+					return
+				}
+			}
+		}
+	}()
+
+	err := handler(jobCtx, job)
+	close(done)
+	if lostErr := <-heartbeats; lostErr != nil {
+		return lostErr
+	}
+	if err != nil {
+		return job.Fail(err)
+	}
+	return job.Complete()
+}
@@ -0,0 +1,188 @@
+package scheduler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule returns the first fire time strictly after t, or the zero time if
+// there is none.
+type Schedule interface {
+	Next(t time.Time) time.Time
+}
+
+type interval time.Duration
+
+// Every fires every d, counting from the last fire. It panics if d is not
+// positive.
+func Every(d time.Duration) Schedule {
+	if d <= 0 {
+		panic(fmt.Sprintf("scheduler: non-positive interval %s", d))
+	}
+	return interval(d)
+}
+
+func (i interval) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(i))
+}
+
+// aligner is implemented by the schedules whose fire times depend on the
+// time they are counted from.
+type aligner interface {
+	// align returns the last fire time counted from from that is not after
+	// t.
+	align(from, t time.Time) time.Time
+}
+
+func (i interval) align(from, t time.Time) time.Time {
+	if i <= 0 || t.Before(from) {
+		return from
+	}
+	return from.Add(t.Sub(from) / time.Duration(i) * time.Duration(i))
+}
+
+// cron holds a bit per allowed value of each field.
+type cron struct {
+	minute, hour, dom, month, dow uint64
+	// Like in Vixie cron a day matches either field when both are
+	// restricted.
+	anyDom, anyDow bool
+}
+
+type bounds struct {
+	name     string
+	min, max int
+}
+
+var fields = []bounds{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+var descriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Cron parses a standard five field expression: minute, hour, day of month,
+// month and day of week. Fields accept *, lists, ranges and steps, e.g.
+// "*/15 9-17 * * 1-5". The descriptors @yearly, @monthly, @weekly, @daily,
+// @hourly and "@every <duration>" are accepted too. Fire times are computed
+// in the location of the time passed to Next.
+func Cron(expr string) (Schedule, error) {
+	expr = strings.TrimSpace(expr)
+	if strings.HasPrefix(expr, "@every ") {
+		d, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(expr, "@every ")))
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid cron expression %q: bad interval", expr)
+		}
+		return Every(d), nil
+	}
+	if descriptor, ok := descriptors[expr]; ok {
+		expr = descriptor
+	}
+
+	parts := strings.Fields(expr)
+	if len(parts) != len(fields) {
+		return nil, fmt.Errorf("invalid cron expression %q: expected %d fields", expr, len(fields))
+	}
+	bits := make([]uint64, len(fields))
+	for i, part := range parts {
+		var err error
+		if bits[i], err = parseField(part, fields[i]); err != nil {
+			return nil, fmt.Errorf("invalid cron expression %q: %v", expr, err)
+		}
+	}
+	// Sunday is both 0 and 7.
+	if bits[4]&(1<<7) != 0 {
+		bits[4] |= 1
+	}
+	return &cron{bits[0], bits[1], bits[2], bits[3], bits[4], strings.HasPrefix(parts[2], "*"), strings.HasPrefix(parts[4], "*")}, nil
+}
+
+// MustCron is like Cron but panics if the expression is invalid.
+func MustCron(expr string) Schedule {
+	schedule, err := Cron(expr)
+	if err != nil {
+		panic(err)
+	}
+	return schedule
+}
+
+func parseField(field string, b bounds) (uint64, error) {
+	var bits uint64
+	for _, item := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(item, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(item[i+1:]); err != nil || step <= 0 {
+				return 0, fmt.Errorf("bad step in %s %q", b.name, item)
+			}
+			item = item[:i]
+		}
+
+		low, high := b.min, b.max
+		if item != "*" {
+			var err error
+			ends := strings.SplitN(item, "-", 2)
+			if low, err = strconv.Atoi(ends[0]); err != nil {
+				return 0, fmt.Errorf("bad %s %q", b.name, item)
+			}
+			high = low
+			if len(ends) == 2 {
+				if high, err = strconv.Atoi(ends[1]); err != nil {
+					return 0, fmt.Errorf("bad %s %q", b.name, item)
+				}
+			} else if step > 1 {
+				// "5/15" means from 5 to the end every 15.
+				high = b.max
+			}
+		}
+		if low < b.min || high > b.max || low > high {
+			return 0, fmt.Errorf("%s %q out of range %d-%d", b.name, item, b.min, b.max)
+		}
+		for v := low; v <= high; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func (c *cron) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case c.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !c.matchDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case c.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case c.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func (c *cron) matchDay(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.anyDom || c.anyDow {
+		return dom && dow
+	}
+	return dom || dow
+}
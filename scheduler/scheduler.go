@@ -0,0 +1,283 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"hopcolony.io/hopcolony/docs"
+	"hopcolony.io/hopcolony/lock"
+)
+
+const (
+	// CheckpointIndex holds the last fire time of every task.
+	CheckpointIndex = ".hop.scheduler"
+	// RunsIndex holds a Record of every run.
+	RunsIndex = ".hop.scheduler.runs"
+)
+
+// CatchUp decides what happens with the fire times that passed while no
+// replica was running the scheduler.
+type CatchUp int
+
+const (
+	// RunOnce runs the task once for all the missed fire times.
+	RunOnce CatchUp = iota
+	// RunAll runs the task for every missed fire time, up to MaxCatchUp.
+	RunAll
+	// Skip runs the task once for the latest missed fire time if it is at
+	// most Grace late, and drops the missed fire times otherwise.
+	Skip
+)
+
+type Func func(ctx context.Context, fireTime time.Time) error
+
+type Task struct {
+	Name     string
+	Schedule Schedule
+	Func     Func
+	CatchUp  CatchUp
+}
+
+// Record is stored in RunsIndex for every run.
+type Record struct {
+	Task       string    `json:"task"`
+	FireTime   time.Time `json:"fireTime"`
+	Owner      string    `json:"owner"`
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt"`
+	Success    bool      `json:"success"`
+	Error      string    `json:"error,omitempty"`
+}
+
+type checkpoint struct {
+	LastFire time.Time `json:"lastFire"`
+	Owner    string    `json:"owner"`
+}
+
+// Scheduler runs its tasks on a single replica at a time, the one holding
+// the leader lock. Fire times are claimed by moving the task checkpoint with
+// a conditional write before running, so a fire time is never run twice even
+// if the leadership moves.
+type Scheduler struct {
+	// Name of the leader lock, "scheduler" by default.
+	Name   string
+	Locker *lock.Locker
+	// Tick is how often the tasks are checked, 1s by default.
+	Tick time.Duration
+	// Grace is how late a fire time can be and still run with Skip.
+	Grace time.Duration
+	// MaxCatchUp bounds the number of runs of RunAll.
+	MaxCatchUp int
+	// Location is used to compute the fire times, the local time by default.
+	Location *time.Location
+	// OnError is called with the errors the scheduler recovers from.
+	OnError func(err error)
+	db      *docs.HopDoc
+	tasks   []Task
+	mu      sync.Mutex
+	running map[string]bool
+}
+
+func New(db *docs.HopDoc) *Scheduler {
+	return &Scheduler{
+		Name:       "scheduler",
+		Locker:     lock.New(db),
+		Tick:       time.Second,
+		Grace:      time.Minute,
+		MaxCatchUp: 100,
+		Location:   time.Local,
+		db:         db,
+		running:    make(map[string]bool),
+	}
+}
+
+// Register adds a task. Task names must be unique.
+func (s *Scheduler) Register(task Task) *Scheduler {
+	s.tasks = append(s.tasks, task)
+	return s
+}
+
+// History returns the runs of a task, newest first. It can be narrowed down
+// like any other query, e.g. with Where("success", "==", false).
+func History(db *docs.HopDoc, task string) *docs.IndexReference {
+	return db.Index(RunsIndex).Where("task", "==", task).OrderBy("fireTime", true)
+}
+
+// Run competes for the leader lock and runs the tasks while holding it,
+// until ctx is done. Running tasks are cancelled when the leadership is lost
+// and waited for before returning.
+func (s *Scheduler) Run(ctx context.Context) {
+	for {
+		lease, err := s.Locker.Lock(ctx, s.Name, s.Tick)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			s.report(fmt.Errorf("could not acquire leadership: %v", err))
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(s.Tick):
+			}
+			continue
+		}
+
+		// Leadership ends when ctx is done or the lease is lost.
+		leadCtx, cancel := context.WithCancel(ctx)
+		go func() {
+			select {
+			case <-lease.Context().Done():
+				cancel()
+			case <-leadCtx.Done():
+			}
+		}()
+		s.lead(leadCtx)
+		cancel()
+		if err := lease.Release(); err != nil && err != lock.LeaseLost {
+			s.report(err)
+		}
+	}
+}
+
+func (s *Scheduler) lead(ctx context.Context) {
+	var wg sync.WaitGroup
+	ticker := time.NewTicker(s.Tick)
+	defer ticker.Stop()
+	for {
+		now := time.Now().In(s.Location)
+		for _, task := range s.tasks {
+			s.fire(ctx, &wg, task, now)
+		}
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// fire runs the due fire times of task, unless it is still running.
+func (s *Scheduler) fire(ctx context.Context, wg *sync.WaitGroup, task Task, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running[task.Name] {
+		return
+	}
+	fires, err := s.claim(task, now)
+	if err != nil {
+		s.report(err)
+		return
+	}
+	if len(fires) == 0 {
+		return
+	}
+
+	s.running[task.Name] = true
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for _, fire := range fires {
+			s.run(ctx, task, fire)
+		}
+		s.mu.Lock()
+		delete(s.running, task.Name)
+		s.mu.Unlock()
+	}()
+}
+
+// claim returns the fire times of task up to now that should run, after
+// moving its checkpoint past them.
+func (s *Scheduler) claim(task Task, now time.Time) ([]time.Time, error) {
+	ref := s.db.Index(CheckpointIndex).Document(task.Name)
+	snapshot := ref.Get()
+	if docs.IsNotFound(snapshot.Err) {
+		// New tasks count from now instead of catching up since forever.
+		created := ref.SetData(checkpoint{now, s.Locker.Owner}, docs.CreateOnly())
+		if !created.Success && !docs.IsConflict(created.Err) {
+			return nil, fmt.Errorf("could not create checkpoint of %s: %s", task.Name, created.Reason)
+		}
+		return nil, nil
+	}
+	if !snapshot.Success {
+		return nil, fmt.Errorf("could not read checkpoint of %s: %s", task.Name, snapshot.Reason)
+	}
+	var last checkpoint
+	if err := snapshot.Doc.DataTo(&last); err != nil {
+		return nil, err
+	}
+
+	keep := 1
+	if task.CatchUp == RunAll {
+		keep = s.MaxCatchUp
+	}
+	fires := recent(task.Schedule, last.LastFire.In(s.Location), now, keep)
+	if len(fires) == 0 {
+		return nil, nil
+	}
+	latest := fires[len(fires)-1]
+	switch {
+	case task.CatchUp == Skip && now.Sub(latest) > s.Grace:
+		fires = nil
+	case task.CatchUp != RunAll:
+		fires = fires[len(fires)-1:]
+	}
+
+	moved := ref.SetData(checkpoint{latest, s.Locker.Owner}, docs.IfMatch(snapshot.Doc.SeqNo, snapshot.Doc.PrimaryTerm))
+	if docs.IsConflict(moved.Err) {
+		return nil, nil
+	}
+	if !moved.Success {
+		return nil, fmt.Errorf("could not move checkpoint of %s: %s", task.Name, moved.Reason)
+	}
+	return fires, nil
+}
+
+// recent returns up to n of the latest fire times of schedule after from and
+// up to now. It looks back from now in growing steps, so that its cost does
+// not depend on how long ago from is.
+func recent(schedule Schedule, from, now time.Time, n int) []time.Time {
+	for span := time.Second; ; span *= 2 {
+		start := now.Add(-span)
+		if span <= 0 || !start.After(from) {
+			start = from
+		} else if a, ok := schedule.(aligner); ok {
+			start = a.align(from, start)
+		}
+
+		// A schedule that does not move forward would never get past now.
+		fires := make([]time.Time, 0, n)
+		for next, last := schedule.Next(start), start; !next.IsZero() && !next.After(now) && next.After(last); next, last = schedule.Next(next), next {
+			fires = append(fires, next)
+			if len(fires) > n {
+				fires = fires[1:]
+			}
+		}
+		if len(fires) >= n || start.Equal(from) {
+			return fires
+		}
+	}
+}
+
+func (s *Scheduler) run(ctx context.Context, task Task, fire time.Time) {
+	record := Record{Task: task.Name, FireTime: fire, Owner: s.Locker.Owner, StartedAt: time.Now().UTC()}
+	err := task.Func(ctx, fire)
+	record.FinishedAt = time.Now().UTC()
+	record.Success = err == nil
+	if err != nil {
+		record.Error = err.Error()
+	}
+
+	id := fmt.Sprintf("%s@%d", task.Name, fire.UnixNano())
+	if snapshot := s.db.Index(RunsIndex).Document(id).SetData(record); !snapshot.Success {
+		s.report(fmt.Errorf("could not record run of %s: %s", task.Name, snapshot.Reason))
+	}
+}
+
+func (s *Scheduler) report(err error) {
+	if s.OnError != nil {
+		s.OnError(err)
+	}
+}
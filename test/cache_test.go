@@ -0,0 +1,129 @@
+package test
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"hopcolony.io/hopcolony/docs"
+)
+
+func TestCacheMemoryEviction(t *testing.T) {
+	cache := docs.NewMemoryCache(2)
+	cache.Set("a", &docs.Document{Id: "a"}, 0)
+	cache.Set("b", &docs.Document{Id: "b"}, 0)
+	cache.Get("a")
+	cache.Set("c", &docs.Document{Id: "c"}, 0)
+
+	if _, ok := cache.Get("b"); ok {
+		t.Error(`Least recently used entry was not evicted`)
+	}
+	if _, ok := cache.Get("a"); !ok {
+		t.Error(`Recently used entry was evicted`)
+	}
+
+	cache.Set("d", &docs.Document{Id: "d"}, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if _, ok := cache.Get("d"); ok {
+		t.Error(`Expired entry was returned`)
+	}
+}
+
+func TestCacheDocumentReads(t *testing.T) {
+	var gets int32
+	release := make(chan struct{})
+	db := &docs.HopDoc{}
+	db.Use(func(req *docs.Request, next docs.Handler) (*docs.Response, error) {
+		switch req.Method {
+		case http.MethodGet:
+			atomic.AddInt32(&gets, 1)
+			<-release
+			return stub(http.StatusOK, `{"_index":"config","_id":"app","_version":1,"_source":{"mode":"fast"}}`)(req, next)
+		case http.MethodDelete:
+			return stub(http.StatusOK, `{}`)(req, next)
+		}
+		return stub(http.StatusOK, `{"_index":"config","_id":"app","_version":2}`)(req, next)
+	})
+	db.EnableCache(docs.CacheConfig{TTL: time.Minute})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if snapshot := db.Index("config").Document("app").Get(); !snapshot.Success {
+				t.Errorf(`Get failed: %s`, snapshot.Reason)
+			}
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if gets != 1 {
+		t.Errorf(`Expected concurrent misses to share 1 request but got %d`, gets)
+	}
+
+	snapshot := db.Index("config").Document("app").Get()
+	snapshot.Doc.Source["mode"] = "changed"
+	snapshot = db.Index("config").Document("app").Get()
+	if snapshot.Doc.Source["mode"] != "fast" {
+		t.Errorf(`Cached document was modified through a snapshot`)
+	}
+	if gets != 1 {
+		t.Errorf(`Expected reads to hit the cache but got %d requests`, gets)
+	}
+
+	db.Index("config").Document("app").SetData(map[string]interface{}{"mode": "slow"})
+	snapshot = db.Index("config").Document("app").Get()
+	if snapshot.Doc.Source["mode"] != "slow" || snapshot.Doc.Version != 2 || gets != 1 {
+		t.Errorf(`Expected SetData to update the cache but got "%v"`, snapshot.Doc)
+	}
+
+	db.Index("config").Document("app").Delete()
+	db.Index("config").Document("app").Get()
+	if gets != 2 {
+		t.Errorf(`Expected Delete to invalidate the cache, got %d requests`, gets)
+	}
+
+	stats := db.CacheStats()
+	if stats.Misses != 6 || stats.Shared != 4 || stats.Hits != 3 {
+		t.Errorf(`Unexpected cache stats %+v`, stats)
+	}
+}
+
+func TestCacheLoadRacingWrite(t *testing.T) {
+	var gets int32
+	loading, release := make(chan struct{}), make(chan struct{})
+	db := &docs.HopDoc{}
+	db.Use(func(req *docs.Request, next docs.Handler) (*docs.Response, error) {
+		if req.Method == http.MethodGet {
+			if atomic.AddInt32(&gets, 1) == 1 {
+				close(loading)
+				<-release
+			}
+			return stub(http.StatusOK, `{"_index":"config","_id":"app","_version":1,"_source":{"mode":"fast"}}`)(req, next)
+		}
+		return stub(http.StatusOK, `{}`)(req, next)
+	})
+	db.EnableCache(docs.CacheConfig{})
+
+	done := make(chan struct{})
+	go func() {
+		db.Index("config").Document("app").Get()
+		close(done)
+	}()
+	// The document is deleted while the first read is in flight, so what it
+	// read must not be cached.
+	<-loading
+	db.Index("config").Document("app").Delete()
+	close(release)
+	<-done
+
+	db.Index("config").Document("app").Get()
+	if gets != 2 {
+		t.Errorf(`Expected the read that raced with the delete not to be cached, got %d requests`, gets)
+	}
+}
@@ -0,0 +1,191 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"hopcolony.io/hopcolony/docs"
+)
+
+// changeLog serves the writes and tombstones of the "orders" index ordered
+// by sequence number.
+type changeLog struct {
+	writes     []string
+	tombstones []string
+	// checkpoint is the global checkpoint of the index, 100 when unset, and
+	// shards its number of shards, 1 when unset.
+	checkpoint int64
+	shards     int
+}
+
+func (l *changeLog) intercept(req *docs.Request, next docs.Handler) (*docs.Response, error) {
+	switch {
+	case strings.HasSuffix(req.Path, "/_refresh?ignore_unavailable=true"):
+		return stub(http.StatusOK, `{}`)(req, next)
+	case strings.HasSuffix(req.Path, "/_stats?level=shards"):
+		checkpoint, shards := l.checkpoint, make([]string, 0)
+		if checkpoint == 0 {
+			checkpoint = 100
+		}
+		for i := 0; i < l.shards || i == 0; i++ {
+			shards = append(shards, fmt.Sprintf(`"%d":[{"routing":{"primary":true},"seq_no":{"global_checkpoint":%d}}]`, i, checkpoint))
+		}
+		return stub(http.StatusOK, `{"indices":{"orders":{"shards":{`+strings.Join(shards, ",")+`}}}}`)(req, next)
+	}
+
+	var body struct {
+		Size  int
+		Query map[string]interface{}
+	}
+	json.Unmarshal(req.Body, &body)
+	b, _ := json.Marshal(body.Query)
+	var after, upTo int64
+	fmt.Sscanf(string(b[strings.Index(string(b), `"gt":`)+5:]), "%d", &after)
+	fmt.Sscanf(string(b[strings.Index(string(b), `"lte":`)+6:]), "%d", &upTo)
+
+	source := l.writes
+	if strings.HasPrefix(req.Path, "/"+docs.TombstoneIndex) {
+		source = l.tombstones
+	}
+	hits := make([]string, 0)
+	for _, hit := range source {
+		var seq struct {
+			SeqNo  int64                 `json:"_seq_no"`
+			Source struct{ SeqNo int64 } `json:"_source"`
+		}
+		json.Unmarshal([]byte(hit), &seq)
+		if seq.SeqNo+seq.Source.SeqNo > after && seq.SeqNo+seq.Source.SeqNo <= upTo && len(hits) < body.Size {
+			hits = append(hits, hit)
+		}
+	}
+	return stub(http.StatusOK, `{"hits":{"hits":[`+strings.Join(hits, ",")+`]}}`)(req, next)
+}
+
+func TestChangeFeedOrder(t *testing.T) {
+	log := &changeLog{
+		writes: []string{
+			`{"_index":"orders","_id":"a","_version":1,"_seq_no":1,"_source":{"total":10}}`,
+			`{"_index":"orders","_id":"b","_version":1,"_seq_no":2,"_source":{"total":20}}`,
+			`{"_index":"orders","_id":"a","_version":2,"_seq_no":4,"_source":{"total":15}}`,
+			`{"_index":"orders","_id":"c","_version":1,"_seq_no":5,"_source":{"total":30}}`,
+		},
+		tombstones: []string{
+			`{"_source":{"index":"orders","id":"b","seqNo":3}}`,
+		},
+		// The write with seq_no 5 is visible, but 4 may still be in flight.
+		checkpoint: 4,
+	}
+	db := &docs.HopDoc{}
+	db.Use(log.intercept)
+	db.TrackDeletes("orders")
+
+	dir, err := ioutil.TempDir("", "hop-checkpoints")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var out bytes.Buffer
+	feed := db.ChangeFeed(context.Background(), "orders", 0)
+	feed.Checkpoints = docs.FileCheckpoints{Dir: dir}
+	feed.Name = "analytics"
+	feed.BatchSize = 2
+
+	sink := docs.NDJSONSink(&out)
+	for i := 0; i < 3; i++ {
+		events, err := feed.Poll()
+		if err != nil {
+			t.Fatalf(`Poll failed: %s`, err)
+		}
+		for _, event := range events {
+			sink.Send(context.Background(), event)
+			feed.Ack(event)
+		}
+	}
+
+	expected := []string{"create a", "create b", "delete b", "update a"}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != len(expected) {
+		t.Fatalf(`Expected %d events but got %d: %s`, len(expected), len(lines), out.String())
+	}
+	for i, line := range lines {
+		var event docs.ChangeEvent
+		json.Unmarshal([]byte(line), &event)
+		if got := fmt.Sprintf("%s %s", event.Type, event.Id); got != expected[i] {
+			t.Errorf(`Expected event %d to be %q but got %q`, i, expected[i], got)
+		}
+	}
+
+	resumed := db.ChangeFeed(context.Background(), "orders", 0)
+	resumed.Checkpoints = docs.FileCheckpoints{Dir: dir}
+	resumed.Name = "analytics"
+	if events, _ := resumed.Poll(); len(events) != 0 || resumed.Position() != 4 {
+		t.Errorf(`Expected the feed to resume at 4 but got %d with %d events`, resumed.Position(), len(events))
+	}
+
+	log.checkpoint = 5
+	if events, _ := resumed.Poll(); len(events) != 1 || events[0].Id != "c" {
+		t.Errorf(`Expected the write to be polled once the checkpoint passed it but got %+v`, events)
+	}
+
+	log.shards = 2
+	if _, err := resumed.Poll(); err != docs.MultipleShards {
+		t.Errorf(`Expected MultipleShards but got %v`, err)
+	}
+}
+
+func TestChangeFeedWebhook(t *testing.T) {
+	var received []docs.ChangeEvent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event docs.ChangeEvent
+		json.NewDecoder(r.Body).Decode(&event)
+		received = append(received, event)
+		if event.Id == "b" {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer server.Close()
+
+	log := &changeLog{writes: []string{
+		`{"_index":"orders","_id":"a","_version":1,"_seq_no":1,"_source":{}}`,
+		`{"_index":"orders","_id":"b","_version":1,"_seq_no":2,"_source":{}}`,
+	}}
+	db := &docs.HopDoc{}
+	db.Use(log.intercept)
+
+	feed := db.ChangeFeed(context.Background(), "orders", 0)
+	if err := feed.Run(docs.WebhookSink(server.URL, nil)); err == nil {
+		t.Fatalf(`Expected the failing webhook to stop the feed`)
+	}
+	if len(received) != 2 || feed.Position() != 1 {
+		t.Errorf(`Expected the feed to stop at 1 but got %d after %d events`, feed.Position(), len(received))
+	}
+}
+
+func TestDocumentCheckpoints(t *testing.T) {
+	store, db := newIndexStore()
+	checkpoints := docs.DocumentCheckpoints{DB: db}
+	for position, name := range []string{"events:orders", "orders/2021?v=1#a"} {
+		if err := checkpoints.Save(name, int64(position+1)); err != nil {
+			t.Fatalf(`Save of %q failed: %s`, name, err)
+		}
+	}
+	if n := len(store.indexes[docs.CheckpointIndex]); n != 2 {
+		t.Errorf(`Expected 2 checkpoint documents but got %d`, n)
+	}
+	position, ok, err := checkpoints.Load("orders/2021?v=1#a")
+	if err != nil || !ok || position != 2 {
+		t.Errorf(`Expected position 2 but got %d, %v: %v`, position, ok, err)
+	}
+	if _, ok, _ := checkpoints.Load("orders"); ok {
+		t.Errorf(`Expected no checkpoint for orders`)
+	}
+}
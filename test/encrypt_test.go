@@ -0,0 +1,159 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"hopcolony.io/hopcolony/docs"
+)
+
+func TestEncryptStaticKeyProvider(t *testing.T) {
+	if _, err := docs.NewStaticKeyProvider("k1", []byte("short")); err == nil {
+		t.Error(`Created key provider with an invalid key`)
+	}
+
+	provider, err := docs.NewStaticKeyProvider("k1", bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatalf(`Could not create key provider: %v`, err)
+	}
+
+	dataKey := bytes.Repeat([]byte{7}, 32)
+	wrapped, err := provider.WrapKey(dataKey)
+	if err != nil {
+		t.Fatalf(`Could not wrap key: %v`, err)
+	}
+	if bytes.Contains(wrapped, dataKey) {
+		t.Error(`Wrapped key contains the plaintext key`)
+	}
+
+	unwrapped, err := provider.UnwrapKey(provider.KeyID(), wrapped)
+	if err != nil {
+		t.Fatalf(`Could not unwrap key: %v`, err)
+	}
+	if !bytes.Equal(unwrapped, dataKey) {
+		t.Error(`Unwrapped key does not match the original`)
+	}
+
+	if _, err := provider.UnwrapKey("unknown", wrapped); err == nil {
+		t.Error(`Unwrapped key with an unknown key id`)
+	}
+}
+
+func TestEncryptDeterministicQuery(t *testing.T) {
+	provider, err := docs.NewStaticKeyProvider("k1", bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatalf(`Could not create key provider: %v`, err)
+	}
+
+	db := &docs.HopDoc{}
+	db.EncryptFields("users", docs.EncryptionConfig{Provider: provider, Deterministic: []string{"email"}})
+
+	first := db.Index("users").Where("email", "==", "jane@example.com").CompoundBody(10, 0)
+	second := db.Index("users").Where("email", "==", "jane@example.com").CompoundBody(10, 0)
+
+	a := first.Query.Bool.Must[0]["term"].(map[string]interface{})["email.keyword"].(string)
+	b := second.Query.Bool.Must[0]["term"].(map[string]interface{})["email.keyword"].(string)
+	if a != b {
+		t.Errorf(`Deterministic encryption produced "%s" and "%s"`, a, b)
+	}
+	if strings.Contains(a, "jane") {
+		t.Errorf(`Query value "%s" leaks the plaintext`, a)
+	}
+
+	other := db.Index("accounts").Where("email", "==", "jane@example.com").CompoundBody(10, 0)
+	if other.Query.Bool.Must[0]["match"].(map[string]interface{})["email"] != "jane@example.com" {
+		t.Error(`Query on an index without encryption was modified`)
+	}
+}
+
+type secretUser struct {
+	Name  string `json:"name"`
+	Email string `json:"email" hop:"encrypt,deterministic"`
+	SSN   string `json:"ssn" hop:"encrypt"`
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	provider, _ := docs.NewStaticKeyProvider("k1", bytes.Repeat([]byte{1}, 32))
+	store, db := newIndexStore()
+	db.EncryptFields("users", docs.EncryptionConfig{Provider: provider, Types: []interface{}{secretUser{}}})
+
+	// Tagged fields are known to Where before anything is written.
+	if snapshot := db.Index("users").Where("ssn", "==", "123").Get(); snapshot.Success {
+		t.Errorf(`Query on a randomized encrypted field succeeded`)
+	}
+
+	users := db.Index("users")
+	users.Document("jane").SetData(secretUser{"Jane", "jane@example.com", "123-45"})
+	users.Document("john").SetData(secretUser{"John", "john@example.com", "678-90"})
+	if stored := store.indexes["users"]["jane"].source; strings.Contains(stored["email"].(string), "jane") || strings.Contains(stored["ssn"].(string), "123") {
+		t.Fatalf(`Stored document leaks the plaintext: %v`, stored)
+	}
+
+	var jane secretUser
+	if err := users.Document("jane").Get().Doc.DataTo(&jane); err != nil || jane != (secretUser{"Jane", "jane@example.com", "123-45"}) {
+		t.Errorf(`Unexpected decrypted document %+v: %v`, jane, err)
+	}
+
+	snapshot := db.Index("users").Where("email", "==", "jane@example.com").Get()
+	if !snapshot.Success || len(snapshot.Docs) != 1 || snapshot.Docs[0].Id != "jane" {
+		t.Errorf(`Expected the query to find only jane but got %+v`, snapshot)
+	}
+}
+
+type secretAddress struct {
+	City string `json:"city"`
+	Code string `json:"code" hop:"encrypt"`
+}
+
+type secretOrder struct {
+	Customer  secretUser               `json:"customer"`
+	Addresses []secretAddress          `json:"addresses"`
+	Contacts  map[string]secretAddress `json:"contacts"`
+}
+
+func TestEncryptLearnedAndNestedFields(t *testing.T) {
+	provider, _ := docs.NewStaticKeyProvider("k1", bytes.Repeat([]byte{1}, 32))
+	store, db := newIndexStore()
+	db.EncryptFields("people", docs.EncryptionConfig{Provider: provider, Types: []interface{}{secretUser{}}})
+
+	// Fields tagged in the struct type are encrypted in partial updates and
+	// maps too.
+	people := db.Index("people")
+	people.Document("1").SetData(map[string]interface{}{"name": "Jane", "ssn": "111"})
+	if ssn := store.indexes["people"]["1"].source["ssn"]; strings.Contains(ssn.(string), "111") {
+		t.Errorf(`SetData of a map stored the plaintext %v`, ssn)
+	}
+	if snapshot := people.Document("1").Update([]docs.UpdateData{{Key: "ssn", Value: "333"}}); !snapshot.Success {
+		t.Fatalf(`Update failed: %s`, snapshot.Reason)
+	}
+	if ssn := store.indexes["people"]["1"].source["ssn"]; strings.Contains(ssn.(string), "333") {
+		t.Errorf(`Update stored the plaintext %v`, ssn)
+	}
+	if snapshot := people.Document("1").Get(); snapshot.Doc.Source["ssn"] != "333" {
+		t.Errorf(`Expected the updated value to be decrypted but got %v`, snapshot.Doc.Source)
+	}
+
+	// Tags of nested structs are encrypted at their path.
+	db.EncryptFields("orders", docs.EncryptionConfig{Provider: provider})
+	order := secretOrder{
+		Customer:  secretUser{"Jane", "jane@example.com", "222"},
+		Addresses: []secretAddress{{"Madrid", "28001"}, {"Paris", "75001"}},
+		Contacts:  map[string]secretAddress{"home": {"Rome", "00118"}},
+	}
+	if snapshot := db.Index("orders").Document("1").SetData(order); !snapshot.Success {
+		t.Fatalf(`SetData failed: %s`, snapshot.Reason)
+	}
+	stored, _ := json.Marshal(store.indexes["orders"]["1"].source)
+	for _, plaintext := range []string{"222", "jane@", "28001", "75001", "00118"} {
+		if strings.Contains(string(stored), plaintext) {
+			t.Errorf(`Stored order leaks %s: %s`, plaintext, stored)
+		}
+	}
+	var read secretOrder
+	if err := db.Index("orders").Document("1").Get().Doc.DataTo(&read); err != nil || read.Customer != order.Customer ||
+		read.Addresses[1] != order.Addresses[1] || read.Contacts["home"] != order.Contacts["home"] {
+		t.Errorf(`Unexpected decrypted order %+v: %v`, read, err)
+	}
+}
@@ -0,0 +1,129 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"hopcolony.io/hopcolony/docs"
+	"hopcolony.io/hopcolony/eventstore"
+)
+
+func TestEventStoreAppendRead(t *testing.T) {
+	_, db := newIndexStore()
+	events := eventstore.New(db, "events")
+
+	version, err := events.Append("order-1", eventstore.NoStream,
+		eventstore.Event{Type: "created", Data: map[string]interface{}{"total": 10.0}},
+		eventstore.Event{Type: "paid"})
+	if err != nil || version != 2 {
+		t.Fatalf(`Expected version 2 but got %d: %v`, version, err)
+	}
+	events.Append("order-2", eventstore.NoStream, eventstore.Event{Type: "created"})
+
+	if _, err := events.Append("order-1", 1, eventstore.Event{Type: "cancelled"}); err == nil {
+		t.Fatalf(`Append at a stale version succeeded`)
+	} else if conflict, ok := err.(*eventstore.ConcurrencyError); !ok || conflict.Expected != 1 {
+		t.Fatalf(`Expected a concurrency error but got %v`, err)
+	}
+	if _, err := events.Append("order-1", 5, eventstore.Event{Type: "cancelled"}); err == nil {
+		t.Fatalf(`Append past the end of the stream succeeded`)
+	}
+	if version, err := events.Append("order-1", eventstore.Any, eventstore.Event{Type: "shipped"}); err != nil || version != 3 {
+		t.Fatalf(`Expected Any to append at version 3 but got %d: %v`, version, err)
+	}
+
+	read, err := events.Read("order-1", 2)
+	if err != nil || len(read) != 2 || read[0].Type != "paid" || read[1].Type != "shipped" {
+		t.Fatalf(`Unexpected events %+v: %v`, read, err)
+	}
+	all, _ := events.Read("order-1", 1)
+	var data struct{ Total float64 }
+	if err := all[0].Decode(&data); err != nil || data.Total != 10 || all[0].Stream != "order-1" || all[0].Version != 1 {
+		t.Errorf(`Unexpected first event %+v`, all[0])
+	}
+}
+
+func TestEventStorePartialAppend(t *testing.T) {
+	store := &indexStore{indexes: make(map[string]map[string]*storedDoc)}
+	db := &docs.HopDoc{}
+	events := eventstore.New(db, "events")
+	// Another writer takes version 2 while the batch is being written.
+	db.Use(func(req *docs.Request, next docs.Handler) (*docs.Response, error) {
+		if strings.Contains(req.Path, "order-1:0000000000000000002") && req.Method == "POST" {
+			store.indexes["events"]["order-1:0000000000000000002"] = &storedDoc{source: map[string]interface{}{"stream": "order-1", "version": 2.0}}
+		}
+		return next(req)
+	})
+	db.Use(store.intercept)
+
+	version, err := events.Append("order-1", eventstore.NoStream, eventstore.Event{Type: "created"}, eventstore.Event{Type: "paid"})
+	var partial *eventstore.PartialAppendError
+	if !errors.As(err, &partial) || partial.Written != 1 || version != 1 {
+		t.Fatalf(`Expected a partial append at version 1 but got %d: %v`, version, err)
+	}
+}
+
+func TestEventStoreSubscriptions(t *testing.T) {
+	store, db := newIndexStore()
+	events := eventstore.New(db, "events")
+	events.Append("order-1", eventstore.NoStream, eventstore.Event{Type: "created"}, eventstore.Event{Type: "paid"})
+	events.Append("order-2", eventstore.NoStream, eventstore.Event{Type: "created"})
+
+	totals := eventstore.Projection{Name: "counts", Index: "order_counts",
+		Apply: func(event eventstore.Event, model *docs.IndexReference) error {
+			ref := model.Document(event.Type)
+			count := 0.0
+			if snapshot := ref.Get(); snapshot.Success {
+				count = snapshot.Doc.Source["count"].(float64)
+			}
+			ref.SetData(map[string]interface{}{"count": count + 1})
+			return nil
+		}}
+	if err := events.Rebuild(context.Background(), totals); err != nil {
+		t.Fatalf(`Rebuild failed: %s`, err)
+	}
+	if created := db.Index("order_counts").Document("created").Get(); !created.Success || created.Doc.Source["count"] != 2.0 {
+		t.Fatalf(`Unexpected read model %v`, store.indexes["order_counts"])
+	}
+
+	// Subscriptions resume from their checkpoint.
+	seen := make([]string, 0)
+	stop := errors.New("stop")
+	err := events.Subscribe(context.Background(), "mailer", func(ctx context.Context, event eventstore.Event) error {
+		if len(seen) == 2 {
+			return stop
+		}
+		seen = append(seen, fmt.Sprintf("%s/%d", event.Stream, event.Version))
+		return nil
+	})
+	if err != stop {
+		t.Fatalf(`Expected the subscription to stop but got %v`, err)
+	}
+	events.Append("order-2", 1, eventstore.Event{Type: "paid"})
+	ctx, cancel := context.WithCancel(context.Background())
+	events.Subscribe(ctx, "mailer", func(ctx context.Context, event eventstore.Event) error {
+		seen = append(seen, fmt.Sprintf("%s/%d", event.Stream, event.Version))
+		if len(seen) == 4 {
+			cancel()
+		}
+		return nil
+	})
+	if strings.Join(seen, " ") != "order-1/1 order-1/2 order-2/1 order-2/2" {
+		t.Errorf(`Unexpected deliveries %v`, seen)
+	}
+
+	// The projection continues after the rebuild.
+	ctx, cancel = context.WithCancel(context.Background())
+	projected := totals
+	projected.Apply = func(event eventstore.Event, model *docs.IndexReference) error {
+		defer cancel()
+		return totals.Apply(event, model)
+	}
+	events.Project(ctx, projected)
+	if paid := db.Index("order_counts").Document("paid").Get(); !paid.Success || paid.Doc.Source["count"] != 2.0 {
+		t.Errorf(`Projection did not resume after the rebuild`)
+	}
+}
@@ -0,0 +1,457 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"hopcolony.io/hopcolony/docs"
+)
+
+// indexStore fakes a server with conditional writes and searches that
+// support the queries built by the docs package, sorting, scoring and the
+// _seq_no of the documents. Like the real server, match queries on text
+// fields are analyzed: they match any of the lower-cased words of the value
+// and score by the number of words found.
+type indexStore struct {
+	mu      sync.Mutex
+	indexes map[string]map[string]*storedDoc
+	next    int64
+}
+
+type storedDoc struct {
+	source  map[string]interface{}
+	seqNo   int64
+	version int
+}
+
+func newIndexStore() (*indexStore, *docs.HopDoc) {
+	store := &indexStore{indexes: make(map[string]map[string]*storedDoc)}
+	db := &docs.HopDoc{}
+	db.Use(store.intercept)
+	return store, db
+}
+
+func (s *indexStore) intercept(req *docs.Request, next docs.Handler) (*docs.Response, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	path, query := req.Path, url.Values{}
+	if i := strings.Index(path, "?"); i >= 0 {
+		query, _ = url.ParseQuery(path[i+1:])
+		path = path[:i]
+	}
+	parts := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	index, documents := parts[0], s.indexes[parts[0]]
+
+	if index == "_mget" {
+		var request struct {
+			Docs []struct {
+				Index string `json:"_index"`
+				Id    string `json:"_id"`
+			}
+		}
+		json.Unmarshal(req.Body, &request)
+		found := make([]string, 0, len(request.Docs))
+		for _, ref := range request.Docs {
+			if doc, ok := s.indexes[ref.Index][ref.Id]; ok {
+				found = append(found, strings.TrimSuffix(s.hit(ref.Index, ref.Id, doc), "}")+`,"found":true}`)
+			} else {
+				found = append(found, fmt.Sprintf(`{"_index":%q,"_id":%q,"found":false}`, ref.Index, ref.Id))
+			}
+		}
+		return stub(http.StatusOK, `{"docs":[`+strings.Join(found, ",")+`]}`)(req, next)
+	}
+	if index == "_msearch" {
+		lines := strings.Split(strings.TrimSpace(string(req.Body)), "\n")
+		responses := make([]string, 0, len(lines)/2)
+		for n := 0; n+1 < len(lines); n += 2 {
+			var header struct{ Index string }
+			json.Unmarshal([]byte(lines[n]), &header)
+			if documents, ok := s.indexes[header.Index]; ok {
+				responses = append(responses, s.search(header.Index, documents, []byte(lines[n+1])))
+			} else {
+				responses = append(responses, `{"error":{"type":"index_not_found_exception"},"status":404}`)
+			}
+		}
+		return stub(http.StatusOK, `{"responses":[`+strings.Join(responses, ",")+`]}`)(req, next)
+	}
+	if len(parts) == 1 {
+		switch req.Method {
+		case http.MethodHead:
+			if documents == nil {
+				return stub(http.StatusNotFound, ``)(req, next)
+			}
+		case http.MethodDelete:
+			delete(s.indexes, index)
+		default:
+			s.indexes[index] = make(map[string]*storedDoc)
+		}
+		return stub(http.StatusOK, `{}`)(req, next)
+	}
+	switch parts[1] {
+	case "scroll":
+		return stub(http.StatusOK, `{"hits":{"hits":[]}}`)(req, next)
+	case "_search":
+		if strings.Contains(index, "*") {
+			documents = make(map[string]*storedDoc)
+			for name, indexDocs := range s.indexes {
+				if ok, _ := filepath.Match(index, name); ok {
+					for id, doc := range indexDocs {
+						documents[name+"/"+id] = doc
+					}
+				}
+			}
+		}
+		return stub(http.StatusOK, s.search(index, documents, req.Body))(req, next)
+	case "_refresh", "_mapping":
+		return stub(http.StatusOK, `{}`)(req, next)
+	case "_stats":
+		// Every write is done, so the global checkpoint is the last seq_no.
+		if documents == nil {
+			return stub(http.StatusNotFound, `{}`)(req, next)
+		}
+		return stub(http.StatusOK, fmt.Sprintf(`{"indices":{%q:{"shards":{"0":[
+			{"routing":{"primary":true},"seq_no":{"global_checkpoint":%d}}]}}}}`, index, s.next))(req, next)
+	case "_delete_by_query":
+		var request struct {
+			Query   map[string]interface{}
+			MaxDocs int `json:"max_docs"`
+		}
+		json.Unmarshal(req.Body, &request)
+		ids := make([]string, 0)
+		for id, doc := range documents {
+			if matches(doc, request.Query) && (request.MaxDocs == 0 || len(ids) < request.MaxDocs) {
+				ids = append(ids, id)
+			}
+		}
+		for _, id := range ids {
+			delete(documents, id)
+		}
+		return stub(http.StatusOK, fmt.Sprintf(`{"deleted":%d}`, len(ids)))(req, next)
+	case "_update_by_query":
+		// Scripts are taken to be ctx._source.putAll(params).
+		var request struct {
+			Query  map[string]interface{}
+			Script docs.Script
+		}
+		json.Unmarshal(req.Body, &request)
+		updated := 0
+		for _, doc := range documents {
+			if matches(doc, request.Query) {
+				for key, value := range request.Script.Params {
+					doc.source[key] = value
+				}
+				updated++
+			}
+		}
+		return stub(http.StatusOK, fmt.Sprintf(`{"updated":%d}`, updated))(req, next)
+	case "_count":
+		var request struct{ Query map[string]interface{} }
+		json.Unmarshal(req.Body, &request)
+		count := 0
+		for _, doc := range documents {
+			if matches(doc, request.Query) {
+				count++
+			}
+		}
+		return stub(http.StatusOK, fmt.Sprintf(`{"count":%d}`, count))(req, next)
+	}
+
+	if documents == nil {
+		documents = make(map[string]*storedDoc)
+		s.indexes[index] = documents
+	}
+	s.next++
+	id := fmt.Sprint(s.next)
+	if len(parts) > 2 {
+		id = parts[2]
+	}
+	doc, exists := documents[id]
+	version := 1
+	if exists {
+		version = doc.version + 1
+	}
+	switch {
+	case req.Method == http.MethodGet:
+		if !exists {
+			return stub(http.StatusNotFound, `{"found":false}`)(req, next)
+		}
+		return stub(http.StatusOK, s.hit(index, id, doc))(req, next)
+	case query.Get("op_type") == "create" && exists:
+		return stub(http.StatusConflict, `{}`)(req, next)
+	case query.Get("if_seq_no") != "" && (!exists || query.Get("if_seq_no") != fmt.Sprint(doc.seqNo)):
+		return stub(http.StatusConflict, `{}`)(req, next)
+	case req.Method == http.MethodDelete:
+		if !exists {
+			return stub(http.StatusNotFound, `{}`)(req, next)
+		}
+		delete(documents, id)
+	case len(parts) > 3 && parts[3] == "_update":
+		if !exists {
+			return stub(http.StatusNotFound, `{}`)(req, next)
+		}
+		var update struct{ Doc map[string]interface{} }
+		json.Unmarshal(req.Body, &update)
+		for field, value := range update.Doc {
+			doc.source[field] = value
+		}
+		doc.seqNo, doc.version = s.next, version
+	default:
+		var source map[string]interface{}
+		json.Unmarshal(req.Body, &source)
+		documents[id] = &storedDoc{source, s.next, version}
+	}
+	return stub(http.StatusOK, fmt.Sprintf(`{"_index":%q,"_id":%q,"_seq_no":%d,"_primary_term":1,"_version":%d}`, index, id, s.next, version))(req, next)
+}
+
+func (s *indexStore) hit(index, id string, doc *storedDoc) string {
+	source, _ := json.Marshal(doc.source)
+	return fmt.Sprintf(`{"_index":%q,"_id":%q,"_seq_no":%d,"_primary_term":1,"_version":%d,"_source":%s}`, index, id, doc.seqNo, doc.version, source)
+}
+
+// search runs the query of body on the documents of index. The documents of
+// wildcard indexes are keyed by "<index>/<id>".
+func (s *indexStore) search(index string, documents map[string]*storedDoc, body []byte) string {
+	var request struct {
+		Size  int
+		Query map[string]interface{}
+		Sort  []map[string]map[string]string
+	}
+	json.Unmarshal(body, &request)
+
+	ids := make([]string, 0)
+	for id, doc := range documents {
+		if matches(doc, request.Query) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	scores := make(map[string]float64)
+	for _, id := range ids {
+		scores[id] = relevance(documents[id], request.Query)
+	}
+	sort.SliceStable(ids, func(a, b int) bool { return scores[ids[a]] > scores[ids[b]] })
+	for i := len(request.Sort) - 1; i >= 0; i-- {
+		for field, order := range request.Sort[i] {
+			sort.SliceStable(ids, func(a, b int) bool {
+				less := compare(value(documents[ids[a]], field), value(documents[ids[b]], field)) < 0
+				if order["order"] == "desc" {
+					return compare(value(documents[ids[b]], field), value(documents[ids[a]], field)) < 0
+				}
+				return less
+			})
+		}
+	}
+	if request.Size > 0 && len(ids) > request.Size {
+		ids = ids[:request.Size]
+	}
+
+	hits := make([]string, 0, len(ids))
+	for _, id := range ids {
+		hitIndex, hitId := index, id
+		if strings.Contains(index, "*") {
+			hitIndex, hitId, _ = strings.Cut(id, "/")
+		}
+		hit := s.hit(hitIndex, hitId, documents[id])
+		hits = append(hits, strings.TrimSuffix(hit, "}")+fmt.Sprintf(`,"_score":%g}`, scores[id]))
+	}
+	return `{"_scroll_id":"scroll","hits":{"hits":[` + strings.Join(hits, ",") + `]}}`
+}
+
+// matches evaluates the match, term, prefix, range, exists, bool,
+// script_score and percolate queries of query.
+func matches(doc *storedDoc, query map[string]interface{}) bool {
+	for kind, clause := range query {
+		switch kind {
+		case "bool":
+			for occur, clauses := range clause.(map[string]interface{}) {
+				list, _ := clauses.([]interface{})
+				matched := 0
+				for _, inner := range list {
+					if matches(doc, inner.(map[string]interface{})) {
+						matched++
+					} else if occur != "should" && occur != "must_not" {
+						return false
+					}
+				}
+				if occur == "must_not" && matched > 0 || occur == "should" && len(list) > 0 && matched == 0 {
+					return false
+				}
+			}
+		case "exists":
+			if value(doc, clause.(map[string]interface{})["field"].(string)) == nil {
+				return false
+			}
+		case "match":
+			for field, expected := range clause.(map[string]interface{}) {
+				if found(doc, field, expected) == 0 {
+					return false
+				}
+			}
+		case "term":
+			for field, expected := range clause.(map[string]interface{}) {
+				if fmt.Sprint(value(doc, field)) != fmt.Sprint(expected) {
+					return false
+				}
+			}
+		case "prefix":
+			for field, prefix := range clause.(map[string]interface{}) {
+				if !strings.HasPrefix(fmt.Sprint(value(doc, field)), fmt.Sprint(prefix)) {
+					return false
+				}
+			}
+		case "percolate":
+			document := clause.(map[string]interface{})["document"].(map[string]interface{})
+			saved, _ := doc.source["query"].(map[string]interface{})
+			if saved == nil || !matches(&storedDoc{source: document}, saved) {
+				return false
+			}
+		case "script_score":
+			if !matches(doc, clause.(map[string]interface{})["query"].(map[string]interface{})) {
+				return false
+			}
+		case "range":
+			for field, bounds := range clause.(map[string]interface{}) {
+				actual := value(doc, field)
+				for operator, bound := range bounds.(map[string]interface{}) {
+					c := compare(actual, bound)
+					if operator == "gt" && c <= 0 || operator == "gte" && c < 0 || operator == "lt" && c >= 0 || operator == "lte" && c > 0 {
+						return false
+					}
+				}
+			}
+		}
+	}
+	return true
+}
+
+// relevance scores a document that matches query: the words found by the
+// match queries and the value of the scripts, weighted by their boost, of
+// the clauses that score. Filters score nothing and other clauses 1.
+func relevance(doc *storedDoc, query map[string]interface{}) float64 {
+	total := 0.0
+	for kind, clause := range query {
+		switch kind {
+		case "bool":
+			for occur, clauses := range clause.(map[string]interface{}) {
+				list, _ := clauses.([]interface{})
+				for _, inner := range list {
+					if occur == "must" || occur == "should" && matches(doc, inner.(map[string]interface{})) {
+						total += relevance(doc, inner.(map[string]interface{}))
+					}
+				}
+			}
+		case "match":
+			for field, expected := range clause.(map[string]interface{}) {
+				total += float64(found(doc, field, expected))
+			}
+		case "script_score":
+			scriptScore := clause.(map[string]interface{})
+			boost, ok := scriptScore["boost"].(float64)
+			if !ok {
+				boost = 1
+			}
+			total += boost * score(doc, scriptScore["script"].(map[string]interface{}))
+		default:
+			total++
+		}
+	}
+	return total
+}
+
+// found returns how many words of expected the field of doc has. Keyword
+// fields and values that are not text must be equal.
+func found(doc *storedDoc, field string, expected interface{}) int {
+	actual := value(doc, field)
+	values, ok := actual.([]interface{})
+	if !ok {
+		values = []interface{}{actual}
+	}
+	count := 0
+	for _, actual := range values {
+		text, isText := actual.(string)
+		query, isQuery := expected.(string)
+		if !isText || !isQuery || strings.HasSuffix(field, ".keyword") {
+			if fmt.Sprint(actual) == fmt.Sprint(expected) {
+				count++
+			}
+			continue
+		}
+		words := make(map[string]bool)
+		for _, word := range analyze(text) {
+			words[word] = true
+		}
+		for _, word := range analyze(query) {
+			if words[word] {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+func analyze(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// score evaluates the vector script the way the docs package writes it.
+func score(doc *storedDoc, script map[string]interface{}) float64 {
+	call := regexp.MustCompile(`(\w+)\(params\.vector, '([^']+)'\)`).FindStringSubmatch(script["source"].(string))
+	query, _ := script["params"].(map[string]interface{})["vector"].([]interface{})
+	vector, _ := value(doc, call[2]).([]interface{})
+	var dot, queryNorm, norm, distance float64
+	for idx := 0; idx < len(query) && idx < len(vector); idx++ {
+		q, v := query[idx].(float64), vector[idx].(float64)
+		dot, queryNorm, norm, distance = dot+q*v, queryNorm+q*q, norm+v*v, distance+(q-v)*(q-v)
+	}
+	switch call[1] {
+	case "dotProduct":
+		return 1 / (1 + math.Exp(-dot))
+	case "l2norm":
+		return 1 / (1 + math.Sqrt(distance))
+	}
+	return dot/math.Sqrt(queryNorm*norm) + 1
+}
+
+func value(doc *storedDoc, field string) interface{} {
+	if field == "_seq_no" {
+		return float64(doc.seqNo)
+	}
+	var current interface{} = doc.source
+	for _, key := range strings.Split(strings.TrimSuffix(field, ".keyword"), ".") {
+		object, _ := current.(map[string]interface{})
+		current = object[key]
+	}
+	return current
+}
+
+func compare(a, b interface{}) int {
+	x, xNumber := a.(float64)
+	y, yNumber := b.(float64)
+	if xNumber && yNumber {
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+func (s *indexStore) count(index string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.indexes[index])
+}
@@ -0,0 +1,101 @@
+package test
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+
+	"hopcolony.io/hopcolony/docs"
+)
+
+func TestGeoPointSerialization(t *testing.T) {
+	b, err := json.Marshal(docs.GeoPoint{Lat: 40.4, Lon: -3.7})
+	if err != nil {
+		t.Fatalf(`Could not marshal geo point: %v`, err)
+	}
+	if string(b) != `{"lat":40.4,"lon":-3.7}` {
+		t.Errorf(`Unexpected geo point JSON "%s"`, b)
+	}
+
+	for _, raw := range []string{`{"lat":40.4,"lon":-3.7}`, `"40.4,-3.7"`, `[-3.7,40.4]`} {
+		var point docs.GeoPoint
+		if err := json.Unmarshal([]byte(raw), &point); err != nil {
+			t.Errorf(`Could not unmarshal "%s": %v`, raw, err)
+		}
+		if point.Lat != 40.4 || point.Lon != -3.7 {
+			t.Errorf(`Expected 40.4,-3.7 from "%s" but got %v`, raw, point)
+		}
+	}
+
+	var point docs.GeoPoint
+	if err := json.Unmarshal([]byte(`"ezjmgtws"`), &point); err != nil {
+		t.Errorf(`Could not unmarshal geohash: %v`, err)
+	}
+	if math.Abs(point.Lat-40.4168) > 0.001 || math.Abs(point.Lon+3.7038) > 0.001 {
+		t.Errorf(`Unexpected geohash center %v`, point)
+	}
+
+	if err := json.Unmarshal([]byte(`{"lat":1}`), &point); err != docs.InvalidGeoPoint {
+		t.Errorf(`Expected InvalidGeoPoint but got %v`, err)
+	}
+
+	var shop struct {
+		Location *docs.GeoPoint `json:"location"`
+	}
+	if err := json.Unmarshal([]byte(`{"location":null}`), &shop); err != nil || shop.Location != nil {
+		t.Errorf(`Expected a null location to be nil but got %v: %v`, shop.Location, err)
+	}
+}
+
+func TestGeoPointDataTo(t *testing.T) {
+	for _, location := range []interface{}{
+		map[string]interface{}{"lat": 40.4, "lon": -3.7}, "40.4,-3.7", []interface{}{-3.7, 40.4},
+	} {
+		var shop struct {
+			Location docs.GeoPoint  `mapstructure:"location"`
+			Previous *docs.GeoPoint `mapstructure:"previous"`
+		}
+		doc := &docs.Document{Source: map[string]interface{}{"location": location, "previous": location}}
+		if err := doc.DataTo(&shop); err != nil {
+			t.Errorf(`Could not decode %v: %v`, location, err)
+			continue
+		}
+		if shop.Location.Lat != 40.4 || shop.Location.Lon != -3.7 || shop.Previous == nil || *shop.Previous != shop.Location {
+			t.Errorf(`Expected 40.4,-3.7 from %v but got %v and %v`, location, shop.Location, shop.Previous)
+		}
+	}
+
+	var shop struct {
+		Location docs.GeoPoint `mapstructure:"location"`
+	}
+	doc := &docs.Document{Source: map[string]interface{}{"location": "ezjmgtws"}}
+	if err := doc.DataTo(&shop); err != nil || math.Abs(shop.Location.Lat-40.4168) > 0.001 {
+		t.Errorf(`Could not decode geohash: %v %v`, shop.Location, err)
+	}
+}
+
+func TestGeoCompoundBody(t *testing.T) {
+	origin := docs.GeoPoint{Lat: 40.4, Lon: -3.7}
+	index := (&docs.HopDoc{}).Index("shops").
+		Where("open", "==", true).
+		WhereGeoDistance("location", origin, "5km").
+		OrderByDistance("location", origin, "km")
+
+	body := index.CompoundBody(10, 0)
+	if len(body.Query.Bool.Must) != 1 || len(body.Query.Bool.Filter) != 1 {
+		t.Fatalf(`Expected 1 must and 1 filter clause but got %d and %d`,
+			len(body.Query.Bool.Must), len(body.Query.Bool.Filter))
+	}
+
+	distance := body.Query.Bool.Filter[0][docs.GeoDistanceOperator].(map[string]interface{})
+	if distance["distance"] != "5km" || distance["location"] != origin {
+		t.Errorf(`Unexpected geo_distance filter "%v"`, distance)
+	}
+
+	if len(body.Sort) != 1 {
+		t.Fatalf(`Expected 1 sort clause but got %d`, len(body.Sort))
+	}
+	if _, ok := body.Sort[0]["_geo_distance"]; !ok {
+		t.Errorf(`Expected a _geo_distance sort but got "%v"`, body.Sort[0])
+	}
+}
@@ -0,0 +1,93 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	"hopcolony.io/hopcolony/docs"
+)
+
+func TestSoftDeleteHistory(t *testing.T) {
+	store, db := newIndexStore()
+	db.Audit("accounts", docs.AuditConfig{SoftDelete: true, History: true})
+	ctx := docs.WithActor(context.Background(), "alice")
+	ref := db.Index("accounts").WithContext(ctx).Document("1")
+
+	ref.SetData(map[string]interface{}{"balance": 10})
+	ref.SetData(map[string]interface{}{"balance": 20})
+	ref.Update([]docs.UpdateData{{Key: "balance", Value: 30}})
+	if snapshot := ref.Delete(); !snapshot.Success {
+		t.Fatalf(`Delete failed: %s`, snapshot.Reason)
+	}
+
+	if _, kept := store.indexes["accounts"]["1"]; !kept {
+		t.Fatalf(`Soft deleted document was removed`)
+	}
+	if snapshot := ref.Get(); snapshot.Success {
+		t.Errorf(`Soft deleted document was read`)
+	}
+	if snapshot := db.Index("accounts").Get(); len(snapshot.Docs) != 0 {
+		t.Errorf(`Soft deleted document was listed`)
+	}
+	deleted := db.Index("accounts").WithDeleted().Get()
+	if len(deleted.Docs) != 1 || deleted.Docs[0].Source[docs.DeletedField] == nil {
+		t.Errorf(`Expected WithDeleted to list the deleted document`)
+	}
+
+	revisions, err := ref.History()
+	if err != nil || len(revisions) != 3 {
+		t.Fatalf(`Expected 3 revisions but got %+v: %v`, revisions, err)
+	}
+	for i, operation := range []string{docs.DeleteOp, docs.UpdateOp, docs.SetOp} {
+		if revisions[i].Operation != operation || revisions[i].Version != 3-i || revisions[i].Actor != "alice" {
+			t.Errorf(`Unexpected revision %+v`, revisions[i])
+		}
+	}
+	if revisions[2].Source["balance"] != 10.0 {
+		t.Errorf(`Expected the first version to have balance 10 but got %v`, revisions[2].Source)
+	}
+
+	if snapshot := ref.Restore(1); !snapshot.Success {
+		t.Fatalf(`Restore failed: %s`, snapshot.Reason)
+	}
+	restored := ref.Get()
+	if !restored.Success || restored.Doc.Source["balance"] != 10.0 {
+		t.Fatalf(`Expected the first version to be restored but got %+v`, restored)
+	}
+	if revisions, _ := ref.History(); len(revisions) != 4 {
+		t.Errorf(`Expected the restore to be recorded but got %d revisions`, len(revisions))
+	}
+}
+
+func TestHistoryHardDelete(t *testing.T) {
+	store, db := newIndexStore()
+	db.Audit("notes", docs.AuditConfig{History: true})
+	ref := db.Index("notes").Document("n")
+	ref.SetData(map[string]interface{}{"text": "hello"})
+	if snapshot := ref.Delete(); !snapshot.Success {
+		t.Fatalf(`Delete failed: %s`, snapshot.Reason)
+	}
+	if store.count("notes") != 0 {
+		t.Errorf(`Document was not deleted`)
+	}
+	revisions, err := ref.History()
+	if err != nil || len(revisions) != 1 || revisions[0].Operation != docs.DeleteOp || revisions[0].Source["text"] != "hello" {
+		t.Fatalf(`Expected the deleted version in the history but got %+v: %v`, revisions, err)
+	}
+	if snapshot := ref.Restore(1); !snapshot.Success || ref.Get().Doc.Source["text"] != "hello" {
+		t.Errorf(`Deleted document was not restored`)
+	}
+}
+
+func TestSoftDeleteKeepsUserFields(t *testing.T) {
+	_, db := newIndexStore()
+	db.Audit("accounts", docs.AuditConfig{SoftDelete: true})
+	accounts := db.Index("accounts")
+	accounts.Document("1").SetData(map[string]interface{}{"deletedAt": "2021-01-01T00:00:00Z"})
+	accounts.Document("2").SetData(map[string]interface{}{"deletedAt": nil, docs.DeletedField: nil})
+	for _, id := range []string{"1", "2"} {
+		if snapshot := accounts.Document(id).Get(); !snapshot.Success {
+			t.Errorf(`Expected live document %s to be read but got %s`, id, snapshot.Reason)
+		}
+	}
+}
@@ -1,7 +1,11 @@
 package test
 
 import (
+	"fmt"
+	"io/ioutil"
+	"os"
 	"testing"
+	"time"
 
 	"hopcolony.io/hopcolony/initialize"
 )
@@ -43,3 +47,49 @@ func TestInitInitialize(t *testing.T) {
 		t.Errorf(`Expected token to be "token" but got "%s"`, project.Config.Token)
 	}
 }
+
+func TestInitCredentials(t *testing.T) {
+	_, err := initialize.Initialize(initialize.ProjectConfig{Username: "username", Project: "project",
+		Credentials: initialize.StaticCredentials("token")})
+	if err != nil {
+		t.Errorf("Error in project creation with credentials: %s", err)
+	}
+
+	os.Setenv("HOP_TEST_TOKEN", "env-token")
+	defer os.Unsetenv("HOP_TEST_TOKEN")
+	token, err := initialize.EnvCredentials("HOP_TEST_TOKEN").Token()
+	if err != nil || token != "env-token" {
+		t.Errorf(`Expected token to be "env-token" but got "%s": %v`, token, err)
+	}
+
+	file, err := ioutil.TempFile("", "hop-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("token: first\n")
+	file.Close()
+
+	provider := initialize.FileCredentials(file.Name())
+	if token, _ := provider.Token(); token != "first" {
+		t.Errorf(`Expected token to be "first" but got "%s"`, token)
+	}
+	ioutil.WriteFile(file.Name(), []byte("second"), 0600)
+	os.Chtimes(file.Name(), time.Now(), time.Now().Add(time.Second))
+	if token, _ := provider.Token(); token != "second" {
+		t.Errorf(`Expected reloaded token to be "second" but got "%s"`, token)
+	}
+
+	calls := 0
+	refreshing := initialize.RefreshingCredentials(func() (initialize.Credentials, error) {
+		calls++
+		return initialize.Credentials{Token: fmt.Sprintf("token-%d", calls), Expiry: time.Now().Add(time.Minute)}, nil
+	}, 30*time.Second)
+	refreshing.Token()
+	if token, _ := refreshing.Token(); token != "token-1" {
+		t.Errorf(`Expected cached token to be "token-1" but got "%s"`, token)
+	}
+	if token, _ := refreshing.Refresh(); token != "token-2" {
+		t.Errorf(`Expected refreshed token to be "token-2" but got "%s"`, token)
+	}
+}
@@ -0,0 +1,136 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"hopcolony.io/hopcolony/docs"
+	"hopcolony.io/hopcolony/lock"
+)
+
+// lockStore fakes conditional writes on the locks index.
+type lockStore struct {
+	mu     sync.Mutex
+	source map[string]string
+	seqNo  map[string]int64
+	next   int64
+}
+
+func (s *lockStore) intercept(req *docs.Request, next docs.Handler) (*docs.Response, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	path, query := req.Path, url.Values{}
+	if i := strings.Index(path, "?"); i >= 0 {
+		query, _ = url.ParseQuery(path[i+1:])
+		path = path[:i]
+	}
+	id := strings.TrimPrefix(path, "/"+lock.Index+"/_doc/")
+	source, exists := s.source[id]
+
+	if req.Method == http.MethodGet {
+		if !exists {
+			return stub(http.StatusNotFound, `{}`)(req, next)
+		}
+		return stub(http.StatusOK, fmt.Sprintf(`{"_id":%q,"_seq_no":%d,"_primary_term":1,"_source":%s}`, id, s.seqNo[id], source))(req, next)
+	}
+	if query.Get("op_type") == "create" && exists {
+		return stub(http.StatusConflict, `{}`)(req, next)
+	}
+	if seqNo := query.Get("if_seq_no"); seqNo != "" && (!exists || seqNo != fmt.Sprint(s.seqNo[id])) {
+		return stub(http.StatusConflict, `{}`)(req, next)
+	}
+	s.next++
+	s.source[id] = string(req.Body)
+	s.seqNo[id] = s.next
+	return stub(http.StatusOK, fmt.Sprintf(`{"_id":%q,"_seq_no":%d,"_primary_term":1}`, id, s.next))(req, next)
+}
+
+// steal overwrites the lock as if another worker took it over.
+func (s *lockStore) steal(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.next++
+	s.source[id] = `{"owner":"thief","token":99,"expiresAt":"2999-01-01T00:00:00Z"}`
+	s.seqNo[id] = s.next
+}
+
+func newLockStore() (*lockStore, *docs.HopDoc) {
+	store := &lockStore{source: make(map[string]string), seqNo: make(map[string]int64)}
+	db := &docs.HopDoc{}
+	db.Use(store.intercept)
+	return store, db
+}
+
+func TestLockAcquireRelease(t *testing.T) {
+	_, db := newLockStore()
+	a := lock.New(db)
+	a.Owner = "a"
+	b := lock.New(db)
+	b.Owner = "b"
+
+	lease, err := a.Acquire(context.Background(), "report")
+	if err != nil {
+		t.Fatalf(`Acquire failed: %s`, err)
+	}
+	if _, err := b.Acquire(context.Background(), "report"); err == nil {
+		t.Fatalf(`Lock was acquired twice`)
+	} else if held, ok := err.(*lock.HeldError); !ok || held.Owner != "a" {
+		t.Fatalf(`Expected the lock to be held by a but got %v`, err)
+	}
+
+	if err := lease.Release(); err != nil {
+		t.Fatalf(`Release failed: %s`, err)
+	}
+	if lease.Context().Err() == nil {
+		t.Errorf(`Lease context was not cancelled on release`)
+	}
+	next, err := b.Acquire(context.Background(), "report")
+	if err != nil {
+		t.Fatalf(`Acquire after release failed: %s`, err)
+	}
+	defer next.Release()
+	if next.Token <= lease.Token {
+		t.Errorf(`Expected the fencing token to grow but got %d after %d`, next.Token, lease.Token)
+	}
+}
+
+func TestLockExpiry(t *testing.T) {
+	store, db := newLockStore()
+	store.source["job"] = `{"owner":"dead","token":4,"expiresAt":"2001-01-01T00:00:00Z"}`
+	store.seqNo["job"] = 1
+	store.next = 1
+
+	locker := lock.New(db)
+	locker.TTL = 30 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	lease, err := locker.Acquire(ctx, "job")
+	cancel()
+	if err != nil {
+		t.Fatalf(`Expected the expired lease to be taken over but got %s`, err)
+	}
+	if lease.Token != 5 {
+		t.Errorf(`Expected token 5 but got %d`, lease.Token)
+	}
+
+	// Heartbeats keep the lease alive past its TTL and the acquire context.
+	time.Sleep(100 * time.Millisecond)
+	if lease.Err() != nil || lease.Context().Err() != nil {
+		t.Fatalf(`Lease was lost while renewing: %s`, lease.Err())
+	}
+
+	store.steal("job")
+	select {
+	case <-lease.Context().Done():
+	case <-time.After(time.Second):
+		t.Fatalf(`Lease context was not cancelled when the lease was lost`)
+	}
+	if lease.Err() != lock.LeaseLost || lease.Release() != lock.LeaseLost {
+		t.Errorf(`Expected LeaseLost but got %v`, lease.Err())
+	}
+}
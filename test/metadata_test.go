@@ -0,0 +1,118 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"hopcolony.io/hopcolony/docs"
+)
+
+type Article struct {
+	Id        string    `json:"id" hop:"id"`
+	Version   int       `json:"version" hop:"version"`
+	Title     string    `json:"title"`
+	CreatedAt time.Time `json:"createdAt" hop:"createdAt,auto"`
+	UpdatedAt time.Time `json:"updatedAt" hop:"updatedAt,auto"`
+}
+
+func TestMetadataWrite(t *testing.T) {
+	var paths []string
+	var source map[string]interface{}
+	db := &docs.HopDoc{}
+	db.Use(func(req *docs.Request, next docs.Handler) (*docs.Response, error) {
+		paths = append(paths, req.Path)
+		json.Unmarshal(req.Body, &source)
+		return stub(http.StatusCreated, `{"_index":"articles","_id":"generated","_version":3}`)(req, next)
+	})
+
+	article := &Article{Title: "Hello"}
+	if snapshot := db.Index("articles").Add(article); !snapshot.Success {
+		t.Fatalf(`Add failed: %s`, snapshot.Reason)
+	}
+	if paths[0] != "/articles/_doc" {
+		t.Errorf(`Expected an id to be generated but got %s`, paths[0])
+	}
+	if article.Id != "generated" || article.Version != 3 {
+		t.Errorf(`Metadata was not written back: %+v`, article)
+	}
+	if _, ok := source["id"]; ok {
+		t.Errorf(`Metadata field was written to the source: %v`, source)
+	}
+	if article.CreatedAt.IsZero() || source["createdAt"] != article.CreatedAt.Format(time.RFC3339Nano) {
+		t.Errorf(`createdAt was not stamped: %v`, source)
+	}
+
+	created := article.CreatedAt
+	time.Sleep(time.Millisecond)
+	article.Id = "hello"
+	db.Index("articles").Add(article)
+	if paths[1] != "/articles/_doc/hello" {
+		t.Errorf(`Expected the tagged id to be used but got %s`, paths[1])
+	}
+	if !article.CreatedAt.Equal(created) || !article.UpdatedAt.After(created) {
+		t.Errorf(`Expected only updatedAt to change: %+v`, article)
+	}
+
+	db.Index("articles").Document("").SetData(Article{Id: "other", Title: "Other"})
+	if paths[2] != "/articles/_doc/other" {
+		t.Errorf(`Expected SetData to use the tagged id but got %s`, paths[2])
+	}
+
+	// Partial updates stamp the updatedAt fields of the structs written to
+	// the index, unless they set them themselves.
+	db.Index("articles").Document("hello").Update([]docs.UpdateData{{Key: "title", Value: "Bye"}})
+	doc, _ := source["doc"].(map[string]interface{})
+	updatedAt, err := time.Parse(time.RFC3339Nano, fmt.Sprint(doc["updatedAt"]))
+	if err != nil || updatedAt.Before(article.UpdatedAt) || doc["createdAt"] != nil {
+		t.Errorf(`Expected only updatedAt to be stamped but got %v`, source)
+	}
+	db.Index("articles").Document("hello").Update([]docs.UpdateData{{Key: "updatedAt", Value: "2001-01-01T00:00:00Z"}})
+	if doc, _ := source["doc"].(map[string]interface{}); doc["updatedAt"] != "2001-01-01T00:00:00Z" {
+		t.Errorf(`Expected the given updatedAt to be kept but got %v`, source)
+	}
+}
+
+func TestMetadataRead(t *testing.T) {
+	db := &docs.HopDoc{}
+	db.Use(stub(http.StatusOK, `{"_index":"articles","_id":"hello","_version":7,
+		"_source":{"title":"Hello","createdAt":"2021-03-04T05:06:07.5Z"}}`))
+
+	snapshot := db.Index("articles").Document("hello").Get()
+	var article Article
+	if err := snapshot.Doc.DataTo(&article); err != nil {
+		t.Fatalf(`DataTo failed: %s`, err)
+	}
+	if article.Id != "hello" || article.Version != 7 || article.Title != "Hello" {
+		t.Errorf(`Unexpected article %+v`, article)
+	}
+	if !article.CreatedAt.Equal(time.Date(2021, 3, 4, 5, 6, 7, 5e8, time.UTC)) {
+		t.Errorf(`Unexpected createdAt %s`, article.CreatedAt)
+	}
+}
+
+type hiddenIdArticle struct {
+	ID      string `json:"-" hop:"id"`
+	Version int    `json:"-" hop:"version"`
+	Title   string `json:"title"`
+}
+
+func TestMetadataIgnoredByJSON(t *testing.T) {
+	store, db := newIndexStore()
+	if snapshot := db.Index("articles").Add(&hiddenIdArticle{ID: "hello", Title: "Hello"}); !snapshot.Success {
+		t.Fatalf(`Add failed: %s`, snapshot.Reason)
+	}
+	if _, ok := store.indexes["articles"]["hello"]; !ok {
+		t.Fatalf(`Expected the tagged id to be used but got %v`, store.indexes["articles"])
+	}
+
+	var article hiddenIdArticle
+	if err := db.Index("articles").Document("hello").Get().Doc.DataTo(&article); err != nil {
+		t.Fatalf(`DataTo failed: %s`, err)
+	}
+	if article.ID != "hello" || article.Version != 1 || article.Title != "Hello" {
+		t.Errorf(`Unexpected article %+v`, article)
+	}
+}
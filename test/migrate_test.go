@@ -0,0 +1,149 @@
+package test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"hopcolony.io/hopcolony/docs"
+)
+
+type migrationServer struct {
+	requests []string
+	bulk     []string
+	polls    int
+	failBulk bool
+	// seqNo is the max_seq_no reported for the target.
+	seqNo int
+}
+
+func (s *migrationServer) intercept(req *docs.Request, next docs.Handler) (*docs.Response, error) {
+	s.requests = append(s.requests, req.Method+" "+req.Path)
+	switch {
+	case req.Path == "/_alias/orders":
+		return stub(http.StatusOK, `{"orders_v1":{"aliases":{"orders":{}}}}`)(req, next)
+	case req.Path == "/_reindex?wait_for_completion=false":
+		return stub(http.StatusOK, `{"task":"node:1"}`)(req, next)
+	case req.Path == "/_tasks/node:1":
+		s.polls++
+		if s.polls < 2 {
+			return stub(http.StatusOK, `{"completed":false,"task":{"status":{"total":2,"created":1}}}`)(req, next)
+		}
+		return stub(http.StatusOK, `{"completed":true,"task":{"status":{"total":2,"created":2}},"response":{"failures":[]}}`)(req, next)
+	case req.Path == "/orders_v1/_count":
+		return stub(http.StatusOK, `{"count":2}`)(req, next)
+	case strings.HasPrefix(req.Path, "/orders_v1/_search"):
+		return stub(http.StatusOK, `{"_scroll_id":"s","hits":{"hits":[
+			{"_id":"a","_source":{"total":"10"}},{"_id":"b","_source":{"total":"bad"}}]}}`)(req, next)
+	case req.Path == "/_search/scroll":
+		return stub(http.StatusOK, `{"_scroll_id":"s","hits":{"hits":[]}}`)(req, next)
+	case req.Path == "/orders_v2/_stats?level=shards":
+		return stub(http.StatusOK, fmt.Sprintf(`{"indices":{"orders_v2":{"shards":{"0":[
+			{"routing":{"primary":true},"seq_no":{"max_seq_no":%d}},{"routing":{"primary":false},"seq_no":{"max_seq_no":%d}}]}}}}`, s.seqNo, s.seqNo))(req, next)
+	case req.Path == "/_bulk":
+		scanner := bufio.NewScanner(bytes.NewReader(req.Body))
+		for scanner.Scan() {
+			s.bulk = append(s.bulk, scanner.Text())
+		}
+		if s.failBulk {
+			return stub(http.StatusOK, `{"errors":true,"items":[{"index":{"_id":"a","status":400,"error":{"reason":"mapper_parsing_exception"}}}]}`)(req, next)
+		}
+		return stub(http.StatusOK, `{"errors":false,"items":[{"index":{"_id":"a","status":201}}]}`)(req, next)
+	}
+	return stub(http.StatusOK, `{}`)(req, next)
+}
+
+func TestMigrateReindex(t *testing.T) {
+	server := &migrationServer{}
+	db := &docs.HopDoc{}
+	db.Use(server.intercept)
+
+	var progress []int
+	migration, err := db.Migrate(docs.MigrationConfig{
+		Alias:        "orders",
+		Target:       "orders_v2",
+		Mapping:      docs.Mapping{"total": {Type: docs.LongType}},
+		Progress:     func(copied, total int) { progress = append(progress, copied) },
+		PollInterval: 1,
+	})
+	if err != nil {
+		t.Fatalf(`Migrate failed: %s`, err)
+	}
+	if migration.Source != "orders_v1" || migration.Copied != 2 || len(progress) != 2 {
+		t.Errorf(`Unexpected migration %+v with progress %v`, migration, progress)
+	}
+	// Writes to the source are blocked from before the copy until the alias
+	// moves.
+	requests := strings.Join(server.requests, ",")
+	if !strings.Contains(requests, "PUT /orders_v1/_settings,POST /_reindex") || !strings.HasSuffix(requests, "POST /_aliases,PUT /orders_v1/_settings") {
+		t.Errorf(`Unexpected migration requests %v`, server.requests)
+	}
+
+	// Once the target is written to, rolling back would lose the writes.
+	server.seqNo++
+	if err := migration.Rollback(); err != docs.MigrationDiverged {
+		t.Fatalf(`Expected the rollback of a diverged target to fail but got %v`, err)
+	}
+	server.seqNo--
+	server.requests = nil
+	if err := migration.Rollback(); err != nil {
+		t.Fatalf(`Rollback failed: %s`, err)
+	}
+	if strings.Join(server.requests, ",") != "PUT /orders_v2/_settings,GET /orders_v2/_stats?level=shards,POST /_aliases,DELETE /orders_v2" {
+		t.Errorf(`Unexpected rollback requests %v`, server.requests)
+	}
+}
+
+func TestMigrateTransform(t *testing.T) {
+	server := &migrationServer{}
+	db := &docs.HopDoc{}
+	db.Use(server.intercept)
+
+	_, err := db.Migrate(docs.MigrationConfig{
+		Alias:  "orders",
+		Target: "orders_v2",
+		Transform: func(doc *docs.Document) (*docs.Document, error) {
+			if doc.Source["total"] == "bad" {
+				return nil, nil
+			}
+			doc.Source["currency"] = "EUR"
+			return doc, nil
+		},
+	})
+	if err != nil {
+		t.Fatalf(`Migrate failed: %s`, err)
+	}
+	if len(server.bulk) != 2 || !strings.Contains(server.bulk[0], `"_index":"orders_v2"`) {
+		t.Fatalf(`Unexpected bulk body %v`, server.bulk)
+	}
+	var source map[string]interface{}
+	json.Unmarshal([]byte(server.bulk[1]), &source)
+	if source["currency"] != "EUR" {
+		t.Errorf(`Transform was not applied: %v`, source)
+	}
+
+	server = &migrationServer{failBulk: true}
+	db = &docs.HopDoc{}
+	db.Use(server.intercept)
+	_, err = db.Migrate(docs.MigrationConfig{Alias: "orders", Target: "orders_v2", Transform: func(doc *docs.Document) (*docs.Document, error) {
+		return doc, nil
+	}})
+	if _, ok := err.(*docs.BulkError); !ok {
+		t.Fatalf(`Expected a bulk error but got %v`, err)
+	}
+	for _, request := range server.requests {
+		if request == "POST /_aliases" {
+			t.Errorf(`Alias was swapped after a failed copy`)
+		}
+	}
+	if last := server.requests[len(server.requests)-2]; last != "PUT /orders_v1/_settings" {
+		t.Errorf(`Expected the source to be writable again but got %s`, last)
+	}
+	if last := server.requests[len(server.requests)-1]; last != "DELETE /orders_v2" {
+		t.Errorf(`Expected the target to be deleted but got %s`, last)
+	}
+}
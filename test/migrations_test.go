@@ -0,0 +1,190 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"hopcolony.io/hopcolony/docs"
+	"hopcolony.io/hopcolony/lock"
+	"hopcolony.io/hopcolony/migrations"
+)
+
+// recordStore fakes the migrations index and records the scripts run by
+// update by query. Locks are kept in an indexStore.
+type recordStore struct {
+	docs    map[string]string
+	scripts []string
+	locks   *indexStore
+}
+
+func newRecordStore() (*recordStore, *docs.HopDoc) {
+	store := &recordStore{docs: make(map[string]string), locks: &indexStore{indexes: make(map[string]map[string]*storedDoc)}}
+	db := &docs.HopDoc{}
+	db.Use(store.intercept, store.locks.intercept)
+	return store, db
+}
+
+func (s *recordStore) intercept(req *docs.Request, next docs.Handler) (*docs.Response, error) {
+	prefix := "/" + migrations.Index
+	path := strings.SplitN(req.Path, "?", 2)[0]
+	switch {
+	case strings.HasPrefix(path, "/"+lock.Index):
+		return next(req)
+	case req.Method == http.MethodHead:
+		if len(s.docs) == 0 {
+			return stub(http.StatusNotFound, ``)(req, next)
+		}
+	case strings.HasSuffix(path, "/_update_by_query"):
+		var body struct{ Script docs.Script }
+		json.Unmarshal(req.Body, &body)
+		s.scripts = append(s.scripts, fmt.Sprint(body.Script.Params))
+		return stub(http.StatusOK, `{"updated":1}`)(req, next)
+	case path == prefix+"/_search":
+		hits := make([]string, 0)
+		for id, source := range s.docs {
+			hits = append(hits, fmt.Sprintf(`{"_id":%q,"_source":%s}`, id, source))
+		}
+		return stub(http.StatusOK, `{"_scroll_id":"s","hits":{"hits":[`+strings.Join(hits, ",")+`]}}`)(req, next)
+	case path == "/_search/scroll":
+		return stub(http.StatusOK, `{"hits":{"hits":[]}}`)(req, next)
+	case strings.HasPrefix(path, prefix+"/_doc/"):
+		id := strings.TrimPrefix(path, prefix+"/_doc/")
+		source, exists := s.docs[id]
+		switch req.Method {
+		case http.MethodGet:
+			if !exists {
+				return stub(http.StatusNotFound, `{}`)(req, next)
+			}
+			return stub(http.StatusOK, fmt.Sprintf(`{"_id":%q,"_source":%s}`, id, source))(req, next)
+		case http.MethodDelete:
+			if !exists {
+				return stub(http.StatusNotFound, `{}`)(req, next)
+			}
+			delete(s.docs, id)
+		default:
+			if exists && strings.Contains(req.Path, "op_type=create") {
+				return stub(http.StatusConflict, `{}`)(req, next)
+			}
+			s.docs[id] = string(req.Body)
+		}
+		return stub(http.StatusOK, fmt.Sprintf(`{"_id":%q,"_version":1}`, id))(req, next)
+	}
+	return stub(http.StatusOK, `{}`)(req, next)
+}
+
+func TestMigrationsUpDown(t *testing.T) {
+	store, db := newRecordStore()
+
+	migrator := migrations.New(db).
+		Register(2, "rename total", migrations.RenameField("orders", "total", "amount")).
+		Register(1, "default status", migrations.AddFieldDefault("orders", "status", "open"))
+
+	n, err := migrator.Up()
+	if err != nil || n != 2 {
+		t.Fatalf(`Expected 2 migrations to run but got %d: %v`, n, err)
+	}
+	if strings.Join(store.scripts, " ") != "map[field:status value:open] map[from:total to:amount]" {
+		t.Errorf(`Migrations ran out of order: %v`, store.scripts)
+	}
+	if lease, err := lock.New(db).Acquire(context.Background(), migrations.LockName); err != nil {
+		t.Errorf(`Lock was not released: %v`, err)
+	} else {
+		lease.Release()
+	}
+
+	records, err := migrations.Applied(db)
+	if err != nil || len(records) != 2 || records[1].Name != "rename total" {
+		t.Fatalf(`Unexpected records %+v: %v`, records, err)
+	}
+	if n, _ := migrator.Up(); n != 0 {
+		t.Errorf(`Expected applied migrations to be skipped but %d ran`, n)
+	}
+
+	store.scripts = nil
+	if n, err := migrator.Down(1); err != nil || n != 1 {
+		t.Fatalf(`Expected 1 migration to be reverted but got %d: %v`, n, err)
+	}
+	if len(store.scripts) != 1 || store.scripts[0] != "map[from:amount to:total]" {
+		t.Errorf(`Unexpected down scripts %v`, store.scripts)
+	}
+	if _, ok := store.docs["2"]; ok {
+		t.Errorf(`Record of the reverted migration was kept`)
+	}
+
+	// Reverting a default leaves the documents alone.
+	store.scripts = nil
+	if n, err := migrator.Down(1); err != nil || n != 1 || len(store.scripts) != 0 {
+		t.Errorf(`Expected the default to be reverted without scripts but got %d, %v: %v`, n, store.scripts, err)
+	}
+}
+
+func TestMigrationsLock(t *testing.T) {
+	store, db := newRecordStore()
+	other := lock.New(db)
+	other.Owner = "other"
+	lease, err := other.Acquire(context.Background(), migrations.LockName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lease.Release()
+
+	migrator := migrations.New(db).Register(1, "default status", migrations.AddFieldDefault("orders", "status", "open"))
+	_, err = migrator.Up()
+	if locked, ok := err.(*migrations.LockedError); !ok || locked.Owner != "other" {
+		t.Fatalf(`Expected a lock error but got %v`, err)
+	}
+	if len(store.scripts) != 0 {
+		t.Errorf(`Migrations ran without the lock`)
+	}
+
+	if err := migrations.Unlock(db); err != nil {
+		t.Fatalf(`Unlock failed: %s`, err)
+	}
+	if n, err := migrator.Up(); err != nil || n != 1 {
+		t.Errorf(`Expected 1 migration to run after unlocking but got %d: %v`, n, err)
+	}
+	// Unlocking keeps the fencing token.
+	if next, err := lock.New(db).Acquire(context.Background(), migrations.LockName); err != nil || next.Token != 3 {
+		t.Errorf(`Expected the third lease on the lock but got %+v: %v`, next, err)
+	} else {
+		next.Release()
+	}
+
+	// The lease of a runner that died expires.
+	db.Index(lock.Index).Document(migrations.LockName).SetData(map[string]interface{}{
+		"owner": "dead", "token": 5, "expiresAt": time.Now().Add(-time.Second)})
+	if _, err := migrator.Down(1); err != nil {
+		t.Errorf(`Expected the expired lock to be taken over but got %v`, err)
+	}
+}
+
+func TestMigrationsOrder(t *testing.T) {
+	_, db := newRecordStore()
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf(`Expected registering a version twice to panic`)
+			}
+		}()
+		migrations.New(db).
+			Register(1, "default status", migrations.AddFieldDefault("orders", "status", "open")).
+			Register(1, "rename total", migrations.RenameField("orders", "total", "amount"))
+	}()
+
+	if n, err := migrations.New(db).Register(2, "rename total", migrations.RenameField("orders", "total", "amount")).Up(); err != nil || n != 1 {
+		t.Fatalf(`Expected 1 migration to run but got %d: %v`, n, err)
+	}
+	migrator := migrations.New(db).
+		Register(1, "default status", migrations.AddFieldDefault("orders", "status", "open")).
+		Register(2, "rename total", migrations.RenameField("orders", "total", "amount")).
+		Register(3, "default currency", migrations.AddFieldDefault("orders", "currency", "EUR"))
+	if n, err := migrator.Up(); err == nil || n != 0 {
+		t.Errorf(`Expected a migration older than the applied ones to be rejected but %d ran: %v`, n, err)
+	}
+}
@@ -0,0 +1,90 @@
+package test
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"hopcolony.io/hopcolony/docs"
+)
+
+// countRequests builds an indexStore that counts the requests made to each
+// endpoint.
+func countRequests() (*docs.HopDoc, func(endpoint string) int) {
+	store := &indexStore{indexes: make(map[string]map[string]*storedDoc)}
+	db := &docs.HopDoc{}
+	var mu sync.Mutex
+	counts := make(map[string]int)
+	db.Use(func(req *docs.Request, next docs.Handler) (*docs.Response, error) {
+		parts := strings.Split(strings.TrimPrefix(req.Path, "/"), "/")
+		mu.Lock()
+		counts[parts[len(parts)-1]]++
+		mu.Unlock()
+		return next(req)
+	})
+	db.Use(store.intercept)
+	return db, func(endpoint string) int {
+		mu.Lock()
+		defer mu.Unlock()
+		return counts[endpoint]
+	}
+}
+
+func TestMultiSearch(t *testing.T) {
+	db, count := countRequests()
+	db.Index("users").Document("ann").SetData(map[string]interface{}{"name": "Ann", "role": "admin"})
+	db.Index("users").Document("bob").SetData(map[string]interface{}{"name": "Bob", "role": "user"})
+	db.Index("orders").Document("o1").SetData(map[string]interface{}{"total": 10})
+
+	snapshots := db.MultiSearch(
+		db.Index("users").Where("role", "==", "admin"),
+		db.Index("missing"),
+		db.Index("orders"),
+	)
+	if len(snapshots) != 3 || count("_msearch") != 1 || count("_search") != 0 {
+		t.Fatalf(`Expected 3 results from a single request but got %d from %d`, len(snapshots), count("_msearch"))
+	}
+	if !snapshots[0].Success || len(snapshots[0].Docs) != 1 || snapshots[0].Docs[0].Id != "ann" {
+		t.Errorf(`Unexpected first result %+v`, snapshots[0])
+	}
+	if snapshots[1].Success || !strings.Contains(snapshots[1].Reason, "404") {
+		t.Errorf(`Expected the missing index to fail but got %+v`, snapshots[1])
+	}
+	if !snapshots[2].Success || len(snapshots[2].Docs) != 1 {
+		t.Errorf(`Unexpected third result %+v`, snapshots[2])
+	}
+}
+
+func TestSearchBatching(t *testing.T) {
+	db, count := countRequests()
+	for _, name := range []string{"ann", "bob", "cid", "dan", "eve"} {
+		db.Index("users").Document(name).SetData(map[string]interface{}{"name": name})
+	}
+	db.EnableSearchBatching(docs.SearchBatchConfig{Window: 50 * time.Millisecond})
+
+	var wg sync.WaitGroup
+	results := make([]*docs.IndexSnapshot, 5)
+	for idx, name := range []string{"ann", "bob", "cid", "dan", "eve"} {
+		wg.Add(1)
+		go func(idx int, name string) {
+			defer wg.Done()
+			results[idx] = db.Index("users").Where("name", "==", name).Get()
+		}(idx, name)
+	}
+	wg.Wait()
+
+	if count("_msearch") != 1 || count("_search") != 0 {
+		t.Errorf(`Expected a single multi search but got %d, and %d searches`, count("_msearch"), count("_search"))
+	}
+	for idx, name := range []string{"ann", "bob", "cid", "dan", "eve"} {
+		if !results[idx].Success || len(results[idx].Docs) != 1 || results[idx].Docs[0].Id != name {
+			t.Errorf(`Unexpected result for %s: %+v`, name, results[idx])
+		}
+	}
+
+	// Errors are given to the Get they belong to.
+	if snapshot := db.Index("missing").Get(); snapshot.Success {
+		t.Errorf(`Expected the search of a missing index to fail`)
+	}
+}
@@ -0,0 +1,349 @@
+package test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+
+	"hopcolony.io/hopcolony/docs"
+)
+
+// fakeServer keeps the documents of a single index in memory and can be
+// taken offline.
+type fakeServer struct {
+	mu      sync.Mutex
+	online  bool
+	docs    map[string]map[string]interface{}
+	version map[string]int
+	seqNo   map[string]int
+	next    int
+	// reject makes writes of a document fail with a bad request.
+	reject string
+	// beforeWrite is called before a write is applied.
+	beforeWrite func(id string)
+}
+
+func newFakeServer() *fakeServer {
+	return &fakeServer{online: true, docs: make(map[string]map[string]interface{}), version: make(map[string]int), seqNo: make(map[string]int)}
+}
+
+func (s *fakeServer) put(id string, source map[string]interface{}) {
+	s.docs[id] = source
+	s.version[id]++
+	s.next++
+	s.seqNo[id] = s.next
+}
+
+func (s *fakeServer) intercept(req *docs.Request, next docs.Handler) (*docs.Response, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.online {
+		return nil, errors.New("could not do request: connection refused")
+	}
+
+	path, rawQuery, _ := strings.Cut(req.Path, "?")
+	query, _ := url.ParseQuery(rawQuery)
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if parts[1] == "_search" {
+		hits := make([]map[string]interface{}, 0)
+		for id, source := range s.docs {
+			hits = append(hits, map[string]interface{}{"_id": id, "_version": s.version[id], "_source": source})
+		}
+		b, _ := json.Marshal(map[string]interface{}{"hits": map[string]interface{}{"hits": hits}})
+		return stub(http.StatusOK, string(b))(req, next)
+	}
+
+	id := parts[2]
+	switch {
+	case req.Method == http.MethodGet:
+		source, ok := s.docs[id]
+		if !ok {
+			return stub(http.StatusNotFound, `{}`)(req, next)
+		}
+		b, _ := json.Marshal(map[string]interface{}{"_id": id, "_index": parts[0], "_version": s.version[id], "_seq_no": s.seqNo[id], "_primary_term": 1, "_source": source})
+		return stub(http.StatusOK, string(b))(req, next)
+	case id == s.reject:
+		return stub(http.StatusBadRequest, `{"error":{"reason":"mapper_parsing_exception"}}`)(req, next)
+	}
+
+	if s.beforeWrite != nil {
+		s.beforeWrite(id)
+	}
+	_, exists := s.docs[id]
+	switch {
+	case query.Get("op_type") == "create" && exists,
+		query.Get("if_seq_no") != "" && (!exists || query.Get("if_seq_no") != fmt.Sprint(s.seqNo[id])):
+		return stub(http.StatusConflict, `{"error":{"reason":"version_conflict_engine_exception"}}`)(req, next)
+	case req.Method == http.MethodDelete:
+		if _, ok := s.docs[id]; !ok {
+			return stub(http.StatusNotFound, `{}`)(req, next)
+		}
+		delete(s.docs, id)
+	case len(parts) == 4:
+		var body struct{ Doc map[string]interface{} }
+		json.Unmarshal(req.Body, &body)
+		source := s.docs[id]
+		for key, value := range body.Doc {
+			source[key] = value
+		}
+		s.put(id, source)
+	default:
+		var source map[string]interface{}
+		json.Unmarshal(req.Body, &source)
+		s.put(id, source)
+	}
+	return stub(http.StatusOK, fmt.Sprintf(`{"_id":%q,"_version":%d}`, id, s.version[id]))(req, next)
+}
+
+func (s *fakeServer) setOnline(online bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.online = online
+}
+
+func offlineDB(t *testing.T, server *fakeServer, dir string, resolver docs.ConflictResolver) *docs.HopDoc {
+	db := &docs.HopDoc{}
+	db.Use(server.intercept)
+	if err := db.EnableOffline(docs.OfflineConfig{Dir: dir, Resolver: resolver}); err != nil {
+		t.Fatalf(`EnableOffline failed: %s`, err)
+	}
+	return db
+}
+
+func TestOfflineJournalReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hop-offline")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := newFakeServer()
+	db := offlineDB(t, server, dir, nil)
+	sensors := db.Index("sensors")
+	sensors.Document("a").SetData(map[string]interface{}{"temp": 20})
+	sensors.Document("b").SetData(map[string]interface{}{"temp": 30})
+
+	server.setOnline(false)
+	snapshot := sensors.Document("a").Get()
+	if !snapshot.Success || !snapshot.Stale || snapshot.Doc.Source["temp"] != 20.0 {
+		t.Fatalf(`Expected the local copy but got %+v`, snapshot)
+	}
+
+	if snapshot = sensors.Document("a").Update([]docs.UpdateData{{Key: "temp", Value: 21}}); !snapshot.Success || !snapshot.Pending {
+		t.Fatalf(`Expected the update to be queued but got %+v`, snapshot)
+	}
+	if snapshot = sensors.Add(map[string]interface{}{"temp": 40}); !snapshot.Success || snapshot.Doc.Id == "" {
+		t.Fatalf(`Expected the add to be queued with an id but got %+v`, snapshot)
+	}
+	added := snapshot.Doc.Id
+	sensors.Document("b").Delete()
+
+	if snapshot = sensors.Document("a").Get(); snapshot.Doc.Source["temp"] != 21.0 || !snapshot.Pending {
+		t.Errorf(`Expected the pending update to be visible but got %+v`, snapshot)
+	}
+	if snapshot = sensors.Document("b").Get(); snapshot.Success {
+		t.Errorf(`Expected the deleted document to be gone but got %+v`, snapshot)
+	}
+	query := db.Index("sensors").Where("temp", ">", 25).Get()
+	if !query.Success || !query.Stale || len(query.Docs) != 1 || query.Docs[0].Id != added {
+		t.Errorf(`Expected the offline query to return %s but got %+v`, added, query)
+	}
+	if err := db.Sync(); err == nil {
+		t.Errorf(`Expected Sync to fail while offline`)
+	}
+	db.Close()
+
+	// The journal survives a restart.
+	server.setOnline(true)
+	db = offlineDB(t, server, dir, nil)
+	defer db.Close()
+	if pending := db.PendingChanges(); len(pending) != 3 {
+		t.Fatalf(`Expected 3 pending changes but got %d`, len(pending))
+	}
+	if err := db.Sync(); err != nil {
+		t.Fatalf(`Sync failed: %s`, err)
+	}
+	if pending := db.PendingChanges(); len(pending) != 0 {
+		t.Errorf(`Expected no pending changes but got %d`, len(pending))
+	}
+	if server.docs["a"]["temp"] != 21.0 || server.docs[added]["temp"] != 40.0 {
+		t.Errorf(`Changes were not replayed: %v`, server.docs)
+	}
+	if _, ok := server.docs["b"]; ok {
+		t.Errorf(`Delete was not replayed`)
+	}
+}
+
+func TestOfflineConflicts(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		resolver docs.ConflictResolver
+		expected float64
+	}{
+		{"local", docs.LocalWins, 1},
+		{"remote", docs.RemoteWins, 2},
+	} {
+		dir, err := ioutil.TempDir("", "hop-offline")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		server := newFakeServer()
+		db := offlineDB(t, server, dir, test.resolver)
+		doc := db.Index("settings").Document("mode")
+		doc.SetData(map[string]interface{}{"level": 0})
+
+		server.setOnline(false)
+		doc.SetData(map[string]interface{}{"level": 1})
+		server.put("mode", map[string]interface{}{"level": 2.0})
+		server.setOnline(true)
+
+		if err := db.Sync(); err != nil {
+			t.Fatalf(`%s: Sync failed: %s`, test.name, err)
+		}
+		if level := server.docs["mode"]["level"]; level != test.expected {
+			t.Errorf(`%s: Expected level %v but got %v`, test.name, test.expected, level)
+		}
+		if snapshot := doc.Get(); snapshot.Doc.Source["level"] != test.expected {
+			t.Errorf(`%s: Expected the local copy to be refreshed but got %v`, test.name, snapshot.Doc.Source)
+		}
+		db.Close()
+	}
+}
+
+func TestOfflineRejectedChanges(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hop-offline")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := newFakeServer()
+	db := offlineDB(t, server, dir, nil)
+	defer db.Close()
+	sensors := db.Index("sensors")
+
+	server.setOnline(false)
+	sensors.Document("bad").SetData(map[string]interface{}{"temp": "hot"})
+	sensors.Document("good").SetData(map[string]interface{}{"temp": 20})
+	server.setOnline(true)
+	server.reject = "bad"
+
+	if err := db.Sync(); err != nil {
+		t.Fatalf(`Sync failed: %s`, err)
+	}
+	if _, ok := server.docs["good"]; !ok || len(db.PendingChanges()) != 0 {
+		t.Fatalf(`Expected the sync to go past the rejected change`)
+	}
+	rejected, err := db.RejectedChanges()
+	if err != nil || len(rejected) != 1 || rejected[0].Id != "bad" || !strings.Contains(rejected[0].Reason, "Bad Request") {
+		t.Fatalf(`Expected the rejected change to be kept but got %+v: %v`, rejected, err)
+	}
+	if err := db.ClearRejected(); err != nil {
+		t.Fatal(err)
+	}
+	if rejected, _ := db.RejectedChanges(); len(rejected) != 0 {
+		t.Errorf(`Expected no rejected changes but got %+v`, rejected)
+	}
+}
+
+func TestOfflineReplayIsConditional(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hop-offline")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := newFakeServer()
+	db := offlineDB(t, server, dir, docs.RemoteWins)
+	defer db.Close()
+	doc := db.Index("settings").Document("mode")
+	doc.SetData(map[string]interface{}{"level": 0})
+
+	server.setOnline(false)
+	doc.SetData(map[string]interface{}{"level": 1})
+	server.setOnline(true)
+
+	// Another writer changes the document after the replay read it, so the
+	// replayed write must not overwrite it.
+	server.beforeWrite = func(id string) {
+		server.beforeWrite = nil
+		server.put(id, map[string]interface{}{"level": 2.0})
+	}
+	if err := db.Sync(); err != nil {
+		t.Fatalf(`Sync failed: %s`, err)
+	}
+	if level := server.docs["mode"]["level"]; level != 2.0 {
+		t.Errorf(`Expected the concurrent write to win but got level %v`, level)
+	}
+}
+
+func TestOfflineSoftDelete(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hop-offline")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := newFakeServer()
+	db := offlineDB(t, server, dir, nil)
+	defer db.Close()
+	db.Audit("accounts", docs.AuditConfig{SoftDelete: true})
+	ref := db.Index("accounts").Document("1")
+	ref.SetData(map[string]interface{}{"balance": 10})
+
+	server.setOnline(false)
+	if snapshot := ref.Delete(); !snapshot.Success || len(db.PendingChanges()) != 1 {
+		t.Fatalf(`Expected the delete to be queued but got %+v`, snapshot)
+	}
+	server.setOnline(true)
+	if err := db.Sync(); err != nil {
+		t.Fatalf(`Sync failed: %s`, err)
+	}
+	if source, kept := server.docs["1"]; !kept || source[docs.DeletedField] == nil {
+		t.Errorf(`Expected the replayed delete to be soft but got %v`, server.docs)
+	}
+}
+
+func TestOfflineEnableTwice(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hop-offline")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := newFakeServer()
+	db := offlineDB(t, server, dir, nil)
+	defer db.Close()
+	before := runtime.NumGoroutine()
+	for i := 0; i < 5; i++ {
+		if err := db.EnableOffline(docs.OfflineConfig{Dir: dir}); err != nil {
+			t.Fatalf(`EnableOffline failed: %s`, err)
+		}
+	}
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf(`Expected the previous stores to be stopped but went from %d to %d goroutines`, before, after)
+	}
+
+	// Offline reads match strings like the analyzed query of the server.
+	users := db.Index("users")
+	users.Document("1").SetData(map[string]interface{}{"name": "Jane Doe", "tags": []interface{}{"Admin"}})
+	server.setOnline(false)
+	for _, q := range []struct{ field, value string }{{"name", "jane"}, {"name", "DOE"}, {"tags", "admin"}} {
+		if snapshot := db.Index("users").Where(q.field, "==", q.value).Get(); len(snapshot.Docs) != 1 {
+			t.Errorf(`Expected %s == %q to match offline but got %+v`, q.field, q.value, snapshot)
+		}
+	}
+	if snapshot := db.Index("users").Where("name", "==", "john").Get(); len(snapshot.Docs) != 0 {
+		t.Errorf(`Expected name == "john" not to match but got %+v`, snapshot)
+	}
+}
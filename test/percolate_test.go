@@ -0,0 +1,68 @@
+package test
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"hopcolony.io/hopcolony/docs"
+)
+
+func TestSavedQueries(t *testing.T) {
+	store, db := newIndexStore()
+	if err := db.Index("orders").Where("total", ">", 1000).Where("country", "==", "ES").SaveQuery("big-spain"); err != nil {
+		t.Fatalf(`SaveQuery failed: %s`, err)
+	}
+	db.Index("orders").Where("country", "==", "ES").SaveQuery("spain")
+	if n := store.count(docs.PercolatorIndex("orders")); n != 2 {
+		t.Fatalf(`Expected 2 saved queries but got %d`, n)
+	}
+
+	matched, err := db.Index("orders").Match(map[string]interface{}{"total": 1500, "country": "ES"})
+	sort.Strings(matched)
+	if err != nil || strings.Join(matched, ",") != "big-spain,spain" {
+		t.Errorf(`Unexpected matches %v, %v`, matched, err)
+	}
+	if matched, _ := db.Index("orders").Match(map[string]interface{}{"total": 1500, "country": "FR"}); len(matched) != 0 {
+		t.Errorf(`Expected no matches but got %v`, matched)
+	}
+
+	alerts := make(map[string][]string)
+	db.OnMatch("orders", func(doc *docs.Document, queries []string, err error) {
+		if err != nil {
+			t.Errorf(`Unexpected hook error %s`, err)
+		}
+		alerts[doc.Id] = queries
+	})
+	db.Index("orders").Document("o1").SetData(map[string]interface{}{"total": 200, "country": "ES"})
+	if got := alerts["o1"]; len(got) != 1 || got[0] != "spain" {
+		t.Errorf(`Unexpected alert on write %v`, got)
+	}
+	db.Index("orders").Document("o1").Update([]docs.UpdateData{{Key: "total", Value: 5000}})
+	if got := alerts["o1"]; len(got) != 2 {
+		t.Errorf(`Expected the update to match both queries but got %v`, got)
+	}
+
+	if err := db.Index("orders").RemoveQuery("spain"); err != nil {
+		t.Fatalf(`RemoveQuery failed: %s`, err)
+	}
+	if matched, _ := db.Index("orders").Match(map[string]interface{}{"total": 1, "country": "ES"}); len(matched) != 0 {
+		t.Errorf(`Expected the removed query not to match but got %v`, matched)
+	}
+}
+
+func TestMatchLeavesDocumentAlone(t *testing.T) {
+	_, db := newIndexStore()
+	db.Index("articles").Where("title", "==", "Hello").SaveQuery("hello")
+	schema, _ := docs.SchemaFor(Order{})
+	db.SetSchema("articles", schema)
+
+	article := &Article{Id: "a1", Title: "Hello"}
+	matched, err := db.Index("articles").Match(article)
+	if err != nil || len(matched) != 1 {
+		t.Errorf(`Expected the article to match without validation but got %v, %v`, matched, err)
+	}
+	if !article.CreatedAt.IsZero() || !article.UpdatedAt.IsZero() {
+		t.Errorf(`Match stamped the article %+v`, article)
+	}
+}
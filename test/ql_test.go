@@ -0,0 +1,61 @@
+package test
+
+import (
+	"reflect"
+	"testing"
+
+	"hopcolony.io/hopcolony/docs"
+)
+
+func TestQueryLanguageParse(t *testing.T) {
+	s, err := docs.ParseQuery(`where status == "open" and total >= 100.5 and paid = true order by created desc, id limit 20`)
+	if err != nil {
+		t.Fatalf(`Could not parse query: %v`, err)
+	}
+
+	expected := []docs.Query{
+		{Field: "status", Operator: "==", Value: "open"},
+		{Field: "total", Operator: ">=", Value: 100.5},
+		{Field: "paid", Operator: "==", Value: true},
+	}
+	if !reflect.DeepEqual(s.Conditions, expected) {
+		t.Errorf(`Expected conditions "%v" but got "%v"`, expected, s.Conditions)
+	}
+
+	sort := []docs.SortField{{Field: "created", Descending: true}, {Field: "id"}}
+	if !reflect.DeepEqual(s.Sort, sort) {
+		t.Errorf(`Expected sort "%v" but got "%v"`, sort, s.Sort)
+	}
+	if s.Limit != 20 {
+		t.Errorf(`Expected limit to be 20 but got %d`, s.Limit)
+	}
+
+	s, err = docs.ParseQuery(`search 'red shoes' in name, description limit 5`)
+	if err != nil {
+		t.Fatalf(`Could not parse search: %v`, err)
+	}
+	if len(s.Searches) != 1 || s.Searches[0].Text != "red shoes" || len(s.Searches[0].Fields) != 2 {
+		t.Errorf(`Unexpected searches "%v"`, s.Searches)
+	}
+
+	body := s.Apply((&docs.HopDoc{}).Index("products")).CompoundBody(s.Limit, 0)
+	if len(body.Query.Bool.Must) != 1 {
+		t.Errorf(`Expected 1 must clause but got %d`, len(body.Query.Bool.Must))
+	}
+}
+
+func TestQueryLanguageErrors(t *testing.T) {
+	for _, query := range []string{
+		`where status`,
+		`where status != "open"`,
+		`where status == "open`,
+		`order created`,
+		`limit -1`,
+		`where a == 1 limit 2 extra`,
+	} {
+		_, err := docs.ParseQuery(query)
+		if _, ok := err.(*docs.QuerySyntaxError); !ok {
+			t.Errorf(`Expected a syntax error for "%s" but got %v`, query, err)
+		}
+	}
+}
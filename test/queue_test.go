@@ -0,0 +1,263 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"hopcolony.io/hopcolony/docs"
+	"hopcolony.io/hopcolony/queue"
+)
+
+// jobStore fakes indexes with conditional writes. Searches return
+// every document of the index by descending priority and leave the filtering
+// to the claims.
+type jobStore struct {
+	mu     sync.Mutex
+	source map[string]map[string]string
+	seqNo  map[string]int64
+	next   int64
+}
+
+func newJobStore() (*jobStore, *docs.HopDoc) {
+	store := &jobStore{source: make(map[string]map[string]string), seqNo: make(map[string]int64)}
+	db := &docs.HopDoc{}
+	db.Use(store.intercept)
+	return store, db
+}
+
+func (s *jobStore) intercept(req *docs.Request, next docs.Handler) (*docs.Response, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	path, query := req.Path, url.Values{}
+	if i := strings.Index(path, "?"); i >= 0 {
+		query, _ = url.ParseQuery(path[i+1:])
+		path = path[:i]
+	}
+	parts := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	index := parts[0]
+	if s.source[index] == nil {
+		s.source[index] = make(map[string]string)
+	}
+	documents := s.source[index]
+
+	if parts[1] == "_search" {
+		ids := make([]string, 0, len(documents))
+		for id := range documents {
+			ids = append(ids, id)
+		}
+		priority := func(id string) float64 {
+			var job struct{ Priority float64 }
+			json.Unmarshal([]byte(documents[id]), &job)
+			return job.Priority
+		}
+		sort.Slice(ids, func(a, b int) bool { return priority(ids[a]) > priority(ids[b]) })
+		hits := make([]string, 0, len(ids))
+		for _, id := range ids {
+			hits = append(hits, fmt.Sprintf(`{"_id":%q,"_source":%s}`, id, documents[id]))
+		}
+		return stub(http.StatusOK, `{"hits":{"hits":[`+strings.Join(hits, ",")+`]}}`)(req, next)
+	}
+
+	s.next++
+	id := fmt.Sprint(s.next)
+	if len(parts) > 2 {
+		id = parts[2]
+	}
+	key := index + "/" + id
+	source, exists := documents[id]
+	switch {
+	case req.Method == http.MethodGet:
+		if !exists {
+			return stub(http.StatusNotFound, `{}`)(req, next)
+		}
+		return stub(http.StatusOK, fmt.Sprintf(`{"_id":%q,"_seq_no":%d,"_primary_term":1,"_source":%s}`, id, s.seqNo[key], source))(req, next)
+	case query.Get("op_type") == "create" && exists:
+		return stub(http.StatusConflict, `{}`)(req, next)
+	case query.Get("if_seq_no") != "" && (!exists || query.Get("if_seq_no") != fmt.Sprint(s.seqNo[key])):
+		return stub(http.StatusConflict, `{}`)(req, next)
+	case req.Method == http.MethodDelete:
+		delete(documents, id)
+	default:
+		documents[id] = string(req.Body)
+	}
+	s.seqNo[key] = s.next
+	return stub(http.StatusOK, fmt.Sprintf(`{"_id":%q,"_seq_no":%d,"_primary_term":1}`, id, s.next))(req, next)
+}
+
+func (s *jobStore) count(index string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.source[index])
+}
+
+func TestQueueClaim(t *testing.T) {
+	store, db := newJobStore()
+	jobs := queue.New(db, "jobs")
+	jobs.Worker = "a"
+
+	jobs.Enqueue("later", 9, time.Now().Add(time.Hour))
+	low, _ := jobs.Enqueue("low", 1, time.Now())
+	high, err := jobs.Enqueue("high", 5, time.Now())
+	if err != nil || high.Id == "" {
+		t.Fatalf(`Enqueue failed: %v`, err)
+	}
+
+	claimed, err := jobs.Claim()
+	if err != nil || claimed == nil || claimed.Id != high.Id {
+		t.Fatalf(`Expected to claim the high priority job but got %+v: %v`, claimed, err)
+	}
+	if claimed.Status != queue.Running || claimed.Attempts != 1 || claimed.LockedBy != "a" {
+		t.Errorf(`Unexpected claim %+v`, claimed)
+	}
+	var payload string
+	if claimed.Decode(&payload); payload != "high" {
+		t.Errorf(`Expected payload high but got %q`, payload)
+	}
+	if err := claimed.Complete(); err != nil {
+		t.Fatalf(`Complete failed: %s`, err)
+	}
+
+	// Jobs are not claimed again while their claim is alive.
+	other := queue.New(db, "jobs")
+	stale, _ := other.Claim()
+	if stale == nil || stale.Id != low.Id {
+		t.Fatalf(`Expected to claim the low priority job but got %+v`, stale)
+	}
+	if next, _ := jobs.Claim(); next != nil {
+		t.Errorf(`Claimed job %s was claimed again`, next.Id)
+	}
+	if store.count("jobs") != 2 {
+		t.Errorf(`Expected 2 jobs left but got %d`, store.count("jobs"))
+	}
+
+	// Expired claims are taken over and the old owner loses the job.
+	jobs.VisibilityTimeout = time.Millisecond
+	other.VisibilityTimeout = time.Millisecond
+	stale.Heartbeat()
+	time.Sleep(5 * time.Millisecond)
+	takeover, _ := jobs.Claim()
+	if takeover == nil || takeover.Id != low.Id || takeover.Attempts != 2 {
+		t.Fatalf(`Expected the expired claim to be taken over but got %+v`, takeover)
+	}
+	if err := stale.Complete(); err != queue.ClaimLost {
+		t.Errorf(`Expected ClaimLost but got %v`, err)
+	}
+}
+
+func TestQueueRetries(t *testing.T) {
+	store, db := newJobStore()
+	jobs := queue.New(db, "jobs")
+	jobs.MaxAttempts = 2
+	jobs.Backoff = queue.ExponentialBackoff(0, 0)
+	jobs.Enqueue(map[string]interface{}{"to": "a@b.c"}, 0, time.Now())
+
+	job, _ := jobs.Claim()
+	if err := job.Fail(errors.New("smtp down")); err != nil {
+		t.Fatalf(`Fail failed: %s`, err)
+	}
+	job, _ = jobs.Claim()
+	if job == nil || job.Attempts != 2 || job.LastError != "smtp down" {
+		t.Fatalf(`Expected the job to be retried but got %+v`, job)
+	}
+	if err := job.Fail(errors.New("smtp still down")); err != nil {
+		t.Fatalf(`Fail failed: %s`, err)
+	}
+	if store.count("jobs") != 0 || store.count("jobs.dead") != 1 {
+		t.Fatalf(`Expected the job to be dead lettered`)
+	}
+	for _, source := range store.source["jobs.dead"] {
+		if !strings.Contains(source, `"status":"dead"`) || !strings.Contains(source, "smtp still down") {
+			t.Errorf(`Unexpected dead letter %s`, source)
+		}
+	}
+
+	// A job whose worker dies on every attempt is dead lettered too.
+	jobs.VisibilityTimeout = time.Millisecond
+	crashing, _ := jobs.Enqueue("crash", 0, time.Now())
+	jobs.Claim()
+	time.Sleep(5 * time.Millisecond)
+	jobs.Claim()
+	time.Sleep(5 * time.Millisecond)
+	if job, err := jobs.Claim(); job != nil || err != nil {
+		t.Fatalf(`Expected the job to be dead lettered instead of claimed but got %+v: %v`, job, err)
+	}
+	if _, ok := store.source["jobs.dead"][crashing.Id]; !ok || store.count("jobs") != 0 {
+		t.Errorf(`Expected the crashing job to be dead lettered`)
+	}
+	// Losing the claim keeps the job out of the dead letter index.
+	if err := crashing.Fail(errors.New("late")); err != queue.ClaimLost || store.count("jobs.dead") != 2 {
+		t.Errorf(`Expected the late failure to lose the claim but got %v`, err)
+	}
+
+	backoff := queue.ExponentialBackoff(time.Second, 5*time.Second)
+	if backoff(1) != time.Second || backoff(3) != 4*time.Second || backoff(10) != 5*time.Second {
+		t.Errorf(`Unexpected backoff %s %s %s`, backoff(1), backoff(3), backoff(10))
+	}
+}
+
+func TestQueueWork(t *testing.T) {
+	store, db := newJobStore()
+	jobs := queue.New(db, "jobs")
+	jobs.PollInterval = time.Millisecond
+	jobs.VisibilityTimeout = 30 * time.Millisecond
+	for i := 0; i < 5; i++ {
+		jobs.Enqueue(i, 0, time.Now())
+	}
+
+	var done int32
+	ctx, cancel := context.WithCancel(context.Background())
+	finished := make(chan struct{})
+	go func() {
+		jobs.Work(ctx, 3, func(ctx context.Context, job *queue.Job) error {
+			// Outlive the visibility timeout to exercise the heartbeats.
+			time.Sleep(50 * time.Millisecond)
+			if atomic.AddInt32(&done, 1) == 5 {
+				cancel()
+			}
+			return ctx.Err()
+		})
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatalf(`Workers did not shut down`)
+	}
+	if atomic.LoadInt32(&done) != 5 || store.count("jobs") != 0 {
+		t.Errorf(`Expected 5 jobs done once each but got %d with %d left`, done, store.count("jobs"))
+	}
+}
+
+func TestQueueVisibilityTimeoutDefaults(t *testing.T) {
+	for _, timeout := range []time.Duration{0, -time.Second, time.Nanosecond} {
+		store, db := newJobStore()
+		jobs := queue.New(db, "jobs")
+		jobs.VisibilityTimeout = timeout
+		jobs.PollInterval = time.Millisecond
+		jobs.Enqueue("job", 0, time.Now())
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		jobs.Work(ctx, 1, func(ctx context.Context, job *queue.Job) error {
+			if timeout <= 0 && time.Until(job.LockedUntil) < time.Second {
+				t.Errorf(`Expected the default visibility timeout but the claim ends at %s`, job.LockedUntil)
+			}
+			cancel()
+			return nil
+		})
+		cancel()
+		if store.count("jobs") != 0 {
+			t.Errorf(`Expected the job to be done with a %s visibility timeout`, timeout)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package test
+
+import (
+	"strings"
+	"testing"
+
+	"hopcolony.io/hopcolony/docs"
+)
+
+type refCustomer struct {
+	Id       string
+	Name     string
+	Referrer *refCustomer
+}
+
+type refOrder struct {
+	Total    float64
+	Customer refCustomer
+	Items    []struct {
+		Product struct{ Name string }
+	}
+}
+
+func TestPopulate(t *testing.T) {
+	store := &indexStore{indexes: make(map[string]map[string]*storedDoc)}
+	db := &docs.HopDoc{}
+	var mgets int
+	db.Use(func(req *docs.Request, next docs.Handler) (*docs.Response, error) {
+		if strings.HasPrefix(req.Path, "/_mget") {
+			mgets++
+		}
+		return next(req)
+	})
+	db.Use(store.intercept)
+
+	customers, products := db.Index("customers"), db.Index("products")
+	customers.Document("ann").SetData(map[string]interface{}{"name": "Ann", "referrer": customers.Document("bob").Ref()})
+	customers.Document("bob").SetData(map[string]interface{}{"name": "Bob", "referrer": customers.Document("ann").Ref()})
+	products.Document("p1").SetData(map[string]interface{}{"name": "Pen"})
+	db.Index("orders").Document("o1").SetData(map[string]interface{}{
+		"total":    3.5,
+		"customer": customers.Document("ann").Ref(),
+		"items": []interface{}{
+			map[string]interface{}{"product": products.Document("p1").Ref()},
+			map[string]interface{}{"product": docs.Ref{Index: "products", Id: "gone"}},
+		},
+	})
+
+	snapshot := db.Index("orders").Document("o1").Get(docs.Populate(3))
+	if !snapshot.Success {
+		t.Fatalf(`Get failed: %s`, snapshot.Reason)
+	}
+	var order refOrder
+	if err := snapshot.Doc.DataTo(&order); err != nil {
+		t.Fatalf(`Could not decode the order: %s`, err)
+	}
+	if order.Customer.Name != "Ann" || order.Customer.Id != "ann" || order.Items[0].Product.Name != "Pen" {
+		t.Errorf(`Refs were not populated: %+v`, order)
+	}
+	if order.Customer.Referrer == nil || order.Customer.Referrer.Name != "Bob" {
+		t.Fatalf(`Nested refs were not populated: %+v`, order.Customer)
+	}
+	// Bob refers back to Ann, who is already being populated.
+	if back := order.Customer.Referrer.Referrer; back == nil || back.Id != "ann" || back.Name != "" {
+		t.Errorf(`Expected the cycle to be left as a ref but got %+v`, back)
+	}
+	if missing := snapshot.Doc.Source["items"].([]interface{})[1].(map[string]interface{})["product"]; len(missing.(map[string]interface{})) != 2 {
+		t.Errorf(`Expected the missing document to be left as a ref but got %v`, missing)
+	}
+	if mgets != 2 {
+		t.Errorf(`Expected a multi-get per level but got %d`, mgets)
+	}
+
+	// Depth limits how far refs are followed, and queries populate too.
+	results := db.Index("orders").Get(docs.Populate(1))
+	customer := results.Docs[0].Source["customer"].(map[string]interface{})
+	if customer["name"] != "Ann" || len(customer["referrer"].(map[string]interface{})) != 2 {
+		t.Errorf(`Expected only the first level to be populated but got %v`, customer)
+	}
+}
@@ -0,0 +1,182 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"hopcolony.io/hopcolony/scheduler"
+)
+
+func TestCron(t *testing.T) {
+	from := time.Date(2021, 3, 15, 10, 7, 30, 0, time.UTC) // a Monday
+	cases := []struct {
+		expr string
+		next time.Time
+	}{
+		{"* * * * *", time.Date(2021, 3, 15, 10, 8, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2021, 3, 15, 10, 15, 0, 0, time.UTC)},
+		{"0 9-17 * * 1-5", time.Date(2021, 3, 15, 11, 0, 0, 0, time.UTC)},
+		{"30 2 * * 7", time.Date(2021, 3, 21, 2, 30, 0, 0, time.UTC)},
+		{"0 0 1,15 * 3", time.Date(2021, 3, 17, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{"@every 90s", from.Add(90 * time.Second)},
+	}
+	for _, c := range cases {
+		schedule, err := scheduler.Cron(c.expr)
+		if err != nil {
+			t.Errorf(`Could not parse %q: %s`, c.expr, err)
+			continue
+		}
+		if next := schedule.Next(from); !next.Equal(c.next) {
+			t.Errorf(`Expected %q to fire at %s but got %s`, c.expr, c.next, next)
+		}
+	}
+
+	for _, expr := range []string{"* * * *", "60 * * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "@every -1s"} {
+		if _, err := scheduler.Cron(expr); err == nil {
+			t.Errorf(`Expected %q to be rejected`, expr)
+		}
+	}
+}
+
+func TestSchedulerSingleExecution(t *testing.T) {
+	store, db := newJobStore()
+	var mu sync.Mutex
+	fired := make(map[time.Time]string)
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	for _, owner := range []string{"a", "b"} {
+		owner := owner
+		s := scheduler.New(db)
+		s.Locker.Owner = owner
+		s.Tick = 5 * time.Millisecond
+		s.Register(scheduler.Task{Name: "report", Schedule: scheduler.Every(20 * time.Millisecond),
+			Func: func(ctx context.Context, fireTime time.Time) error {
+				mu.Lock()
+				defer mu.Unlock()
+				if previous, ok := fired[fireTime]; ok {
+					t.Errorf(`%s fired at %s again after %s`, owner, fireTime, previous)
+				}
+				fired[fireTime] = owner
+				return nil
+			}})
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.Run(ctx)
+		}()
+	}
+	time.Sleep(300 * time.Millisecond)
+	cancel()
+	wg.Wait()
+
+	owners := make(map[string]bool)
+	for _, owner := range fired {
+		owners[owner] = true
+	}
+	if len(fired) < 5 || len(owners) != 1 {
+		t.Errorf(`Expected a single leader to fire regularly but got %v`, fired)
+	}
+	if runs := store.count(scheduler.RunsIndex); runs != len(fired) {
+		t.Errorf(`Expected %d run records but got %d`, len(fired), runs)
+	}
+	if _, held := store.source[".hop.locks"]["scheduler"]; !held {
+		t.Errorf(`Leader lock was never written`)
+	}
+}
+
+func TestSchedulerCatchUp(t *testing.T) {
+	cases := map[scheduler.CatchUp]int{scheduler.RunAll: 6, scheduler.RunOnce: 1, scheduler.Skip: 0}
+	for policy, expected := range cases {
+		store, db := newJobStore()
+		lastFire := time.Now().Add(-65 * time.Minute).UTC().Format(time.RFC3339Nano)
+		store.source[scheduler.CheckpointIndex] = map[string]string{"backup": fmt.Sprintf(`{"lastFire":%q}`, lastFire)}
+		store.seqNo[scheduler.CheckpointIndex+"/backup"] = 1
+		store.next = 1
+
+		s := scheduler.New(db)
+		s.Tick = 5 * time.Millisecond
+		s.Register(scheduler.Task{Name: "backup", Schedule: scheduler.Every(10 * time.Minute), CatchUp: policy,
+			Func: func(ctx context.Context, fireTime time.Time) error { return nil }})
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		s.Run(ctx)
+		cancel()
+
+		if runs := store.count(scheduler.RunsIndex); runs != expected {
+			t.Errorf(`Expected policy %d to run %d times but got %d`, policy, expected, runs)
+		}
+		if history := scheduler.History(db, "backup").Get(); !history.Success || len(history.Docs) != expected {
+			t.Errorf(`Expected %d runs in the history of policy %d`, expected, policy)
+		}
+	}
+}
+
+func TestSchedulerLongOutage(t *testing.T) {
+	store, db := newJobStore()
+	last := time.Now().AddDate(-10, 0, 0).UTC().Truncate(time.Second)
+	store.source[scheduler.CheckpointIndex] = map[string]string{"ping": fmt.Sprintf(`{"lastFire":%q}`, last.Format(time.RFC3339Nano))}
+	store.seqNo[scheduler.CheckpointIndex+"/ping"] = 1
+	store.next = 1
+
+	fires := make(chan time.Time, 10)
+	s := scheduler.New(db)
+	s.Tick = 5 * time.Millisecond
+	s.Register(scheduler.Task{Name: "ping", Schedule: scheduler.Every(time.Second),
+		Func: func(ctx context.Context, fireTime time.Time) error {
+			fires <- fireTime
+			return nil
+		}})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	s.Run(ctx)
+	cancel()
+
+	// The missed fire times are not walked one by one, and the one that runs
+	// keeps counting from the last fire. A second run happens if the test
+	// crosses a second.
+	if len(fires) == 0 || len(fires) > 2 {
+		t.Fatalf(`Expected a single run but got %d`, len(fires))
+	}
+	if fire := <-fires; time.Since(fire) > 2*time.Second || fire.Sub(last)%time.Second != 0 {
+		t.Errorf(`Unexpected fire time %s`, fire)
+	}
+}
+
+// stuckSchedule never moves past the time it is given.
+type stuckSchedule struct{}
+
+func (stuckSchedule) Next(t time.Time) time.Time {
+	return t
+}
+
+func TestSchedulerStuckSchedule(t *testing.T) {
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf(`Expected Every(0) to panic`)
+			}
+		}()
+		scheduler.Every(0)
+	}()
+
+	_, db := newJobStore()
+	s := scheduler.New(db)
+	s.Tick = 5 * time.Millisecond
+	s.Register(scheduler.Task{Name: "stuck", Schedule: stuckSchedule{},
+		Func: func(ctx context.Context, fireTime time.Time) error { return nil }})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf(`Scheduler hung on a schedule that does not move forward`)
+	}
+}
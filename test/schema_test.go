@@ -0,0 +1,114 @@
+package test
+
+import (
+	"strings"
+	"testing"
+
+	"hopcolony.io/hopcolony/docs"
+)
+
+type Order struct {
+	Status string   `json:"status" hop:"required,enum=open|closed"`
+	Total  float64  `json:"total" hop:"min=0,max=1000"`
+	Code   string   `json:"code" hop:"min=3,max=5"`
+	Tags   []string `json:"tags" hop:"max=2"`
+	Note   *string  `json:"note,omitempty"`
+}
+
+func TestSchemaFromStruct(t *testing.T) {
+	schema, err := docs.SchemaFor(Order{})
+	if err != nil {
+		t.Fatalf(`Could not derive schema: %v`, err)
+	}
+
+	if err := schema.Validate(Order{Status: "open", Total: 10, Code: "abcd"}); err != nil {
+		t.Errorf(`Valid order failed validation: %v`, err)
+	}
+
+	err = schema.Validate(map[string]interface{}{
+		"status": "pending",
+		"total":  -1.0,
+		"code":   "ab",
+		"tags":   []interface{}{"a", "b", "c"},
+	})
+	errs, ok := err.(docs.ValidationErrors)
+	if !ok {
+		t.Fatalf(`Expected ValidationErrors but got %v`, err)
+	}
+	paths := make([]string, 0)
+	for _, e := range errs {
+		paths = append(paths, e.Path)
+	}
+	if strings.Join(paths, ",") != "code,status,tags,total" {
+		t.Errorf(`Unexpected failing fields "%v"`, paths)
+	}
+
+	err = schema.Validate(map[string]interface{}{"total": "10"})
+	if err == nil || !strings.Contains(err.Error(), "status: is required") || !strings.Contains(err.Error(), "total: must be of type number") {
+		t.Errorf(`Unexpected validation error %v`, err)
+	}
+}
+
+func TestSchemaParse(t *testing.T) {
+	schema, err := docs.ParseSchema([]byte(`{
+		"type": "object",
+		"required": ["name"],
+		"additionalProperties": false,
+		"properties": {
+			"name": {"type": "string", "pattern": "^[a-z]+$"},
+			"age": {"type": ["integer", "null"], "minimum": 0},
+			"address": {"type": "object", "properties": {"zip": {"type": "string", "minLength": 5}}}
+		}
+	}`))
+	if err != nil {
+		t.Fatalf(`Could not parse schema: %v`, err)
+	}
+
+	err = schema.Validate(map[string]interface{}{
+		"name":    "Jane",
+		"age":     1.5,
+		"address": map[string]interface{}{"zip": "123"},
+		"extra":   true,
+	})
+	if err == nil {
+		t.Fatal(`Invalid document passed validation`)
+	}
+	for _, expected := range []string{"name: must match", "age: must be of type integer or null", "address.zip: must be at least 5", "extra: is not allowed"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf(`Expected "%s" in "%v"`, expected, err)
+		}
+	}
+}
+
+func TestSchemaRejectsWrites(t *testing.T) {
+	db := &docs.HopDoc{}
+	db.Use(func(req *docs.Request, next docs.Handler) (*docs.Response, error) {
+		t.Errorf(`Invalid document was sent to %s`, req.Path)
+		return stub(200, `{}`)(req, next)
+	})
+
+	schema, _ := docs.SchemaFor(Order{})
+	db.SetSchema("orders", schema)
+
+	snapshot := db.Index("orders").Document("1").SetData(Order{Status: "lost"})
+	if snapshot.Success || !strings.Contains(snapshot.Reason, "status: must be one of") {
+		t.Errorf(`Expected SetData to fail validation but got "%s"`, snapshot.Reason)
+	}
+
+	snapshot = db.Index("orders").Add(map[string]interface{}{"total": 5})
+	if snapshot.Success || !strings.Contains(snapshot.Reason, "status: is required") {
+		t.Errorf(`Expected Add to fail validation but got "%s"`, snapshot.Reason)
+	}
+
+	snapshot = db.Index("orders").Document("1").Update([]docs.UpdateData{{Key: "total", Value: 5000}})
+	if snapshot.Success || !strings.Contains(snapshot.Reason, "total: must be at most 1000") {
+		t.Errorf(`Expected Update to fail validation but got "%s"`, snapshot.Reason)
+	}
+
+	for _, data := range []interface{}{"lost", []interface{}{Order{}}, 5} {
+		snapshot = db.Index("orders").Document("1").SetData(data)
+		if snapshot.Success || !strings.Contains(snapshot.Reason, "must be an object") {
+			t.Errorf(`Expected SetData of %v to fail validation but got "%s"`, data, snapshot.Reason)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"hopcolony.io/hopcolony/docs"
+)
+
+func TestForEachClearsScroll(t *testing.T) {
+	var cleared []string
+	db := &docs.HopDoc{}
+	db.Use(func(req *docs.Request, next docs.Handler) (*docs.Response, error) {
+		if req.Method == http.MethodDelete {
+			var body struct {
+				ScrollId string `json:"scroll_id"`
+			}
+			json.Unmarshal(req.Body, &body)
+			cleared = append(cleared, req.Path+" "+body.ScrollId)
+			return stub(http.StatusOK, `{}`)(req, next)
+		}
+		return stub(http.StatusOK, `{"_scroll_id":"s1","hits":{"hits":[{"_id":"a","_source":{}},{"_id":"b","_source":{}}]}}`)(req, next)
+	})
+
+	for _, stop := range []error{docs.StopIteration, errors.New("failed")} {
+		cleared = nil
+		err := db.Index("products").ForEach(func(doc *docs.Document) error { return stop })
+		if stop != docs.StopIteration && err != stop {
+			t.Errorf(`Expected the error of fn but got %v`, err)
+		}
+		if len(cleared) != 1 || cleared[0] != "/_search/scroll s1" {
+			t.Errorf(`Expected the scroll to be cleared after %v but got %v`, stop, cleared)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"hopcolony.io/hopcolony/docs"
+)
+
+func TestSearchCompoundBody(t *testing.T) {
+	index := (&docs.HopDoc{}).Index("products").
+		Where("stock", ">", 0).
+		Search("red shoes", docs.Boost("name", 3), "description").
+		SearchPrefix("sne", "name").
+		Highlight("name")
+
+	body := index.CompoundBody(10, 0)
+	if len(body.Query.Bool.Must) != 3 {
+		t.Fatalf(`Expected 3 must clauses but got %d`, len(body.Query.Bool.Must))
+	}
+
+	multiMatch, ok := body.Query.Bool.Must[1]["multi_match"].(map[string]interface{})
+	if !ok {
+		t.Fatalf(`Expected a multi_match clause but got "%v"`, body.Query.Bool.Must[1])
+	}
+	if multiMatch["type"] != "best_fields" {
+		t.Errorf(`Expected type to be "best_fields" but got "%v"`, multiMatch["type"])
+	}
+	fields := multiMatch["fields"].([]string)
+	if fields[0] != "name^3" {
+		t.Errorf(`Expected boosted field to be "name^3" but got "%s"`, fields[0])
+	}
+
+	prefix := body.Query.Bool.Must[2]["multi_match"].(map[string]interface{})
+	if prefix["type"] != "phrase_prefix" {
+		t.Errorf(`Expected type to be "phrase_prefix" but got "%v"`, prefix["type"])
+	}
+
+	if body.Highlight == nil {
+		t.Fatal(`Expected highlight to be set`)
+	}
+	if _, ok := body.Highlight.Fields["name"]; !ok {
+		t.Errorf(`Expected highlight on "name"`)
+	}
+}
+
+func TestSearchDocumentScore(t *testing.T) {
+	raw := []byte(`{"_index":"products","_id":"1","_score":1.5,"_source":{"name":"Red shoes"},"highlight":{"name":["<em>Red</em> shoes"]}}`)
+	var doc docs.Document
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		t.Fatalf(`Could not unmarshal document: %v`, err)
+	}
+	if doc.Score != 1.5 {
+		t.Errorf(`Expected score to be 1.5 but got %v`, doc.Score)
+	}
+	if doc.Highlight["name"][0] != "<em>Red</em> shoes" {
+		t.Errorf(`Unexpected highlight "%v"`, doc.Highlight["name"])
+	}
+}
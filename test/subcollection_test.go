@@ -0,0 +1,109 @@
+package test
+
+import (
+	"bytes"
+	"testing"
+
+	"hopcolony.io/hopcolony/docs"
+)
+
+func TestSubcollections(t *testing.T) {
+	store, db := newIndexStore()
+	posts := db.Index("posts")
+	first, second := posts.Document("p1"), posts.Document("p2")
+	first.SetData(map[string]interface{}{"title": "First"})
+	second.SetData(map[string]interface{}{"title": "Second"})
+
+	comments := first.Collection("comments")
+	if snapshot := comments.Document("c1").SetData(map[string]interface{}{"text": "Nice"}); !snapshot.Success {
+		t.Fatalf(`SetData failed: %s`, snapshot.Reason)
+	}
+	comments.Add(map[string]interface{}{"text": "Agreed"})
+	// The same id under another parent is another document.
+	second.Collection("comments").Document("c1").SetData(map[string]interface{}{"text": "Other"})
+	comments.Document("c1").Collection("replies").Document("r1").SetData(map[string]interface{}{"text": "Thanks"})
+
+	if n := store.count("posts~comments"); n != 3 {
+		t.Fatalf(`Expected 3 comments stored but got %d`, n)
+	}
+	stored := store.indexes["posts~comments"]["p1:c1"]
+	if stored == nil || stored.source[docs.ParentField] != "posts/p1" {
+		t.Fatalf(`Unexpected stored comment %v`, store.indexes["posts~comments"])
+	}
+	if path := comments.Document("c1").Collection("replies").Document("r1").Path(); path != "posts/p1/comments/c1/replies/r1" {
+		t.Errorf(`Unexpected path %s`, path)
+	}
+
+	if snapshot := comments.Document("c1").Get(); !snapshot.Success || snapshot.Doc.Source["text"] != "Nice" {
+		t.Errorf(`Unexpected comment %+v`, snapshot)
+	}
+	if snapshot := comments.Get(); !snapshot.Success || len(snapshot.Docs) != 2 {
+		t.Errorf(`Expected the 2 comments of the parent but got %+v`, snapshot)
+	}
+	if count, err := second.Collection("comments").Count(); err != nil || count != 1 {
+		t.Errorf(`Expected 1 comment but got %d, %v`, count, err)
+	}
+	if snapshot := db.CollectionGroup("comments").Get(); !snapshot.Success || len(snapshot.Docs) != 3 {
+		t.Errorf(`Expected the comments of every post but got %+v`, snapshot)
+	}
+
+	if snapshot := first.Delete(docs.Cascade("comments", "comments.replies")); !snapshot.Success {
+		t.Fatalf(`Delete failed: %s`, snapshot.Reason)
+	}
+	if comments, replies := store.count("posts~comments"), store.count("posts~comments~replies"); comments != 1 || replies != 0 {
+		t.Errorf(`Expected only the comment of the other post to be left but got %d comments and %d replies`, comments, replies)
+	}
+}
+
+func TestCascadeSoftDelete(t *testing.T) {
+	store, db := newIndexStore()
+	db.Audit("posts~comments", docs.AuditConfig{SoftDelete: true})
+	post := db.Index("posts").Document("p1")
+	post.SetData(map[string]interface{}{"title": "First"})
+	post.Collection("comments").Document("c1").SetData(map[string]interface{}{"text": "Nice"})
+
+	if snapshot := post.Delete(docs.Cascade("comments")); !snapshot.Success {
+		t.Fatalf(`Delete failed: %s`, snapshot.Reason)
+	}
+	comment, kept := store.indexes["posts~comments"]["p1:c1"]
+	if !kept || comment.source[docs.DeletedField] == nil {
+		t.Fatalf(`Expected the comment to be marked as deleted but got %v`, store.indexes["posts~comments"])
+	}
+	if snapshot := post.Collection("comments").Get(); len(snapshot.Docs) != 0 {
+		t.Errorf(`Soft deleted comment was listed`)
+	}
+}
+
+func TestCollectionGroupOptions(t *testing.T) {
+	_, db := newIndexStore()
+	provider, _ := docs.NewStaticKeyProvider("k1", bytes.Repeat([]byte{1}, 32))
+	db.EncryptFields("posts~comments", docs.EncryptionConfig{Provider: provider, Fields: []string{"text"}})
+	db.Audit("posts~comments", docs.AuditConfig{SoftDelete: true})
+	comments := db.Index("posts").Document("p1").Collection("comments")
+	comments.Document("c1").SetData(map[string]interface{}{"text": "Nice"})
+	comments.Document("c2").SetData(map[string]interface{}{"text": "Gone"})
+	comments.Document("c2").Delete()
+
+	snapshot := db.CollectionGroup("comments").Get()
+	if !snapshot.Success || len(snapshot.Docs) != 1 || snapshot.Docs[0].Source["text"] != "Nice" {
+		t.Errorf(`Expected the decrypted comment that was not deleted but got %+v`, snapshot)
+	}
+}
+
+func TestSubcollectionNamedLikeSystemIndexes(t *testing.T) {
+	store, db := newIndexStore()
+	db.Audit("posts", docs.AuditConfig{History: true})
+	post := db.Index("posts").Document("p1")
+	post.SetData(map[string]interface{}{"title": "First"})
+	post.SetData(map[string]interface{}{"title": "Second"})
+	revisions := store.count(docs.HistoryIndex("posts"))
+	post.Collection("history").Document("h1").SetData(map[string]interface{}{"text": "Edited"})
+
+	if n := store.count(docs.HistoryIndex("posts")); revisions == 0 || n != revisions {
+		t.Errorf(`Expected the history to hold only the %d revisions of the post but got %d documents`, revisions, n)
+	}
+	snapshot := db.CollectionGroup("history").Get()
+	if !snapshot.Success || len(snapshot.Docs) != 1 || snapshot.Docs[0].Source["text"] != "Edited" {
+		t.Errorf(`Expected the group to hold only the subcollection document but got %+v`, snapshot)
+	}
+}
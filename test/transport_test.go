@@ -0,0 +1,123 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"hopcolony.io/hopcolony/docs"
+)
+
+type recordingLogger struct {
+	infos  []string
+	errors []string
+}
+
+func (l *recordingLogger) Info(msg string, args ...interface{}) {
+	l.infos = append(l.infos, fmt.Sprint(append([]interface{}{msg}, args...)...))
+}
+
+func (l *recordingLogger) Error(msg string, args ...interface{}) {
+	l.errors = append(l.errors, fmt.Sprint(append([]interface{}{msg}, args...)...))
+}
+
+func stub(status int, body string) docs.Interceptor {
+	return func(req *docs.Request, next docs.Handler) (*docs.Response, error) {
+		resp := &docs.Response{StatusCode: status, Status: http.StatusText(status), Body: []byte(body)}
+		if status >= 300 {
+			return resp, &docs.StatusError{Method: req.Method, StatusCode: status, Status: resp.Status}
+		}
+		return resp, nil
+	}
+}
+
+func TestTransportInterceptors(t *testing.T) {
+	logger := &recordingLogger{}
+	metrics := docs.NewRequestMetrics()
+	var seen []*docs.Request
+
+	db := &docs.HopDoc{}
+	db.Use(docs.Logging(logger), docs.Instrument(metrics), func(req *docs.Request, next docs.Handler) (*docs.Response, error) {
+		seen = append(seen, req)
+		return next(req)
+	}, stub(http.StatusOK, `{"count": 3}`))
+
+	count, err := db.Index("orders").Count()
+	if err != nil {
+		t.Fatalf(`Count failed: %v`, err)
+	}
+	if count != 3 {
+		t.Errorf(`Expected count to be 3 but got %d`, count)
+	}
+
+	if len(seen) != 1 {
+		t.Fatalf(`Expected 1 request but got %d`, len(seen))
+	}
+	if seen[0].Index != "orders" || seen[0].Operation != "count" {
+		t.Errorf(`Expected index "orders" and op "count" but got "%s" and "%s"`, seen[0].Index, seen[0].Operation)
+	}
+	if seen[0].Header.Get("X-Request-Id") == "" {
+		t.Error(`Request has no X-Request-Id header`)
+	}
+
+	if len(logger.infos) != 1 || !strings.Contains(logger.infos[0], "orders") {
+		t.Errorf(`Unexpected log lines "%v"`, logger.infos)
+	}
+
+	var out bytes.Buffer
+	if err := metrics.WritePrometheus(&out); err != nil {
+		t.Fatalf(`Could not write metrics: %v`, err)
+	}
+	if !strings.Contains(out.String(), `hop_docs_requests_total{index="orders",op="count",status="200"} 1`) {
+		t.Errorf(`Missing request counter in "%s"`, out.String())
+	}
+}
+
+func TestTransportRetry(t *testing.T) {
+	attempts := 0
+	db := &docs.HopDoc{}
+	db.Use(docs.Retry(3, time.Millisecond), func(req *docs.Request, next docs.Handler) (*docs.Response, error) {
+		attempts++
+		if attempts < 3 {
+			return stub(http.StatusServiceUnavailable, "")(req, next)
+		}
+		return stub(http.StatusOK, `{"status": "green"}`)(req, next)
+	})
+
+	status, err := db.Status()
+	if err != nil {
+		t.Fatalf(`Status failed: %v`, err)
+	}
+	if status != "green" || attempts != 3 {
+		t.Errorf(`Expected "green" after 3 attempts but got "%s" after %d`, status, attempts)
+	}
+
+	db = &docs.HopDoc{}
+	db.Use(docs.Retry(3, time.Millisecond), stub(http.StatusNotFound, ""))
+	_, err = db.Status()
+	var statusErr *docs.StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusNotFound {
+		t.Errorf(`Expected a 404 StatusError but got %v`, err)
+	}
+
+	// A document added without an id could be created twice.
+	attempts = 0
+	db = &docs.HopDoc{}
+	db.Use(docs.Retry(3, time.Millisecond), func(req *docs.Request, next docs.Handler) (*docs.Response, error) {
+		attempts++
+		return stub(http.StatusServiceUnavailable, "")(req, next)
+	})
+	if snapshot := db.Index("orders").Add(map[string]interface{}{"total": 1}); snapshot.Success || attempts != 1 {
+		t.Errorf(`Expected the add to be sent once but it was sent %d times`, attempts)
+	}
+	attempts = 0
+	db.Index("orders").WithContext(docs.RetryUnsafe(context.Background())).Add(map[string]interface{}{"total": 1})
+	if attempts != 3 {
+		t.Errorf(`Expected the add to be retried when allowed but it was sent %d times`, attempts)
+	}
+}
@@ -0,0 +1,133 @@
+package test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"hopcolony.io/hopcolony/docs"
+)
+
+func TestExpireAt(t *testing.T) {
+	store, db := newIndexStore()
+	sessions := db.Index("sessions")
+	sessions.Document("old").SetData(map[string]interface{}{"user": "a"}, docs.ExpireAt(time.Now().Add(-time.Minute)))
+	sessions.Document("live").SetData(map[string]interface{}{"user": "b"}, docs.ExpireAfter(time.Hour))
+	sessions.Document("forever").SetData(map[string]interface{}{"user": "c"})
+
+	if _, ok := store.indexes["sessions"]["old"].source[docs.ExpiryField]; !ok {
+		t.Fatalf(`Expiry was not stored`)
+	}
+	if snapshot := sessions.Document("old").Get(); snapshot.Success {
+		t.Errorf(`Expired document was read`)
+	} else if statusErr, ok := snapshot.Err.(*docs.StatusError); !ok || statusErr.StatusCode != 404 {
+		t.Errorf(`Expected expired documents to read as not found but got %v`, snapshot.Err)
+	}
+	if snapshot := sessions.Document("live").Get(); !snapshot.Success {
+		t.Errorf(`Live document was not read: %s`, snapshot.Reason)
+	}
+	if snapshot := db.Index("sessions").Get(); len(snapshot.Docs) != 2 {
+		t.Errorf(`Expected 2 unexpired documents but got %d`, len(snapshot.Docs))
+	}
+
+	// Updates can move the expiry.
+	sessions.Document("live").Update([]docs.UpdateData{{Key: "user", Value: "b"}}, docs.ExpireAt(time.Now().Add(-time.Second)))
+	count := 0
+	db.Index("sessions").ForEach(func(doc *docs.Document) error {
+		count++
+		return nil
+	})
+	if count != 1 {
+		t.Errorf(`Expected 1 unexpired document but got %d`, count)
+	}
+}
+
+func TestSweeper(t *testing.T) {
+	store, db := newIndexStore()
+	for _, id := range []string{"a", "b", "c", "d", "e"} {
+		db.Index("otps").Document(id).SetData(map[string]interface{}{"code": id}, docs.ExpireAt(time.Now().Add(-time.Second)))
+	}
+	db.Index("otps").Document("f").SetData(map[string]interface{}{"code": "f"}, docs.ExpireAfter(time.Hour))
+
+	sweeper := db.Sweeper("otps", "missing")
+	sweeper.BatchSize = 2
+	if deleted, err := sweeper.Sweep(); err != nil || deleted != 5 {
+		t.Fatalf(`Expected 5 documents to be swept but got %d: %v`, deleted, err)
+	}
+	if store.count("otps") != 1 {
+		t.Errorf(`Expected the live document to be kept`)
+	}
+	if stats := sweeper.Stats("otps"); stats.Sweeps != 1 || stats.Deleted != 5 || stats.Errors != 0 {
+		t.Errorf(`Unexpected stats %+v`, stats)
+	}
+	var metrics bytes.Buffer
+	sweeper.WritePrometheus(&metrics)
+	if !strings.Contains(metrics.String(), `hop_docs_swept_documents_total{index="otps"} 5`) {
+		t.Errorf(`Unexpected metrics %s`, metrics.String())
+	}
+
+	// Background sweeps stop with the client.
+	db.Index("otps").Document("g").SetData(map[string]interface{}{"code": "g"}, docs.ExpireAt(time.Now()))
+	background := db.Sweeper("otps")
+	background.Interval = 10 * time.Millisecond
+	background.Start()
+	time.Sleep(50 * time.Millisecond)
+	db.Close()
+	sweeps := background.Stats("otps").Sweeps
+	time.Sleep(30 * time.Millisecond)
+	if sweeps < 2 || background.Stats("otps").Sweeps != sweeps || store.count("otps") != 1 {
+		t.Errorf(`Expected the sweeper to run until Close but got %d sweeps`, sweeps)
+	}
+}
+
+func TestSweeperTombstones(t *testing.T) {
+	store, db := newIndexStore()
+	db.TrackDeletes("otps")
+	for _, id := range []string{"a", "b", "c"} {
+		db.Index("otps").Document(id).SetData(map[string]interface{}{"code": id}, docs.ExpireAt(time.Now().Add(-time.Second)))
+	}
+	db.Index("otps").Document("d").SetData(map[string]interface{}{"code": "d"}, docs.ExpireAfter(time.Hour))
+
+	sweeper := db.Sweeper("otps")
+	sweeper.BatchSize = 2
+	if deleted, err := sweeper.Sweep(); err != nil || deleted != 3 {
+		t.Fatalf(`Expected 3 documents to be swept but got %d: %v`, deleted, err)
+	}
+	if store.count("otps") != 1 || store.count(docs.TombstoneIndex) != 3 {
+		t.Errorf(`Expected a tombstone for every swept document but got %d`, store.count(docs.TombstoneIndex))
+	}
+
+	// Starting twice runs a single background loop.
+	sweeper.Start()
+	sweeper.Start()
+	sweeper.Stop()
+}
+
+func TestSweeperDefaults(t *testing.T) {
+	store, db := newIndexStore()
+	db.TrackDeletes("otps")
+	db.Index("otps").Document("a").SetData(map[string]interface{}{"code": "a"}, docs.ExpireAt(time.Now().Add(-time.Second)))
+	// A field of the user with the same name as the usual one is left alone.
+	db.Index("otps").Document("b").SetData(map[string]interface{}{"expireAt": time.Now().Add(-time.Hour).UTC().Format(time.RFC3339Nano)})
+	if snapshot := db.Index("otps").Document("b").Get(); !snapshot.Success {
+		t.Errorf(`Expected a document with its own expireAt to be read but got %s`, snapshot.Reason)
+	}
+
+	sweeper := db.Sweeper("otps")
+	sweeper.Interval, sweeper.BatchSize = 0, 0
+	done := make(chan struct{})
+	go func() {
+		sweeper.Start()
+		sweeper.Stop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf(`Sweeper did not stop with a zero batch size`)
+	}
+	if store.count("otps") != 1 {
+		t.Errorf(`Expected only the expired document to be swept but %d are left`, store.count("otps"))
+	}
+}
@@ -0,0 +1,90 @@
+package test
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+
+	"hopcolony.io/hopcolony/docs"
+)
+
+type embedded struct {
+	Title     string      `json:"title"`
+	Lang      string      `json:"lang"`
+	Embedding docs.Vector `json:"embedding"`
+}
+
+func TestNearest(t *testing.T) {
+	store, db := newIndexStore()
+	articles := db.Index("articles")
+	articles.Document("north").SetData(embedded{"North", "en", docs.Vector{0, 1}})
+	articles.Document("east").SetData(embedded{"East", "en", docs.Vector{1, 0}})
+	articles.Document("northeast").SetData(embedded{"Northeast", "en", docs.Vector{0.6, 0.8}})
+	articles.Document("nord").SetData(embedded{"Nord", "fr", docs.Vector{0, 1}})
+
+	var decoded embedded
+	if err := articles.Document("northeast").Get().Doc.DataTo(&decoded); err != nil || decoded.Embedding[1] != 0.8 {
+		t.Fatalf(`Unexpected vector %v, %v`, decoded.Embedding, err)
+	}
+
+	snapshot := db.Index("articles").Where("lang", "==", "en").Nearest("embedding", docs.Vector{0, 2}, 2).Get()
+	if !snapshot.Success || len(snapshot.Docs) != 2 {
+		t.Fatalf(`Expected 2 results but got %+v`, snapshot)
+	}
+	if snapshot.Docs[0].Id != "north" || snapshot.Docs[1].Id != "northeast" {
+		t.Errorf(`Unexpected order %s, %s`, snapshot.Docs[0].Id, snapshot.Docs[1].Id)
+	}
+	if math.Abs(snapshot.Docs[0].Similarity-1) > 1e-6 || math.Abs(snapshot.Docs[1].Similarity-0.8) > 1e-6 {
+		t.Errorf(`Unexpected similarities %v, %v`, snapshot.Docs[0].Similarity, snapshot.Docs[1].Similarity)
+	}
+	if n := store.count("articles"); n != 4 {
+		t.Errorf(`Expected 4 articles but got %d`, n)
+	}
+
+	if got := docs.L2Norm.Score(docs.Vector{0, 0}, docs.Vector{3, 4}); got != 1.0/6 {
+		t.Errorf(`Unexpected L2 score %v`, got)
+	}
+}
+
+func TestNearestHybrid(t *testing.T) {
+	db := &docs.HopDoc{}
+	var body string
+	db.Use(func(req *docs.Request, next docs.Handler) (*docs.Response, error) {
+		body = string(req.Body)
+		return stub(200, `{"hits":{"hits":[]}}`)(req, next)
+	})
+	db.Index("articles").Search("compass").NearestWith(docs.VectorQuery{
+		Field: "embedding", Vector: docs.Vector{1, 0}, K: 5, Similarity: docs.DotProduct, Boost: 2,
+	}).Get()
+
+	var request struct {
+		Size  int
+		Query struct {
+			Bool struct{ Must []map[string]json.RawMessage }
+		}
+	}
+	if err := json.Unmarshal([]byte(body), &request); err != nil {
+		t.Fatalf(`Could not decode the request: %s`, err)
+	}
+	must := request.Query.Bool.Must
+	if request.Size != 5 || len(must) != 2 || must[0]["multi_match"] == nil || must[1]["script_score"] == nil {
+		t.Fatalf(`Unexpected request %s`, body)
+	}
+	if script := string(must[1]["script_score"]); !strings.Contains(script, "dotProduct(params.vector, 'embedding')") || !strings.Contains(script, `"boost":2`) {
+		t.Errorf(`Unexpected script %s`, script)
+	}
+}
+
+func TestNearestWhereDoesNotScore(t *testing.T) {
+	_, db := newIndexStore()
+	articles := db.Index("articles")
+	articles.Document("many").SetData(map[string]interface{}{"tags": "news sports weather", "embedding": docs.Vector{1, 0}})
+	articles.Document("one").SetData(map[string]interface{}{"tags": "news", "embedding": docs.Vector{0, 1}})
+
+	// Matching more of the tags must not outrank a closer vector.
+	snapshot := db.Index("articles").Where("tags", "==", "news sports weather").Nearest("embedding", docs.Vector{0, 1}, 2).Get()
+	if !snapshot.Success || len(snapshot.Docs) != 2 || snapshot.Docs[0].Id != "one" {
+		t.Errorf(`Expected the closest vector first but got %+v`, snapshot.Docs)
+	}
+}